@@ -1,11 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/pdok/sieve/pkg"
+	"github.com/pdok/sieve/pkg/fgb"
+	"github.com/pdok/sieve/pkg/geojson"
 	"github.com/pdok/sieve/pkg/gpkg"
+	"github.com/pdok/sieve/pkg/logging"
+	"github.com/pdok/sieve/pkg/metrics"
+	"github.com/pdok/sieve/pkg/mvt"
+	"github.com/pdok/sieve/pkg/shp"
 	"github.com/urfave/cli/v2"
 )
 
@@ -13,6 +23,42 @@ const SOURCE string = `source`
 const TARGET string = `target`
 const RESOLUTION string = `resolution`
 const PAGESIZE string = `pagesize`
+const POLICY string = `policy`
+const CONFIG string = `config`
+const MULTIPOLYGON string = `multipolygon`
+const FILTERPARTS string = `filter-parts`
+const VALIDATION string = `validation`
+const REJECTS string = `rejects`
+const ORIENTATION string = `orientation`
+const CRS string = `crs`
+const SCHEMASAMPLE string = `schema-sample`
+const TILEMATRIXSET string = `tile-matrix-set`
+const MINZOOM string = `min-zoom`
+const MAXZOOM string = `max-zoom`
+const INPLACE string = `in-place`
+const MODE string = `mode`
+const RENAME string = `rename`
+const COMPUTED string = `computed`
+const ANNOTATE string = `annotate`
+const FASTLOAD string = `fast-load`
+const JOURNALMODE string = `journal-mode`
+const SYNCHRONOUS string = `synchronous`
+const CACHESIZE string = `cache-size`
+const SORT string = `sort`
+const SORTBUFFER string = `sort-buffer`
+const RENUMBER string = `renumber`
+const FINALIZE string = `finalize`
+const VACUUM string = `vacuum`
+const RESUME string = `resume`
+const CHANNELBUFFER string = `channel-buffer`
+const BLOCKING string = `blocking`
+const PROGRESS string = `progress`
+const PROGRESSBAR string = `progress-bar`
+const PROGRESSJSON string = `progress-json`
+const METRICSADDR string = `metrics-addr`
+const METRICSPUSH string = `metrics-push`
+const LOGFORMAT string = `log-format`
+const LOGLEVEL string = `log-level`
 
 func main() {
 	app := cli.NewApp()
@@ -23,15 +69,15 @@ func main() {
 		&cli.StringFlag{
 			Name:     SOURCE,
 			Aliases:  []string{"s"},
-			Usage:    "Source GPKG",
+			Usage:    "Source GPKG, FlatGeobuf (.fgb), Shapefile (.shp), GeoJSON (.geojson, .geojsonl, .geojsons) or - for GeoJSON from stdin",
 			Required: true,
 			EnvVars:  []string{"SOURCE_GPKG"},
 		},
 		&cli.StringFlag{
 			Name:     TARGET,
 			Aliases:  []string{"t"},
-			Usage:    "Target GPKG",
-			Required: true,
+			Usage:    "Target GPKG, FlatGeobuf (.fgb), Shapefile (.shp), GeoJSON (.geojson, .geojsonl, .geojsons), - for GeoJSON to stdout or MBTiles (.mbtiles)",
+			Required: false,
 			EnvVars:  []string{"TARGET_GPKG"},
 		},
 		&cli.Float64Flag{
@@ -50,42 +96,565 @@ func main() {
 			Required: false,
 			EnvVars:  []string{"SIEVE_PAGESIZE"},
 		},
+		&cli.StringFlag{
+			Name:     POLICY,
+			Usage:    "Policy for features of which all parts are sieved: drop, largest or point",
+			Value:    string(pkg.PolicyDrop),
+			Required: false,
+			EnvVars:  []string{"SIEVE_POLICY"},
+		},
+		&cli.StringFlag{
+			Name:     MULTIPOLYGON,
+			Usage:    "MultiPolygon mode, sieve the parts of a MULTIPOLYGON separately (parts) or on their summed area (whole)",
+			Value:    string(pkg.MultiPolygonParts),
+			Required: false,
+			EnvVars:  []string{"SIEVE_MULTIPOLYGON"},
+		},
+		&cli.BoolFlag{
+			Name:     FILTERPARTS,
+			Usage:    "Filter parts, remove the small parts of a MULTIPOLYGON that is kept as a whole",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_FILTER_PARTS"},
+		},
+		&cli.StringFlag{
+			Name:     VALIDATION,
+			Usage:    "Validation of the sieved geometries: none, repair or reject",
+			Value:    string(pkg.ValidationNone),
+			Required: false,
+			EnvVars:  []string{"SIEVE_VALIDATION"},
+		},
+		&cli.StringFlag{
+			Name:     REJECTS,
+			Usage:    "Rejects, newline-delimited JSON file receiving the features rejected by the validation",
+			Required: false,
+			EnvVars:  []string{"SIEVE_REJECTS"},
+		},
+		&cli.StringFlag{
+			Name:     ORIENTATION,
+			Usage:    "Orientation of the rings on output: none, mvt, ogc or rfc7946",
+			Value:    string(pkg.OrientationNone),
+			Required: false,
+			EnvVars:  []string{"SIEVE_ORIENTATION"},
+		},
+		&cli.StringFlag{
+			Name:     COMPUTED,
+			Usage:    "Computed columns appended to the target: original_area, sieved_area, removed_holes, removed_parts, min_resolution and/or max_resolution, comma separated",
+			Required: false,
+			EnvVars:  []string{"SIEVE_COMPUTED"},
+		},
+		&cli.BoolFlag{
+			Name:     ANNOTATE,
+			Usage:    "Annotate, keep all features and write the largest resolution at which they survive to the indexed max_resolution column",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_ANNOTATE"},
+		},
+		&cli.StringFlag{
+			Name:     SORT,
+			Usage:    "Sort the features of every table by the Hilbert or Z-order key of the centroid of their envelope: none, hilbert or z-order",
+			Value:    string(pkg.CurveNone),
+			Required: false,
+			EnvVars:  []string{"SIEVE_SORT"},
+		},
+		&cli.IntFlag{
+			Name:     SORTBUFFER,
+			Usage:    "Sort buffer, how many features are sorted in memory, the features of larger tables are spilled to disk",
+			Value:    100000,
+			Required: false,
+			EnvVars:  []string{"SIEVE_SORT_BUFFER"},
+		},
+		&cli.BoolFlag{
+			Name:     RENUMBER,
+			Usage:    "Renumber the primary key of the sorted features, starting at 1",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_RENUMBER"},
+		},
+		&cli.StringFlag{
+			Name:     CRS,
+			Usage:    "CRS of a GeoJSON source or a Shapefile source without EPSG code in the .prj, like EPSG:28992",
+			Value:    `EPSG:4326`,
+			Required: false,
+			EnvVars:  []string{"SIEVE_CRS"},
+		},
+		&cli.IntFlag{
+			Name:     SCHEMASAMPLE,
+			Usage:    "Schema sample, from how many features the columns of a GeoJSON source are inferred, the other features are streamed",
+			Value:    1000,
+			Required: false,
+			EnvVars:  []string{"SIEVE_SCHEMA_SAMPLE"},
+		},
+		&cli.StringFlag{
+			Name:     MODE,
+			Usage:    "Mode for tables that exist in the target GPKG: create (fail), overwrite, append or upsert",
+			Value:    string(gpkg.ModeCreate),
+			Required: false,
+			EnvVars:  []string{"SIEVE_MODE"},
+		},
+		&cli.StringFlag{
+			Name:     RENAME,
+			Usage:    "Rename template for the target tables, {table} is the source table and {resolution} the resolution, like {table}_sieved_{resolution}",
+			Required: false,
+			EnvVars:  []string{"SIEVE_RENAME"},
+		},
+		&cli.BoolFlag{
+			Name:     FASTLOAD,
+			Usage:    "Fast load, write the target GPKG tables without RTree triggers, the RTree is populated and the triggers installed afterwards",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_FAST_LOAD"},
+		},
+		&cli.StringFlag{
+			Name:     JOURNALMODE,
+			Usage:    "Journal mode of the target GPKG during a fast load: DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF",
+			Value:    `MEMORY`,
+			Required: false,
+			EnvVars:  []string{"SIEVE_JOURNAL_MODE"},
+		},
+		&cli.StringFlag{
+			Name:     SYNCHRONOUS,
+			Usage:    "Synchronous setting of the target GPKG during a fast load: OFF, NORMAL, FULL or EXTRA",
+			Value:    `OFF`,
+			Required: false,
+			EnvVars:  []string{"SIEVE_SYNCHRONOUS"},
+		},
+		&cli.IntFlag{
+			Name:     CACHESIZE,
+			Usage:    "Cache size of the target GPKG during a fast load, in pages or in KiB when negative",
+			Value:    -65536,
+			Required: false,
+			EnvVars:  []string{"SIEVE_CACHE_SIZE"},
+		},
+		&cli.BoolFlag{
+			Name:     FINALIZE,
+			Usage:    "Finalize, run ANALYZE on the target GPKG and check its integrity and GeoPackage conformance, violations fail the run",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_FINALIZE"},
+		},
+		&cli.BoolFlag{
+			Name:     VACUUM,
+			Usage:    "Vacuum the target GPKG when it is finalized",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_VACUUM"},
+		},
+		&cli.BoolFlag{
+			Name:     RESUME,
+			Usage:    "Resume an interrupted run on the target GPKG, or the source GPKG sieved in place, completed tables are skipped and the others continue after the last written feature",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_RESUME"},
+		},
+		&cli.IntFlag{
+			Name:     CHANNELBUFFER,
+			Usage:    "Channel buffer, how many features are buffered between the stages of the pipeline, 0 for unbuffered channels",
+			Value:    0,
+			Required: false,
+			EnvVars:  []string{"SIEVE_CHANNEL_BUFFER"},
+		},
+		&cli.BoolFlag{
+			Name:     BLOCKING,
+			Usage:    "Log the time every stage of the pipeline was blocked, for tuning the page size and channel buffer",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_BLOCKING"},
+		},
+		&cli.DurationFlag{
+			Name:     PROGRESS,
+			Usage:    "Progress interval, how often the progress of a table is reported, 0 for no progress",
+			Value:    0,
+			Required: false,
+			EnvVars:  []string{"SIEVE_PROGRESS"},
+		},
+		&cli.BoolFlag{
+			Name:     PROGRESSBAR,
+			Usage:    "Draw the progress as a progress bar when standard error is a terminal",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_PROGRESS_BAR"},
+		},
+		&cli.StringFlag{
+			Name:     PROGRESSJSON,
+			Usage:    "Progress JSON, the file the progress reports are written to as JSON lines",
+			Required: false,
+			EnvVars:  []string{"SIEVE_PROGRESS_JSON"},
+		},
+		&cli.StringFlag{
+			Name:     METRICSADDR,
+			Usage:    "Metrics address, like :9090, the Prometheus metrics are exposed on /metrics while sieving",
+			Required: false,
+			EnvVars:  []string{"SIEVE_METRICS_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:     METRICSPUSH,
+			Usage:    "Metrics push URL, the Pushgateway the Prometheus metrics are pushed to when done",
+			Required: false,
+			EnvVars:  []string{"SIEVE_METRICS_PUSH"},
+		},
+		&cli.StringFlag{
+			Name:     LOGFORMAT,
+			Usage:    "Log format: text, json or logfmt, the table, stage and counts are fields of the records",
+			Value:    "text",
+			Required: false,
+			EnvVars:  []string{"SIEVE_LOG_FORMAT"},
+		},
+		&cli.StringFlag{
+			Name:     LOGLEVEL,
+			Usage:    "Log level: debug, info, warn or error, debug explains why every feature, part or ring is removed",
+			Value:    "info",
+			Required: false,
+			EnvVars:  []string{"SIEVE_LOG_LEVEL"},
+		},
+		&cli.BoolFlag{
+			Name:     INPLACE,
+			Usage:    "In place, sieve the source GPKG itself by deleting and updating its features, no target is written",
+			Value:    false,
+			Required: false,
+			EnvVars:  []string{"SIEVE_IN_PLACE"},
+		},
+		&cli.StringFlag{
+			Name:     TILEMATRIXSET,
+			Usage:    "Tile matrix set of the vector tiles written to a GPKG or MBTiles target: WebMercatorQuad or NetherlandsRDNewQuad",
+			Required: false,
+			EnvVars:  []string{"SIEVE_TILE_MATRIX_SET"},
+		},
+		&cli.IntFlag{
+			Name:     MINZOOM,
+			Usage:    "Min zoom, the first zoom level of the vector tiles",
+			Value:    0,
+			Required: false,
+			EnvVars:  []string{"SIEVE_MIN_ZOOM"},
+		},
+		&cli.IntFlag{
+			Name:     MAXZOOM,
+			Usage:    "Max zoom, the last zoom level of the vector tiles",
+			Value:    14,
+			Required: false,
+			EnvVars:  []string{"SIEVE_MAX_ZOOM"},
+		},
+		&cli.StringFlag{
+			Name:     CONFIG,
+			Aliases:  []string{"c"},
+			Usage:    "Config, JSON file with table specific settings",
+			Required: false,
+			EnvVars:  []string{"SIEVE_CONFIG"},
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
 
-		_, err := os.Stat(c.String(SOURCE))
-		if os.IsNotExist(err) {
-			log.Fatalf("error opening source GeoPackage: %s", err)
+		logFormat, err := logging.ParseFormat(c.String(LOGFORMAT))
+		if err != nil {
+			log.Fatalf("error parsing the log format: %s", err)
+		}
+		logLevel, err := logging.ParseLevel(c.String(LOGLEVEL))
+		if err != nil {
+			log.Fatalf("error parsing the log level: %s", err)
+		}
+		logging.Setup(os.Stderr, logFormat, logLevel)
+
+		if c.String(SOURCE) != stdio {
+			_, err := os.Stat(c.String(SOURCE))
+			if os.IsNotExist(err) {
+				log.Fatalf("error opening source: %s", err)
+			}
 		}
 
-		source := gpkg.SourceGeopackage{}
-		source.Init(c.String(SOURCE))
+		crs, err := geojson.ParseCRS(c.String(CRS))
+		if err != nil {
+			log.Fatalf("error parsing the crs: %s", err)
+		}
+		if c.Int(SCHEMASAMPLE) < 1 {
+			log.Fatalf("error parsing the schema sample: %d is less than 1", c.Int(SCHEMASAMPLE))
+		}
+		policy, err := pkg.ParsePolicy(c.String(POLICY))
+		if err != nil {
+			log.Fatalf("error parsing the policy: %s", err)
+		}
+		mode, err := pkg.ParseMultiPolygonMode(c.String(MULTIPOLYGON))
+		if err != nil {
+			log.Fatalf("error parsing the multipolygon mode: %s", err)
+		}
+		validation, err := pkg.ParseValidation(c.String(VALIDATION))
+		if err != nil {
+			log.Fatalf("error parsing the validation: %s", err)
+		}
+		orientation, err := pkg.ParseOrientation(c.String(ORIENTATION))
+		if err != nil {
+			log.Fatalf("error parsing the orientation: %s", err)
+		}
+		computed, err := pkg.ParseComputed(c.String(COMPUTED))
+		if err != nil {
+			log.Fatalf("error parsing the computed columns: %s", err)
+		}
+		if c.Bool(ANNOTATE) {
+			computed = pkg.Annotated(computed)
+		}
+		curve, err := pkg.ParseCurve(c.String(SORT))
+		if err != nil {
+			log.Fatalf("error parsing the sort: %s", err)
+		}
+		if curve != pkg.CurveNone && c.Bool(INPLACE) {
+			log.Fatalf("error parsing the sort: features can't be sorted in place")
+		}
+		if c.Bool(RENUMBER) && (curve == pkg.CurveNone || c.String(MODE) == string(gpkg.ModeAppend) || c.String(MODE) == string(gpkg.ModeUpsert)) {
+			log.Fatalf("error parsing the sort: renumbering needs a sort and can't be combined with append or upsert")
+		}
+		defaults := pkg.Options{
+			Resolution:       c.Float64(RESOLUTION),
+			Policy:           policy,
+			MultiPolygonMode: mode,
+			FilterParts:      c.Bool(FILTERPARTS),
+			Validation:       validation,
+			KeepRejected:     c.Bool(INPLACE),
+			Orientation:      orientation,
+			Computed:         computed,
+			Annotate:         c.Bool(ANNOTATE),
+			Sort:             pkg.Sort{Curve: curve, Buffer: c.Int(SORTBUFFER), Renumber: c.Bool(RENUMBER)},
+			ChannelBuffer:    c.Int(CHANNELBUFFER),
+		}
+		if c.Int(CHANNELBUFFER) < 0 {
+			log.Fatalf("error parsing the channel buffer: %d is negative", c.Int(CHANNELBUFFER))
+		}
+		if c.Int(SORTBUFFER) < 1 {
+			log.Fatalf("error parsing the sort buffer: %d is less than 1", c.Int(SORTBUFFER))
+		}
+		if c.Bool(BLOCKING) {
+			defaults.Blocking = &pkg.Blocking{}
+		}
+		if c.Duration(PROGRESS) < 0 {
+			log.Fatalf("error parsing the progress interval: %s is negative", c.Duration(PROGRESS))
+		}
+		if (c.Bool(PROGRESSBAR) || c.String(PROGRESSJSON) != ``) && c.Duration(PROGRESS) == 0 {
+			log.Fatalf("error reporting the progress: the progress bar and JSON need a progress interval")
+		}
+		if c.Duration(PROGRESS) > 0 {
+			defaults.Progress = &pkg.Progress{Interval: c.Duration(PROGRESS), Bar: c.Bool(PROGRESSBAR)}
+			if c.String(PROGRESSJSON) != `` {
+				f, err := os.Create(c.String(PROGRESSJSON))
+				if err != nil {
+					log.Fatalf("error creating the progress JSON: %s", err)
+				}
+				defer f.Close()
+				defaults.Progress.JSON = f
+			}
+		}
+
+		if c.String(REJECTS) != `` {
+			rejects := &pkg.Rejects{}
+			rejects.Init(c.String(REJECTS), c.Bool(RESUME))
+			defer rejects.Close()
+			defaults.Rejects = rejects
+		}
+
+		var config pkg.Config
+		if c.String(CONFIG) != `` {
+			config, err = pkg.ReadConfig(c.String(CONFIG))
+			if err != nil {
+				log.Fatalf("error reading the config: %s", err)
+			}
+		}
+
+		if c.String(METRICSADDR) != `` {
+			server := metrics.Serve(c.String(METRICSADDR))
+			defer server.Close()
+		}
+
+		source := openSource(c.String(SOURCE), crs, c.Int(SCHEMASAMPLE))
 		defer source.Close()
 
-		target := gpkg.TargetGeopackage{}
-		target.Init(c.String(TARGET), c.Int(PAGESIZE))
+		if c.Bool(INPLACE) {
+			if !isGeopackage(c.String(SOURCE)) {
+				log.Fatalf("error sieving in place: %s is not a GeoPackage", c.String(SOURCE))
+			}
+		} else if c.String(TARGET) == `` {
+			log.Fatalf("error opening target: a target is required when not sieving in place")
+		}
+
+		targetMode, err := gpkg.ParseMode(c.String(MODE))
+		if err != nil {
+			log.Fatalf("error parsing the mode: %s", err)
+		}
+		if targetMode != gpkg.ModeCreate && (c.Bool(INPLACE) || c.String(TILEMATRIXSET) != `` || !isGeopackage(c.String(TARGET))) {
+			log.Fatalf("error parsing the mode: %s is only supported for a GeoPackage target", targetMode)
+		}
+
+		if (c.Bool(FINALIZE) || c.Bool(VACUUM)) && !c.Bool(INPLACE) && (c.String(TILEMATRIXSET) != `` || !isGeopackage(c.String(TARGET))) {
+			log.Fatalf("error finalizing: only supported for a GeoPackage target")
+		}
+
+		if c.Bool(RESUME) {
+			if !c.Bool(INPLACE) && (c.String(TILEMATRIXSET) != `` || !isGeopackage(c.String(TARGET))) || !isGeopackage(c.String(SOURCE)) {
+				log.Fatalf("error resuming: only supported for a GeoPackage source and target")
+			}
+			if curve != pkg.CurveNone {
+				log.Fatalf("error resuming: sorted features can't be resumed")
+			}
+		}
+
+		var fastLoad *gpkg.FastLoad
+		if c.Bool(FASTLOAD) {
+			if c.Bool(INPLACE) || c.String(TILEMATRIXSET) != `` || !isGeopackage(c.String(TARGET)) {
+				log.Fatalf("error fast loading: only supported for a GeoPackage target")
+			}
+			journalMode, err := gpkg.ParseJournalMode(c.String(JOURNALMODE))
+			if err != nil {
+				log.Fatalf("error parsing the journal mode: %s", err)
+			}
+			synchronous, err := gpkg.ParseSynchronous(c.String(SYNCHRONOUS))
+			if err != nil {
+				log.Fatalf("error parsing the synchronous setting: %s", err)
+			}
+			fastLoad = &gpkg.FastLoad{JournalMode: journalMode, Synchronous: synchronous, CacheSize: c.Int(CACHESIZE)}
+		}
+
+		rename, err := pkg.ParseRenameTemplate(c.String(RENAME))
+		if err != nil {
+			log.Fatalf("error parsing the rename template: %s", err)
+		}
+		if rename != `` && c.Bool(INPLACE) {
+			log.Fatalf("error parsing the rename template: tables can't be renamed in place")
+		}
+
+		var tiles *mvt.TargetTiles
+		var target pkg.TargetDataset
+		if c.Bool(INPLACE) {
+			inPlace := &gpkg.TargetGeopackage{}
+			inPlace.InitInPlace(c.String(SOURCE), c.Int(PAGESIZE))
+			if c.Bool(RESUME) {
+				inPlace.InitResume()
+			}
+			target = inPlace
+		} else if c.String(TILEMATRIXSET) != `` || isMBTiles(c.String(TARGET)) {
+			if c.Bool(ANNOTATE) {
+				log.Fatalf("error annotating: vector tiles are sieved for every zoom level")
+			}
+			if c.String(SOURCE) == stdio {
+				log.Fatalf("error opening source: vector tiles read the source for every zoom level, which stdin can't")
+			}
+			tms, err := mvt.ParseTileMatrixSet(c.String(TILEMATRIXSET))
+			if err != nil {
+				log.Fatalf("error parsing the tile matrix set: %s", err)
+			}
+			tiles = &mvt.TargetTiles{}
+			tiles.Init(c.String(TARGET), tms, c.Int(MINZOOM), c.Int(MAXZOOM))
+			target = tiles
+		} else {
+			target = openTarget(c.String(TARGET), c.Int(PAGESIZE), targetMode, fastLoad, c.Bool(RESUME))
+		}
 		defer target.Close()
 
 		tables := source.GetTableInfo()
+		projections := make([]*pkg.Projection, len(tables))
+		projectedTables := make([]pkg.Table, len(tables))
+		for i, table := range tables {
+			projections[i], projectedTables[i], err = config.Project(table)
+			if err != nil {
+				log.Fatalf("error selecting the columns: %s", err)
+			}
+			if projections[i] != nil && c.Bool(INPLACE) {
+				log.Fatalf("error selecting the columns: columns can't be selected in place")
+			}
+			projectedTables[i], err = pkg.AddComputed(projectedTables[i], computed)
+			if err != nil {
+				log.Fatalf("error adding the computed columns: %s", err)
+			}
+			if len(computed) > 0 && c.Bool(INPLACE) {
+				log.Fatalf("error adding the computed columns: columns can't be added in place")
+			}
+		}
+		targetTables, err := rename.Rename(projectedTables, func(table string) float64 {
+			return config.Options(table, defaults).Resolution
+		})
+		if err != nil {
+			log.Fatalf("error renaming the tables: %s", err)
+		}
 
-		err = target.CreateTables(tables)
+		err = target.CreateTables(targetTables)
 		if err != nil {
-			log.Fatalf("error initialization the target GeoPackage: %s", err)
+			log.Fatalf("error initialization the target: %s", err)
 		}
 
-		log.Println("=== start sieving ===")
+		slog.Info(`start sieving`)
 
 		// Process the tables sequential
-		for _, table := range tables {
-			log.Printf("  sieving %s", table.Name)
-			source.Table = table
-			target.Table = table
-			pkg.Sieve(source, target, c.Float64(RESOLUTION))
-			log.Printf("  finised %s", table.Name)
+		for i, table := range tables {
+			slog.Info(`sieving`, `table`, table.Name)
+			if targetTables[i].Name != table.Name {
+				slog.Info(`writing`, `table`, table.Name, `target`, targetTables[i].Name)
+			}
+			source.SetTable(table)
+			target.SetTable(targetTables[i])
+			if c.Bool(RESUME) {
+				state := target.(*gpkg.TargetGeopackage).State(targetTables[i].Name)
+				if state.Completed {
+					slog.Info(`skipping completed table`, `table`, table.Name)
+					continue
+				}
+				if state.LastFID != nil {
+					slog.Info(`resuming`, `table`, table.Name, `after_fid`, *state.LastFID)
+					source.(*gpkg.SourceGeopackage).ResumeAfter(*state.LastFID)
+				}
+			}
+			if defaults.Rejects != nil {
+				defaults.Rejects.Table = table.Name
+			}
+			options := config.Options(table.Name, defaults)
+			options.Table = table.Name
+			options.Projection = projections[i]
+			if options.PrimaryKey, err = pkg.PrimaryKeyPosition(table); err != nil {
+				options.PrimaryKey = -1
+			}
+			if options.Sort.Renumber {
+				options.Sort.PrimaryKey, err = pkg.PrimaryKeyPosition(targetTables[i])
+				if err != nil {
+					log.Fatalf("error renumbering: %s", err)
+				}
+			}
+			total := int64(-1)
+			if counter, ok := source.(pkg.Counter); ok && options.Progress != nil {
+				if count, ok := counter.Count(); ok {
+					total = count
+				}
+			}
+			if tiles != nil {
+				// every zoom level is sieved with the resolution of its pixels
+				for zoom := tiles.MinZoom; zoom <= tiles.MaxZoom; zoom++ {
+					slog.Info(`zoom level`, `table`, table.Name, `zoom`, zoom)
+					tiles.SetZoom(zoom)
+					options.Resolution = tiles.TileMatrixSet.Resolution(zoom)
+					options.Zoom = &zoom
+					if defaults.Rejects != nil {
+						defaults.Rejects.Zoom = &zoom
+					}
+					sieve(source, target, options, fmt.Sprintf("%s zoom %d", table.Name, zoom), total)
+				}
+			} else {
+				sieve(source, target, options, table.Name, total)
+			}
+			slog.Info(`finished`, `table`, table.Name)
+		}
+
+		slog.Info(`done sieving`)
+		if defaults.Blocking != nil {
+			defaults.Blocking.Log()
 		}
 
-		log.Println("=== done sieving ===")
+		if c.Bool(FINALIZE) || c.Bool(VACUUM) {
+			slog.Info(`start finalizing`)
+			if err = target.(*gpkg.TargetGeopackage).Finalize(targetTables, c.Bool(VACUUM)); err != nil {
+				log.Fatalf("error finalizing the target: %s", err)
+			}
+			slog.Info(`done finalizing`)
+		}
+
+		if c.String(METRICSPUSH) != `` {
+			if err = metrics.Push(c.String(METRICSPUSH), `sieve`); err != nil {
+				log.Fatalf("error pushing the metrics: %s", err)
+			}
+		}
 		return nil
 	}
 
@@ -94,3 +663,109 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// stdio is given as source or target to read GeoJSON from stdin or write it to stdout
+const stdio = `-`
+
+// isFlatGeobuf determines the format of a file by its extension
+func isFlatGeobuf(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), `.fgb`)
+}
+
+// isShapefile determines the format of a file by its extension
+func isShapefile(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), `.shp`)
+}
+
+// isMBTiles determines the format of a file by its extension
+func isMBTiles(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), `.mbtiles`)
+}
+
+// isGeoJSON determines the format of a file by its extension
+func isGeoJSON(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case `.geojson`, `.geojsonl`, `.geojsons`:
+		return true
+	}
+	return file == stdio
+}
+
+// isGeopackage determines if a file is a GeoPackage, which is the format of the files with
+// an extension not used by the other formats
+func isGeopackage(file string) bool {
+	return !isGeoJSON(file) && !isShapefile(file) && !isFlatGeobuf(file) && !isMBTiles(file)
+}
+
+func openSource(file string, crs pkg.SpatialReferenceSystem, sample int) pkg.SourceDataset {
+	if isGeoJSON(file) {
+		source := &geojson.SourceGeoJSON{}
+		if file == stdio {
+			source.Init(os.Stdin, `stdin`, crs, sample)
+			return source
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			log.Fatalf("error opening source: %s", err)
+		}
+		source.Init(f, strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), crs, sample)
+		return source
+	}
+	if isShapefile(file) {
+		source := &shp.SourceShapefile{}
+		source.Init(file, crs)
+		return source
+	}
+	if isFlatGeobuf(file) {
+		source := &fgb.SourceFlatGeobuf{}
+		source.Init(file)
+		return source
+	}
+	source := &gpkg.SourceGeopackage{}
+	source.Init(file)
+	return source
+}
+
+// sieve sieves the current table, reporting the progress when enabled
+func sieve(source pkg.SourceDataset, target pkg.TargetDataset, options pkg.Options, name string, total int64) {
+	if options.Progress != nil {
+		options.Progress.Start(name, total)
+		defer options.Progress.Stop()
+	}
+	pkg.Sieve(source, target, options)
+}
+
+func openTarget(file string, pagesize int, mode gpkg.Mode, fastLoad *gpkg.FastLoad, resume bool) pkg.TargetDataset {
+	if isGeoJSON(file) {
+		target := &geojson.TargetGeoJSON{}
+		if file == stdio {
+			target.Init(os.Stdout, nil)
+			return target
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			log.Fatalf("error creating target: %s", err)
+		}
+		target.Init(f, f)
+		return target
+	}
+	if isShapefile(file) {
+		target := &shp.TargetShapefile{}
+		target.Init(file)
+		return target
+	}
+	if isFlatGeobuf(file) {
+		target := &fgb.TargetFlatGeobuf{}
+		target.Init(file)
+		return target
+	}
+	target := &gpkg.TargetGeopackage{}
+	target.Init(file, pagesize, mode)
+	if fastLoad != nil {
+		target.InitFastLoad(*fastLoad)
+	}
+	if resume {
+		target.InitResume()
+	}
+	return target
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Blocking accumulates the time the stages of Sieve were blocked on the channels between them, over
+// all tables. The source and the target are no stages themselves: a slow source shows as the sieve
+// stage waiting to receive, a slow target as the last stage waiting to send.
+type Blocking struct {
+	mu     sync.Mutex
+	stages []StageBlocking
+}
+
+// StageBlocking is the time a stage waited to receive a feature from the previous stage and
+// to send a feature to the next stage
+type StageBlocking struct {
+	Stage     string
+	Receiving time.Duration
+	Sending   time.Duration
+}
+
+// add adds the blocking of a stage, the stages are kept in the order they are first added
+func (b *Blocking) add(s StageBlocking) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := range b.stages {
+		if b.stages[i].Stage == s.Stage {
+			b.stages[i].Receiving += s.Receiving
+			b.stages[i].Sending += s.Sending
+			return
+		}
+	}
+	b.stages = append(b.stages, s)
+}
+
+// Stages returns the accumulated blocking of every stage
+func (b *Blocking) Stages() []StageBlocking {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]StageBlocking{}, b.stages...)
+}
+
+// Log logs the blocking of every stage
+func (b *Blocking) Log() {
+	logBlocking(``, b.Stages())
+}
+
+// logBlocking logs the blocking of every stage of the table, without a table it is the total
+func logBlocking(table string, stages []StageBlocking) {
+	for _, s := range stages {
+		var attrs []any
+		if table != `` {
+			attrs = append(attrs, `table`, table)
+		}
+		attrs = append(attrs, `stage`, s.Stage, `receiving_seconds`, s.Receiving.Seconds(), `sending_seconds`, s.Sending.Seconds())
+		slog.Info(`blocked`, attrs...)
+	}
+}
+
+// receive receives a feature from the channel, the time is only measured when it has to wait
+func (s *StageBlocking) receive(in chan Feature) (Feature, bool) {
+	select {
+	case feature, hasMore := <-in:
+		return feature, hasMore
+	default:
+	}
+	start := time.Now()
+	feature, hasMore := <-in
+	s.Receiving += time.Since(start)
+	return feature, hasMore
+}
+
+// send sends a feature to the channel, the time is only measured when it has to wait
+func (s *StageBlocking) send(out chan Feature, feature Feature) {
+	select {
+	case out <- feature:
+		return
+	default:
+	}
+	start := time.Now()
+	out <- feature
+	s.Sending += time.Since(start)
+}
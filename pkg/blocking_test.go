@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-spatial/geom"
+)
+
+type testSource struct {
+	count int
+}
+
+func (s testSource) ReadFeatures(preSieve chan Feature) {
+	for i := 0; i < s.count; i++ {
+		preSieve <- &testFeature{columns: []interface{}{int64(i)}, geometry: geom.Point{0, 0}}
+	}
+	close(preSieve)
+}
+
+// testTarget is a target that takes delay for every feature
+type testTarget struct {
+	delay   time.Duration
+	written *int
+}
+
+func (t testTarget) WriteFeatures(postSieve chan Feature) {
+	for range postSieve {
+		time.Sleep(t.delay)
+		*t.written++
+	}
+}
+
+func TestBlocking(t *testing.T) {
+	var tests = []struct {
+		buffer int
+		// blocked is whether the stages are expected to wait for the slow target
+		blocked bool
+	}{
+		// 0
+		{buffer: 0, blocked: true},
+		// 1
+		{buffer: 10, blocked: false},
+	}
+
+	for k, test := range tests {
+		blocking := &Blocking{}
+		written := 0
+		options := Options{ChannelBuffer: test.buffer, Blocking: blocking, Orientation: OrientationOGC}
+		// two tables, the blocking of the stages is accumulated
+		for i := 0; i < 2; i++ {
+			Sieve(testSource{count: 5}, testTarget{delay: 5 * time.Millisecond, written: &written}, options)
+		}
+
+		if written != 10 {
+			t.Errorf("test: %d, expected: 10 features \ngot: %d", k, written)
+		}
+		stages := blocking.Stages()
+		if len(stages) != 2 || stages[0].Stage != `sieve` || stages[1].Stage != `orient` {
+			t.Fatalf("test: %d, expected: the sieve and orient stages \ngot: %v", k, stages)
+		}
+		// the target takes 50ms in total, without buffers the stages wait for it
+		for _, stage := range stages {
+			if blocked := stage.Sending > 10*time.Millisecond; blocked != test.blocked {
+				t.Errorf("test: %d, expected %s blocked: %v \ngot: %v", k, stage.Stage, test.blocked, stage.Sending)
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-spatial/geom"
+)
+
+// Computed is a column computed while sieving and appended to the columns of the target
+type Computed string
+
+const (
+	// ComputedOriginalArea is the area of the (MULTI)POLYGON before sieving
+	ComputedOriginalArea Computed = `original_area`
+	// ComputedSievedArea is the area of the (MULTI)POLYGON after sieving
+	ComputedSievedArea Computed = `sieved_area`
+	// ComputedRemovedHoles is the number of interior rings removed by the sieve
+	ComputedRemovedHoles Computed = `removed_holes`
+	// ComputedRemovedParts is the number of POLYGONs removed by the sieve
+	ComputedRemovedParts Computed = `removed_parts`
+	// ComputedMinResolution is the smallest resolution at which the feature is sieved, the
+	// min zoom of the feature is the first zoom level with a smaller resolution
+	ComputedMinResolution Computed = `min_resolution`
+	// ComputedMaxResolution is the largest resolution at which the feature survives the sieve,
+	// it has the same value as ComputedMinResolution but is indexed for filtering on request
+	ComputedMaxResolution Computed = `max_resolution`
+)
+
+// ParseComputed validates the given comma separated list of computed columns, every column can be given once
+func ParseComputed(computed string) ([]Computed, error) {
+	var columns []Computed
+	if computed == `` {
+		return columns, nil
+	}
+	seen := make(map[Computed]bool)
+	for _, c := range strings.Split(computed, `,`) {
+		column := Computed(strings.TrimSpace(c))
+		switch column {
+		case ComputedOriginalArea, ComputedSievedArea, ComputedRemovedHoles, ComputedRemovedParts, ComputedMinResolution,
+			ComputedMaxResolution:
+			if seen[column] {
+				return nil, fmt.Errorf("computed column %s is given more than once", column)
+			}
+			seen[column] = true
+			columns = append(columns, column)
+		default:
+			return nil, fmt.Errorf("unknown computed column: %s", c)
+		}
+	}
+	return columns, nil
+}
+
+// column returns the column definition of the computed column
+func (c Computed) column() Column {
+	if c == ComputedRemovedHoles || c == ComputedRemovedParts {
+		return Column{Name: string(c), Type: `INTEGER`}
+	}
+	return Column{Name: string(c), Type: `REAL`, Indexed: c == ComputedMaxResolution}
+}
+
+// Annotated returns the computed columns including ComputedMaxResolution, which is
+// written when all features are kept by Options.Annotate
+func Annotated(computed []Computed) []Computed {
+	for _, c := range computed {
+		if c == ComputedMaxResolution {
+			return computed
+		}
+	}
+	return append(computed, ComputedMaxResolution)
+}
+
+// AddComputed adds the computed columns to the table, they can't have the name of an existing column
+func AddComputed(table Table, computed []Computed) (Table, error) {
+	t := table
+	t.Columns = append([]Column{}, table.Columns...)
+	for _, c := range computed {
+		for _, column := range table.Columns {
+			if strings.EqualFold(column.Name, string(c)) {
+				return table, fmt.Errorf("computed column %s already exists in table %s", c, table.Name)
+			}
+		}
+		t.Columns = append(t.Columns, c.column())
+	}
+	return t, nil
+}
+
+type computedFeature struct {
+	Feature
+	values []interface{}
+}
+
+func (f computedFeature) Columns() []interface{} {
+	columns := f.Feature.Columns()
+	return append(columns[:len(columns):len(columns)], f.values...)
+}
+
+// polygonStats are the measures of the (MULTI)POLYGONs of a geometry
+type polygonStats struct {
+	polygons bool
+	area     float64
+	parts    int
+	holes    int
+}
+
+func measure(geometry geom.Geometry) polygonStats {
+	var stats polygonStats
+	var add func(geometry geom.Geometry)
+	add = func(geometry geom.Geometry) {
+		switch g := geometry.(type) {
+		case geom.Polygon:
+			stats.polygons = true
+			stats.area = stats.area + area(g)
+			stats.parts++
+			if len(g) > 1 {
+				stats.holes = stats.holes + len(g) - 1
+			}
+		case geom.MultiPolygon:
+			stats.polygons = true
+			for _, p := range g {
+				add(geom.Polygon(p))
+			}
+		case geom.Collection:
+			for _, member := range g {
+				add(member)
+			}
+		}
+	}
+	add(geometry)
+	return stats
+}
+
+// sieveResolution returns the smallest resolution at which the geometry is sieved completely,
+// ok is false for geometries that are never sieved completely
+func sieveResolution(geometry geom.Geometry, mode MultiPolygonMode) (resolution float64, ok bool) {
+	switch g := geometry.(type) {
+	case geom.Polygon:
+		return math.Sqrt(area(g)), true
+	case geom.MultiPolygon:
+		if mode == MultiPolygonWhole {
+			total := 0.
+			for _, p := range g {
+				total = total + area(p)
+			}
+			return math.Sqrt(total), true
+		}
+		for _, p := range g {
+			resolution = math.Max(resolution, math.Sqrt(area(p)))
+		}
+		return resolution, true
+	case geom.Collection:
+		for _, member := range g {
+			r, ok := sieveResolution(member, mode)
+			if !ok {
+				return 0, false
+			}
+			resolution = math.Max(resolution, r)
+		}
+		return resolution, true
+	}
+	return 0, false
+}
+
+// withComputed appends the computed columns to the feature, based on the geometry before
+// and after sieving. The values are NULL for features without (MULTI)POLYGONs.
+func withComputed(feature Feature, original geom.Geometry, options Options) Feature {
+	before := measure(original)
+	after := measure(feature.Geometry())
+	values := make([]interface{}, len(options.Computed))
+	if !before.polygons {
+		return &computedFeature{feature, values}
+	}
+	for i, c := range options.Computed {
+		switch c {
+		case ComputedOriginalArea:
+			values[i] = before.area
+		case ComputedSievedArea:
+			values[i] = after.area
+		case ComputedRemovedHoles:
+			values[i] = int64(before.holes - after.holes)
+		case ComputedRemovedParts:
+			values[i] = int64(before.parts - after.parts)
+		case ComputedMinResolution, ComputedMaxResolution:
+			if r, ok := sieveResolution(original, options.MultiPolygonMode); ok {
+				values[i] = r
+			}
+		}
+	}
+	return &computedFeature{feature, values}
+}
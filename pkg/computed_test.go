@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+)
+
+func TestWithComputed(t *testing.T) {
+	computed := []Computed{ComputedOriginalArea, ComputedSievedArea, ComputedRemovedHoles, ComputedRemovedParts, ComputedMinResolution}
+	square := func(x, y, size float64) [][2]float64 {
+		return [][2]float64{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}}
+	}
+
+	var tests = []struct {
+		original geom.Geometry
+		sieved   geom.Geometry
+		mode     MultiPolygonMode
+		expected []interface{}
+	}{
+		// 0
+		{original: geom.Polygon{square(0, 0, 10), square(1, 1, 1)}, sieved: geom.Polygon{square(0, 0, 10)},
+			expected: []interface{}{99.0, 100.0, int64(1), int64(0), 9.9498743710662}},
+		// 1 the largest part determines when the feature is sieved
+		{original: geom.MultiPolygon{{square(0, 0, 4)}, {square(10, 10, 1)}}, sieved: geom.MultiPolygon{{square(0, 0, 4)}},
+			expected: []interface{}{17.0, 16.0, int64(0), int64(1), 4.0}},
+		// 2 or the summed area of the parts
+		{original: geom.MultiPolygon{{square(0, 0, 4)}, {square(10, 10, 3)}}, sieved: geom.MultiPolygon{{square(0, 0, 4)}, {square(10, 10, 3)}},
+			mode: MultiPolygonWhole, expected: []interface{}{25.0, 25.0, int64(0), int64(0), 5.0}},
+		// 3 a collection with other members is never sieved completely
+		{original: geom.Collection{geom.Polygon{square(0, 0, 2)}, geom.Point{1, 1}}, sieved: geom.Collection{geom.Point{1, 1}},
+			expected: []interface{}{4.0, 0.0, int64(0), int64(1), nil}},
+		// 4
+		{original: geom.LineString{{0, 0}, {1, 1}}, sieved: geom.LineString{{0, 0}, {1, 1}},
+			expected: []interface{}{nil, nil, nil, nil, nil}},
+	}
+
+	for k, test := range tests {
+		f := &testFeature{columns: []interface{}{`a`}, geometry: test.sieved}
+		got := withComputed(f, test.original, Options{MultiPolygonMode: test.mode, Computed: computed}).Columns()
+		expected := append([]interface{}{`a`}, test.expected...)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected, got)
+		}
+	}
+}
+
+func TestParseComputed(t *testing.T) {
+	var tests = []struct {
+		computed string
+		expected []Computed
+		err      bool
+	}{
+		// 0
+		{computed: ``, expected: nil},
+		// 1
+		{computed: `original_area, min_resolution`, expected: []Computed{ComputedOriginalArea, ComputedMinResolution}},
+		// 2
+		{computed: `area`, err: true},
+		// 3
+		{computed: `original_area, original_area`, err: true},
+	}
+
+	for k, test := range tests {
+		got, err := ParseComputed(test.computed)
+		if (err != nil) != test.err || !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v %v \ngot: %v %v", k, test.expected, test.err, got, err)
+		}
+	}
+}
+
+type testFeature struct {
+	columns  []interface{}
+	geometry geom.Geometry
+}
+
+func (f *testFeature) Columns() []interface{} {
+	return f.columns
+}
+
+func (f *testFeature) Geometry() geom.Geometry {
+	return f.geometry
+}
+
+func (f *testFeature) UpdateGeometry(geometry geom.Geometry) {
+	f.geometry = geometry
+}
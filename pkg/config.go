@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Config contains the table specific settings, read from a JSON configuration file
+//
+//	{
+//	  "tables": {
+//	    "municipalities": { "policy": "largest" },
+//	    "archipelagos": { "multipolygon": "whole", "filterParts": true },
+//	    "roads": { "columns": [{ "name": "naam", "as": "name" }, { "name": "width", "cast": "INTEGER" }] }
+//	  }
+//	}
+type Config struct {
+	Tables map[string]TableConfig `json:"tables"`
+}
+
+// TableConfig overrides the default Options for a single table
+type TableConfig struct {
+	Policy           Policy           `json:"policy,omitempty"`
+	MultiPolygonMode MultiPolygonMode `json:"multipolygon,omitempty"`
+	FilterParts      *bool            `json:"filterParts,omitempty"`
+	Validation       Validation       `json:"validation,omitempty"`
+	// Columns selects the columns written to the target, when empty all columns are written
+	Columns []ColumnConfig `json:"columns,omitempty"`
+}
+
+// ReadConfig reads and validates the given JSON configuration file
+func ReadConfig(file string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("error parsing configuration %s: %w", file, err)
+	}
+
+	for name, table := range config.Tables {
+		if _, err = ParsePolicy(string(table.Policy)); err != nil {
+			return config, fmt.Errorf("error in configuration for table %s: %w", name, err)
+		}
+		if _, err = ParseMultiPolygonMode(string(table.MultiPolygonMode)); err != nil {
+			return config, fmt.Errorf("error in configuration for table %s: %w", name, err)
+		}
+		if _, err = ParseValidation(string(table.Validation)); err != nil {
+			return config, fmt.Errorf("error in configuration for table %s: %w", name, err)
+		}
+		for _, column := range table.Columns {
+			if column.Name == `` {
+				return config, fmt.Errorf("error in configuration for table %s: column without name", name)
+			}
+			if _, err = parseCast(column.Cast); err != nil {
+				return config, fmt.Errorf("error in configuration for table %s: %w", name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// Options returns the Options for the given table, the table specific
+// settings from the configuration take precedence over the given defaults
+func (c Config) Options(table string, defaults Options) Options {
+	options := defaults
+	if t, ok := c.Tables[table]; ok {
+		if t.Policy != `` {
+			options.Policy = t.Policy
+		}
+		if t.MultiPolygonMode != `` {
+			options.MultiPolygonMode = t.MultiPolygonMode
+		}
+		if t.FilterParts != nil {
+			options.FilterParts = *t.FilterParts
+		}
+		if t.Validation != `` {
+			options.Validation = t.Validation
+		}
+	}
+	return options
+}
+
+// Project returns the Projection for the given table and the table as it is written to the target,
+// without columns in the configuration the Projection is nil and the table is returned as-is
+func (c Config) Project(table Table) (*Projection, Table, error) {
+	t, ok := c.Tables[table.Name]
+	if !ok || len(t.Columns) == 0 {
+		return nil, table, nil
+	}
+	return NewProjection(table, t.Columns)
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/go-spatial/geom"
+)
+
+// explain logs at the debug level why the sieve removed the feature, or the parts and rings of a
+// feature that is kept. The geometry of the feature is the original one.
+func explain(logger *slog.Logger, n uint64, feature Feature, kept bool, retained bool, options Options) {
+	minArea := options.Resolution * options.Resolution
+	identity := []any{`feature`, n}
+	if columns := feature.Columns(); options.PrimaryKey >= 0 && options.PrimaryKey < len(columns) {
+		identity = append(identity, `fid`, columns[options.PrimaryKey])
+	}
+	with := func(attrs ...any) []any {
+		return append(append([]any{}, identity...), attrs...)
+	}
+
+	if !kept || retained {
+		var reason string
+		switch g := feature.Geometry().(type) {
+		case geom.Polygon:
+			reason = fmt.Sprintf("the area %g is not larger than %g", area(g), minArea)
+		case geom.MultiPolygon:
+			if options.MultiPolygonMode == MultiPolygonWhole {
+				total := 0.
+				for _, p := range g {
+					total += area(p)
+				}
+				reason = fmt.Sprintf("the summed area %g is not larger than %g", total, minArea)
+			} else {
+				reason = fmt.Sprintf("the area of every part is not larger than %g", minArea)
+			}
+		default:
+			reason = fmt.Sprintf("the area of every polygon is not larger than %g", minArea)
+		}
+		if retained {
+			logger.Debug(`feature retained`, with(`reason`, reason, `policy`, options.Policy)...)
+		} else {
+			logger.Debug(`feature removed`, with(`reason`, reason)...)
+		}
+		return
+	}
+
+	// parts are removed on their own, unless a MULTIPOLYGON is evaluated as a whole without filtering them
+	filterParts := options.MultiPolygonMode != MultiPolygonWhole || options.FilterParts
+	// removable is set for the parts that are removed on their own when they are too small
+	var walk func(g geom.Geometry, path string, removable bool)
+	walk = func(g geom.Geometry, path string, removable bool) {
+		switch member := g.(type) {
+		case geom.Polygon:
+			if a := area(member); removable && a <= minArea {
+				logger.Debug(`part removed`, with(`part`, path, `reason`, fmt.Sprintf("the area %g is not larger than %g", a, minArea))...)
+				return
+			}
+			if len(member) == 0 {
+				return
+			}
+			for i, ring := range member[1:] {
+				if a := shoelace(ring); a <= minArea {
+					attrs := []any{`ring`, i + 1, `reason`, fmt.Sprintf("the area %g is not larger than %g", a, minArea)}
+					if path != `` {
+						attrs = append([]any{`part`, path}, attrs...)
+					}
+					logger.Debug(`ring removed`, with(attrs...)...)
+				}
+			}
+		case geom.MultiPolygon:
+			if removable && options.MultiPolygonMode == MultiPolygonWhole {
+				total := 0.
+				for _, p := range member {
+					total += area(p)
+				}
+				if total <= minArea {
+					logger.Debug(`part removed`, with(`part`, path, `reason`, fmt.Sprintf("the summed area %g is not larger than %g", total, minArea))...)
+					return
+				}
+			}
+			for i, p := range member {
+				walk(geom.Polygon(p), join(path, i), filterParts)
+			}
+		case geom.Collection:
+			for i, m := range member {
+				walk(m, join(path, i), true)
+			}
+		}
+	}
+	walk(feature.Geometry(), ``, false)
+}
+
+// join appends the index to the path of a part, like 2.0 for the first polygon in the third member
+func join(path string, i int) string {
+	if path == `` {
+		return fmt.Sprint(i)
+	}
+	return fmt.Sprintf("%s.%d", path, i)
+}
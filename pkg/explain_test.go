@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-spatial/geom"
+)
+
+func TestExplain(t *testing.T) {
+	small := geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}
+	holed := geom.Polygon{{{0, 0}, {0, 20}, {20, 20}, {20, 0}, {0, 0}}, {{1, 1}, {1, 2}, {2, 2}, {2, 1}, {1, 1}}}
+
+	var tests = []struct {
+		geometry geom.Geometry
+		kept     bool
+		retained bool
+		options  Options
+		expected []map[string]interface{}
+	}{
+		// 0 too small POLYGON, identified by its primary key
+		0: {geometry: small, options: Options{Resolution: 11, PrimaryKey: 0},
+			expected: []map[string]interface{}{{`msg`: `feature removed`, `feature`: 1., `fid`: 7., `reason`: `the area 100 is not larger than 121`}}},
+		// 1 the hole of a kept POLYGON is removed, without a primary key
+		1: {geometry: holed, kept: true, options: Options{Resolution: 11, PrimaryKey: -1},
+			expected: []map[string]interface{}{{`msg`: `ring removed`, `feature`: 1., `ring`: 1., `reason`: `the area 1 is not larger than 121`}}},
+		// 2 the small part of a MULTIPOLYGON is removed
+		2: {geometry: geom.MultiPolygon{holed, small}, kept: true, options: Options{Resolution: 11, PrimaryKey: -1},
+			expected: []map[string]interface{}{
+				{`msg`: `ring removed`, `feature`: 1., `part`: `0`, `ring`: 1., `reason`: `the area 1 is not larger than 121`},
+				{`msg`: `part removed`, `feature`: 1., `part`: `1`, `reason`: `the area 100 is not larger than 121`}}},
+		// 3 the parts of a MULTIPOLYGON evaluated as a whole are kept
+		3: {geometry: geom.MultiPolygon{holed, small}, kept: true, options: Options{Resolution: 11, PrimaryKey: -1, MultiPolygonMode: MultiPolygonWhole},
+			expected: []map[string]interface{}{{`msg`: `ring removed`, `feature`: 1., `part`: `0`, `ring`: 1., `reason`: `the area 1 is not larger than 121`}}},
+		// 4 the largest polygon of a collection is retained
+		4: {geometry: geom.Collection{geom.Point{1, 1}, small}, kept: true, retained: true, options: Options{Resolution: 11, PrimaryKey: -1, Policy: PolicyLargest},
+			expected: []map[string]interface{}{{`msg`: `feature retained`, `feature`: 1., `policy`: `largest`, `reason`: `the area of every polygon is not larger than 121`}}},
+		// 5 a small polygon in a nested collection is removed
+		5: {geometry: geom.Collection{geom.Collection{small}, holed}, kept: true, options: Options{Resolution: 11, PrimaryKey: -1},
+			expected: []map[string]interface{}{
+				{`msg`: `part removed`, `feature`: 1., `part`: `0.0`, `reason`: `the area 100 is not larger than 121`},
+				{`msg`: `ring removed`, `feature`: 1., `part`: `1`, `ring`: 1., `reason`: `the area 1 is not larger than 121`}}},
+	}
+
+	for k, test := range tests {
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey || a.Key == slog.LevelKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		}))
+		feature := &testFeature{columns: []interface{}{int64(7), `name`}, geometry: test.geometry}
+		explain(logger, 1, feature, test.kept, test.retained, test.options)
+
+		var got []map[string]interface{}
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == `` {
+				continue
+			}
+			var record map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatalf("test: %d, expected: a JSON record \ngot: %s %v", k, line, err)
+			}
+			got = append(got, record)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
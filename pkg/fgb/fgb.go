@@ -0,0 +1,650 @@
+package fgb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-spatial/geom"
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/pdok/sieve/pkg"
+)
+
+// fidColumn is added to tables without a primary key, GeoPackage tables require one. When the
+// table has a column with this name a number is appended, like fid_1.
+const fidColumn = `fid`
+
+// geometryColumn is the name of the geometry column, FlatGeobuf doesn't name it
+const geometryColumn = `geom`
+
+// indexNodeSize is the node size of the packed Hilbert R-tree that is written
+const indexNodeSize = 16
+
+type featureFGB struct {
+	columns  []interface{}
+	geometry geom.Geometry
+}
+
+func (f featureFGB) Columns() []interface{} {
+	return f.columns
+}
+
+func (f featureFGB) Geometry() geom.Geometry {
+	return f.geometry
+}
+
+func (f *featureFGB) UpdateGeometry(geometry geom.Geometry) {
+	f.geometry = geometry
+}
+
+// columnTypeFromString returns the FlatGeobuf column type of a GeoPackage column type
+func columnTypeFromString(columntype string) byte {
+	columntype = strings.ToUpper(columntype)
+	switch {
+	case columntype == "BOOLEAN":
+		return columnTypeBool
+	case columntype == "TINYINT":
+		return columnTypeByte
+	case columntype == "SMALLINT":
+		return columnTypeShort
+	case columntype == "MEDIUMINT":
+		return columnTypeInt
+	case strings.Contains(columntype, "INT"):
+		return columnTypeLong
+	case columntype == "FLOAT":
+		return columnTypeFloat
+	case columntype == "DOUBLE" || columntype == "REAL":
+		return columnTypeDouble
+	case columntype == "DATE" || columntype == "DATETIME":
+		return columnTypeDateTime
+	case strings.HasPrefix(columntype, "BLOB"):
+		return columnTypeBinary
+	default:
+		return columnTypeString
+	}
+}
+
+// columnTypeToString returns the GeoPackage column type of a FlatGeobuf column type
+func columnTypeToString(columntype byte) string {
+	switch columntype {
+	case columnTypeBool:
+		return "BOOLEAN"
+	case columnTypeByte, columnTypeUByte:
+		return "TINYINT"
+	case columnTypeShort, columnTypeUShort:
+		return "SMALLINT"
+	case columnTypeInt:
+		return "MEDIUMINT"
+	case columnTypeUInt, columnTypeLong, columnTypeULong:
+		return "INTEGER"
+	case columnTypeFloat:
+		return "FLOAT"
+	case columnTypeDouble:
+		return "DOUBLE"
+	case columnTypeDateTime:
+		return "DATETIME"
+	case columnTypeBinary:
+		return "BLOB"
+	default:
+		return "TEXT"
+	}
+}
+
+type SourceFlatGeobuf struct {
+	Table  pkg.Table
+	file   string
+	header header
+	// offset of the first feature in the file
+	offset int64
+	// addFid is set when the table has no primary key and a fid column is added
+	addFid bool
+}
+
+func (source *SourceFlatGeobuf) Init(file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("error opening FlatGeobuf: %s", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	h, size, err := readHeader(r)
+	if err != nil {
+		log.Fatalf("error reading the FlatGeobuf header: %s", err)
+	}
+	source.file = file
+	source.header = h
+	source.offset = size + int64(indexSize(h.featuresCount, h.indexNodeSize))
+}
+
+func (source SourceFlatGeobuf) Close() {
+}
+
+func (source *SourceFlatGeobuf) SetTable(table pkg.Table) {
+	source.Table = table
+}
+
+// GetTableInfo returns the single table of the FlatGeobuf file, named after the file
+// when the header has no name
+func (source *SourceFlatGeobuf) GetTableInfo() []pkg.Table {
+	h := source.header
+	t := pkg.Table{
+		Name:           h.name,
+		GeometryColumn: geometryColumn,
+		GeometryType:   geometryTypeToString(h.geometryType),
+	}
+	if t.Name == `` {
+		t.Name = strings.TrimSuffix(filepath.Base(source.file), filepath.Ext(source.file))
+	}
+
+	source.addFid = true
+	for _, c := range h.columns {
+		if c.primaryKey {
+			source.addFid = false
+		}
+	}
+	if source.addFid {
+		t.Columns = append(t.Columns, pkg.Column{Name: fidName(h.columns), Type: "INTEGER", NotNull: true, PrimaryKey: true})
+	}
+	for _, c := range h.columns {
+		t.Columns = append(t.Columns, pkg.Column{
+			Name:       c.name,
+			Type:       columnTypeToString(c.ctype),
+			NotNull:    !c.nullable,
+			PrimaryKey: c.primaryKey,
+		})
+	}
+	t.Columns = append(t.Columns, pkg.Column{Name: geometryColumn, Type: t.GeometryType})
+
+	t.SRS = pkg.SpatialReferenceSystem{
+		Name:                   h.crs.name,
+		ID:                     int(h.crs.code),
+		Organization:           h.crs.org,
+		OrganizationCoordsysID: int(h.crs.code),
+		Definition:             h.crs.wkt,
+		Description:            h.crs.description,
+	}
+	if t.SRS.Organization == `` {
+		t.SRS.Organization = `EPSG`
+	}
+	if t.SRS.Name == `` {
+		t.SRS.Name = fmt.Sprintf("%s:%d", t.SRS.Organization, h.crs.code)
+	}
+	if t.SRS.Definition == `` {
+		t.SRS.Definition = `undefined`
+	}
+	return []pkg.Table{t}
+}
+
+// fidName returns the name of the added fid column that is not taken by one of the columns
+// or the geometry column, compared case-insensitively like SQLite does
+func fidName(columns []column) string {
+	used := map[string]bool{geometryColumn: true}
+	for _, c := range columns {
+		used[strings.ToLower(c.name)] = true
+	}
+	name := fidColumn
+	for n := 1; used[name]; n++ {
+		name = fmt.Sprintf("%s_%d", fidColumn, n)
+	}
+	return name
+}
+
+// Count returns the number of features from the header, it is unknown when the header has none
+func (source SourceFlatGeobuf) Count() (int64, bool) {
+	if source.header.featuresCount == 0 {
+		return 0, false
+	}
+	return int64(source.header.featuresCount), true
+}
+
+func (source SourceFlatGeobuf) ReadFeatures(preSieve chan pkg.Feature) {
+	f, err := os.Open(source.file)
+	if err != nil {
+		log.Fatalf("error opening FlatGeobuf: %s", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Seek(source.offset, io.SeekStart); err != nil {
+		log.Fatalf("error skipping the FlatGeobuf index: %s", err)
+	}
+	r := bufio.NewReader(f)
+
+	for i := int64(1); ; i++ {
+		buf, err := readSizePrefixed(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error reading feature: %s", err)
+		}
+
+		feature, err := source.decodeFeature(buf)
+		if err != nil {
+			log.Fatalf("error decoding feature %d: %s", i, err)
+		}
+		if source.addFid {
+			feature.columns = append([]interface{}{i}, feature.columns...)
+		}
+		preSieve <- feature
+	}
+	close(preSieve)
+}
+
+// decodeFeature decodes the geometry and the properties of a Feature,
+// missing properties are returned as nil
+func (source SourceFlatGeobuf) decodeFeature(buf []byte) (*featureFGB, error) {
+	t := root(buf)
+	var f featureFGB
+	if g, ok := t.table(featureGeometry); ok {
+		geometry, err := decodeGeometry(g, source.header.geometryType)
+		if err != nil {
+			return nil, err
+		}
+		f.geometry = geometry
+	}
+
+	f.columns = make([]interface{}, len(source.header.columns))
+	properties := t.bytes(featureProperties)
+	for pos := 0; pos < len(properties); {
+		if pos+2 > len(properties) {
+			return nil, errors.New("invalid properties")
+		}
+		i := int(binary.LittleEndian.Uint16(properties[pos:]))
+		pos = pos + 2
+		if i >= len(source.header.columns) {
+			return nil, fmt.Errorf("invalid column index: %d", i)
+		}
+		value, n, err := decodeValue(source.header.columns[i].ctype, properties[pos:])
+		if err != nil {
+			return nil, err
+		}
+		f.columns[i] = value
+		pos = pos + n
+	}
+	return &f, nil
+}
+
+// decodeValue decodes a single property value, returning the number of bytes read
+func decodeValue(ctype byte, b []byte) (interface{}, int, error) {
+	size := map[byte]int{
+		columnTypeByte: 1, columnTypeUByte: 1, columnTypeBool: 1,
+		columnTypeShort: 2, columnTypeUShort: 2,
+		columnTypeInt: 4, columnTypeUInt: 4, columnTypeFloat: 4,
+		columnTypeLong: 8, columnTypeULong: 8, columnTypeDouble: 8,
+	}[ctype]
+	if size == 0 {
+		size = 4
+	}
+	if len(b) < size {
+		return nil, 0, errors.New("invalid properties")
+	}
+
+	switch ctype {
+	case columnTypeByte:
+		return int64(int8(b[0])), 1, nil
+	case columnTypeUByte:
+		return int64(b[0]), 1, nil
+	case columnTypeBool:
+		return b[0] != 0, 1, nil
+	case columnTypeShort:
+		return int64(int16(binary.LittleEndian.Uint16(b))), 2, nil
+	case columnTypeUShort:
+		return int64(binary.LittleEndian.Uint16(b)), 2, nil
+	case columnTypeInt:
+		return int64(int32(binary.LittleEndian.Uint32(b))), 4, nil
+	case columnTypeUInt:
+		return int64(binary.LittleEndian.Uint32(b)), 4, nil
+	case columnTypeLong:
+		return int64(binary.LittleEndian.Uint64(b)), 8, nil
+	case columnTypeULong:
+		return int64(binary.LittleEndian.Uint64(b)), 8, nil
+	case columnTypeFloat:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 4, nil
+	case columnTypeDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), 8, nil
+	}
+
+	length := int(binary.LittleEndian.Uint32(b))
+	if len(b) < 4+length {
+		return nil, 0, errors.New("invalid properties")
+	}
+	if ctype == columnTypeBinary {
+		value := make([]byte, length)
+		copy(value, b[4:4+length])
+		return value, 4 + length, nil
+	}
+	return string(b[4 : 4+length]), 4 + length, nil
+}
+
+type TargetFlatGeobuf struct {
+	Table pkg.Table
+	file  string
+	files map[string]string
+}
+
+// Init sets the target file, when more then one table is written the
+// table name is added to the file name: target_table.fgb
+func (target *TargetFlatGeobuf) Init(file string) {
+	target.file = file
+}
+
+func (target TargetFlatGeobuf) Close() {
+}
+
+func (target *TargetFlatGeobuf) SetTable(table pkg.Table) {
+	target.Table = table
+}
+
+func (target *TargetFlatGeobuf) CreateTables(tables []pkg.Table) error {
+	target.files = make(map[string]string)
+	for _, table := range tables {
+		file := target.file
+		if len(tables) > 1 {
+			ext := filepath.Ext(target.file)
+			file = strings.TrimSuffix(target.file, ext) + `_` + table.Name + ext
+		}
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("target FlatGeobuf already exists: %s", file)
+		}
+		target.files[table.Name] = file
+	}
+	return nil
+}
+
+// WriteFeatures writes the features to a temporary file first, because the
+// header and index that precede the features can only be written when all
+// features are known. The features are written in the order of the index.
+func (target TargetFlatGeobuf) WriteFeatures(postSieve chan pkg.Feature) {
+	file := target.files[target.Table.Name]
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+`.*.tmp`)
+	if err != nil {
+		log.Fatalf("error creating temporary file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	columns := target.columns()
+	typed := geometryTypeFromString(target.Table.GeometryType) == geometryTypeUnknown
+	w := bufio.NewWriter(tmp)
+	var leaves []nodeItem
+	var sizes []uint64
+	extent := emptyNodeItem()
+	offset := uint64(0)
+
+	for {
+		feature, hasMore := <-postSieve
+		if !hasMore {
+			break
+		}
+		buf, err := encodeFeature(feature, columns, typed)
+		if err != nil {
+			log.Fatalf("error encoding feature: %s", err)
+		}
+		if _, err = w.Write(buf); err != nil {
+			log.Fatalf("error writing to temporary file: %s", err)
+		}
+
+		leaf := nodeItem{0, 0, 0, 0, offset}
+		if feature.Geometry() != nil {
+			if ext, err := geom.NewExtentFromGeometry(feature.Geometry()); err == nil {
+				leaf = nodeItem{ext.MinX(), ext.MinY(), ext.MaxX(), ext.MaxY(), offset}
+				extent.expand(leaf)
+			}
+		}
+		leaves = append(leaves, leaf)
+		sizes = append(sizes, uint64(len(buf)))
+		offset = offset + uint64(len(buf))
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatalf("error writing to temporary file: %s", err)
+	}
+
+	if err = target.write(file, tmp, leaves, sizes, extent); err != nil {
+		log.Fatalf("error writing FlatGeobuf %s: %s", file, err)
+	}
+}
+
+// write writes the header, the index and the features from the temporary file in Hilbert order
+func (target TargetFlatGeobuf) write(file string, tmp *os.File, leaves []nodeItem, sizes []uint64, extent nodeItem) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	h := header{
+		name:          target.Table.Name,
+		geometryType:  geometryTypeFromString(target.Table.GeometryType),
+		featuresCount: uint64(len(leaves)),
+		indexNodeSize: indexNodeSize,
+		crs: crs{
+			org:         target.Table.SRS.Organization,
+			code:        int32(target.Table.SRS.OrganizationCoordsysID),
+			name:        target.Table.SRS.Name,
+			description: target.Table.SRS.Description,
+			wkt:         target.Table.SRS.Definition,
+		},
+	}
+	for _, c := range target.columns() {
+		h.columns = append(h.columns, column{name: c.Name, ctype: columnTypeFromString(c.Type), nullable: !c.NotNull, primaryKey: c.PrimaryKey})
+	}
+	if len(leaves) > 0 && !math.IsInf(extent.minX, 1) {
+		h.envelope = []float64{extent.minX, extent.minY, extent.maxX, extent.maxY}
+	}
+	if len(leaves) == 0 {
+		h.indexNodeSize = 0
+	}
+
+	if _, err = w.Write(magicBytes); err != nil {
+		return err
+	}
+	if err = writeSizePrefixed(w, encodeHeader(h)); err != nil {
+		return err
+	}
+	if len(leaves) == 0 {
+		return w.Flush()
+	}
+
+	// the offsets of the leaves become the offsets in the sorted data section
+	tmpOffsets := make(map[uint64]uint64, len(leaves))
+	for i, leaf := range leaves {
+		tmpOffsets[leaf.offset] = sizes[i]
+	}
+	hilbertSort(leaves, extent)
+	sorted := make([]nodeItem, len(leaves))
+	tmpLeaves := make([]nodeItem, len(leaves))
+	offset := uint64(0)
+	for i, leaf := range leaves {
+		tmpLeaves[i] = leaf
+		sorted[i] = leaf
+		sorted[i].offset = offset
+		offset = offset + tmpOffsets[leaf.offset]
+	}
+	if err = writeIndex(w, sorted, indexNodeSize); err != nil {
+		return err
+	}
+
+	for _, leaf := range tmpLeaves {
+		buf := make([]byte, tmpOffsets[leaf.offset])
+		if _, err = tmp.ReadAt(buf, int64(leaf.offset)); err != nil {
+			return err
+		}
+		if _, err = w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// columns returns the non-geometry columns of the table
+func (target TargetFlatGeobuf) columns() []pkg.Column {
+	var columns []pkg.Column
+	for _, c := range target.Table.Columns {
+		if c.Name != target.Table.GeometryColumn {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// encodeFeature encodes a feature as a size prefixed FlatGeobuf Feature, typed
+// is needed for tables without a specific geometry type
+func encodeFeature(feature pkg.Feature, columns []pkg.Column, typed bool) ([]byte, error) {
+	var properties bytes.Buffer
+	for i, value := range feature.Columns() {
+		if value == nil || i >= len(columns) {
+			continue
+		}
+		binary.Write(&properties, binary.LittleEndian, uint16(i))
+		if err := encodeValue(&properties, columnTypeFromString(columns[i].Type), value); err != nil {
+			return nil, fmt.Errorf("column %s: %w", columns[i].Name, err)
+		}
+	}
+
+	b := flatbuffers.NewBuilder(1024)
+	var geometry flatbuffers.UOffsetT
+	if feature.Geometry() != nil {
+		var err error
+		geometry, err = encodeGeometry(b, feature.Geometry(), typed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var propertiesVector flatbuffers.UOffsetT
+	if properties.Len() > 0 {
+		propertiesVector = b.CreateByteVector(properties.Bytes())
+	}
+
+	b.StartObject(featureFields)
+	if geometry != 0 {
+		b.PrependUOffsetTSlot(slot(featureGeometry), geometry, 0)
+	}
+	if propertiesVector != 0 {
+		b.PrependUOffsetTSlot(slot(featureProperties), propertiesVector, 0)
+	}
+	b.Finish(b.EndObject())
+
+	var buf bytes.Buffer
+	if err := writeSizePrefixed(&buf, b.FinishedBytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeValue encodes a single property value, converting it to the column type when needed
+func encodeValue(w *bytes.Buffer, ctype byte, value interface{}) error {
+	switch ctype {
+	case columnTypeString, columnTypeJSON, columnTypeDateTime, columnTypeBinary:
+		var b []byte
+		switch v := value.(type) {
+		case []byte:
+			b = v
+		case time.Time:
+			b = []byte(v.Format(time.RFC3339))
+		default:
+			b = []byte(fmt.Sprint(v))
+		}
+		binary.Write(w, binary.LittleEndian, uint32(len(b)))
+		w.Write(b)
+		return nil
+	case columnTypeFloat, columnTypeDouble:
+		var f float64
+		switch v := value.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case string:
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return err
+			}
+			f = parsed
+		default:
+			return fmt.Errorf("unexpected value %v of type %T", v, v)
+		}
+		if ctype == columnTypeFloat {
+			return binary.Write(w, binary.LittleEndian, float32(f))
+		}
+		return binary.Write(w, binary.LittleEndian, f)
+	}
+
+	var i int64
+	switch v := value.(type) {
+	case int64:
+		i = v
+	case bool:
+		if v {
+			i = 1
+		}
+	case float64:
+		i = int64(v)
+	case string:
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		i = parsed
+	default:
+		return fmt.Errorf("unexpected value %v of type %T", v, v)
+	}
+	switch ctype {
+	case columnTypeByte, columnTypeUByte, columnTypeBool:
+		return w.WriteByte(byte(i))
+	case columnTypeShort, columnTypeUShort:
+		return binary.Write(w, binary.LittleEndian, uint16(i))
+	case columnTypeInt, columnTypeUInt:
+		return binary.Write(w, binary.LittleEndian, uint32(i))
+	default:
+		return binary.Write(w, binary.LittleEndian, i)
+	}
+}
+
+// readHeader checks the magic bytes and reads the header, returning the
+// offset of the first byte after the header
+func readHeader(r io.Reader) (header, int64, error) {
+	magic := make([]byte, len(magicBytes))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return header{}, 0, err
+	}
+	if !bytes.Equal(magic[:3], magicBytes[:3]) || magic[3] != magicBytes[3] {
+		return header{}, 0, errors.New("not a FlatGeobuf version 3 file")
+	}
+	buf, err := readSizePrefixed(r)
+	if err != nil {
+		return header{}, 0, err
+	}
+	return decodeHeader(buf), int64(len(magicBytes) + 4 + len(buf)), nil
+}
+
+func readSizePrefixed(r io.Reader) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func writeSizePrefixed(w io.Writer, buf []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(buf))); err != nil {
+		return err
+	}
+	_, err := w.Write(buf)
+	return err
+}
@@ -0,0 +1,188 @@
+package fgb
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var tests = []struct {
+		table    pkg.Table
+		features []featureFGB
+	}{
+		// 0
+		{table: pkg.Table{
+			Name: `polygons`,
+			Columns: []pkg.Column{
+				{Name: `id`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+				{Name: `name`, Type: `TEXT`},
+				{Name: `value`, Type: `DOUBLE`},
+				{Name: `geom`, Type: `POLYGON`},
+			},
+			GeometryColumn: `geom`,
+			GeometryType:   `POLYGON`,
+			SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992, Definition: `undefined`},
+		},
+			features: []featureFGB{
+				{columns: []interface{}{int64(1), `a`, 1.5}, geometry: geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}, {{2, 2}, {2, 4}, {4, 4}, {4, 2}, {2, 2}}}},
+				{columns: []interface{}{int64(2), nil, -2.0}, geometry: geom.Polygon{{{100, 100}, {100, 110}, {110, 110}, {110, 100}, {100, 100}}}},
+				{columns: []interface{}{int64(3), `c`, nil}, geometry: geom.Polygon{{{50, 50}, {50, 60}, {60, 60}, {60, 50}, {50, 50}}}},
+			}},
+		// 1
+		{table: pkg.Table{
+			Name: `mixed`,
+			Columns: []pkg.Column{
+				{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+				{Name: `geom`, Type: `GEOMETRY`},
+			},
+			GeometryColumn: `geom`,
+			GeometryType:   `GEOMETRY`,
+			SRS:            pkg.SpatialReferenceSystem{Name: `WGS 84`, ID: 4326, Organization: `EPSG`, OrganizationCoordsysID: 4326, Definition: `undefined`},
+		},
+			features: []featureFGB{
+				{columns: []interface{}{int64(1)}, geometry: geom.MultiPolygon{{{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}}, {{{2, 2}, {2, 3}, {3, 3}, {3, 2}, {2, 2}}}}},
+				{columns: []interface{}{int64(2)}, geometry: geom.Collection{geom.Point{5, 5}, geom.LineString{{0, 0}, {5, 5}}}},
+			}},
+	}
+
+	for k, test := range tests {
+		file := filepath.Join(t.TempDir(), `test.fgb`)
+
+		target := TargetFlatGeobuf{}
+		target.Init(file)
+		if err := target.CreateTables([]pkg.Table{test.table}); err != nil {
+			t.Fatalf("test: %d, unexpected error: %s", k, err)
+		}
+		target.SetTable(test.table)
+		postSieve := make(chan pkg.Feature)
+		go func() {
+			for i := range test.features {
+				postSieve <- &test.features[i]
+			}
+			close(postSieve)
+		}()
+		target.WriteFeatures(postSieve)
+
+		source := SourceFlatGeobuf{}
+		source.Init(file)
+		tables := source.GetTableInfo()
+		if len(tables) != 1 || !reflect.DeepEqual(tables[0], test.table) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.table, tables)
+		}
+		source.SetTable(tables[0])
+
+		preSieve := make(chan pkg.Feature)
+		go source.ReadFeatures(preSieve)
+		got := make(map[int64]pkg.Feature)
+		for {
+			feature, hasMore := <-preSieve
+			if !hasMore {
+				break
+			}
+			got[feature.Columns()[0].(int64)] = feature
+		}
+
+		if len(got) != len(test.features) {
+			t.Errorf("test: %d, expected: %d features \ngot: %d", k, len(test.features), len(got))
+		}
+		for _, expected := range test.features {
+			feature := got[expected.columns[0].(int64)]
+			if feature == nil {
+				t.Errorf("test: %d, expected: %v \ngot: nil", k, expected.columns)
+				continue
+			}
+			if !reflect.DeepEqual(feature.Columns(), expected.columns) {
+				t.Errorf("test: %d, expected: %v \ngot: %v", k, expected.columns, feature.Columns())
+			}
+			if !reflect.DeepEqual(feature.Geometry(), expected.geometry) {
+				t.Errorf("test: %d, expected: %v \ngot: %v", k, expected.geometry, feature.Geometry())
+			}
+		}
+	}
+}
+
+func TestCreateTablesExisting(t *testing.T) {
+	file := filepath.Join(t.TempDir(), `test.fgb`)
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := TargetFlatGeobuf{}
+	target.Init(file)
+	if err := target.CreateTables([]pkg.Table{{Name: `a`}}); err == nil {
+		t.Errorf("expected an error for an existing target")
+	}
+}
+
+func TestFidName(t *testing.T) {
+	var tests = []struct {
+		columns  []column
+		expected string
+	}{
+		// 0
+		{columns: []column{{name: `name`}}, expected: `fid`},
+		// 1
+		{columns: []column{{name: `name`}, {name: `fid`}}, expected: `fid_1`},
+		// 2
+		{columns: []column{{name: `FID`}, {name: `fid_1`}}, expected: `fid_2`},
+	}
+
+	for k, test := range tests {
+		if got := fidName(test.columns); got != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.expected, got)
+		}
+	}
+}
+
+func TestIndexSize(t *testing.T) {
+	var tests = []struct {
+		items    uint64
+		nodeSize uint16
+		expected uint64
+	}{
+		// 0
+		{items: 0, nodeSize: 16, expected: 0},
+		// 1
+		{items: 1, nodeSize: 16, expected: 2 * nodeItemSize},
+		// 2
+		{items: 16, nodeSize: 16, expected: 17 * nodeItemSize},
+		// 3
+		{items: 17, nodeSize: 16, expected: (17 + 2 + 1) * nodeItemSize},
+	}
+
+	for k, test := range tests {
+		if size := indexSize(test.items, test.nodeSize); size != test.expected {
+			t.Errorf("test: %d, expected: %d \ngot: %d", k, test.expected, size)
+		}
+	}
+}
+
+func TestRings(t *testing.T) {
+	xy := []float64{0, 0, 0, 1, 1, 1, 0, 0, 5, 5, 5, 6, 6, 6, 5, 5}
+
+	var tests = []struct {
+		ends     []uint32
+		expected [][][2]float64
+		err      bool
+	}{
+		// 0 without ends all points are a single ring
+		{expected: [][][2]float64{{{0, 0}, {0, 1}, {1, 1}, {0, 0}, {5, 5}, {5, 6}, {6, 6}, {5, 5}}}},
+		// 1
+		{ends: []uint32{4, 8}, expected: [][][2]float64{{{0, 0}, {0, 1}, {1, 1}, {0, 0}}, {{5, 5}, {5, 6}, {6, 6}, {5, 5}}}},
+		// 2 an end beyond the points of a truncated file
+		{ends: []uint32{4, 9}, err: true},
+		// 3 an end before the previous end
+		{ends: []uint32{4, 2}, err: true},
+	}
+
+	for k, test := range tests {
+		got, err := rings(xy, test.ends)
+		if (err != nil) != test.err || !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v %v \ngot: %v %v", k, test.expected, test.err, got, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package fgb
+
+import (
+	"fmt"
+
+	"github.com/go-spatial/geom"
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// geometryTypeFromString returns the FlatGeobuf geometry type of a GeoPackage geometry type name
+func geometryTypeFromString(geometrytype string) byte {
+	switch geometrytype {
+	case "POINT":
+		return geometryTypePoint
+	case "LINESTRING":
+		return geometryTypeLineString
+	case "POLYGON":
+		return geometryTypePolygon
+	case "MULTIPOINT":
+		return geometryTypeMultiPoint
+	case "MULTILINESTRING":
+		return geometryTypeMultiLineString
+	case "MULTIPOLYGON":
+		return geometryTypeMultiPolygon
+	case "GEOMETRYCOLLECTION":
+		return geometryTypeGeometryCollection
+	default:
+		return geometryTypeUnknown
+	}
+}
+
+// geometryTypeToString returns the GeoPackage geometry type name of a FlatGeobuf geometry type
+func geometryTypeToString(geometrytype byte) string {
+	switch geometrytype {
+	case geometryTypePoint:
+		return "POINT"
+	case geometryTypeLineString:
+		return "LINESTRING"
+	case geometryTypePolygon:
+		return "POLYGON"
+	case geometryTypeMultiPoint:
+		return "MULTIPOINT"
+	case geometryTypeMultiLineString:
+		return "MULTILINESTRING"
+	case geometryTypeMultiPolygon:
+		return "MULTIPOLYGON"
+	case geometryTypeGeometryCollection:
+		return "GEOMETRYCOLLECTION"
+	default:
+		return "GEOMETRY"
+	}
+}
+
+// typeForGeometry returns the FlatGeobuf geometry type of a geometry
+func typeForGeometry(geometry geom.Geometry) byte {
+	switch geometry.(type) {
+	case geom.Point:
+		return geometryTypePoint
+	case geom.LineString:
+		return geometryTypeLineString
+	case geom.Polygon:
+		return geometryTypePolygon
+	case geom.MultiPoint:
+		return geometryTypeMultiPoint
+	case geom.MultiLineString:
+		return geometryTypeMultiLineString
+	case geom.MultiPolygon:
+		return geometryTypeMultiPolygon
+	case geom.Collection:
+		return geometryTypeGeometryCollection
+	default:
+		return geometryTypeUnknown
+	}
+}
+
+// decodeGeometry decodes a FlatGeobuf Geometry table, the geometry type of the header is used
+// unless it is unknown, then the type is read from the Geometry itself
+func decodeGeometry(t table, gtype byte) (geom.Geometry, error) {
+	if gtype == geometryTypeUnknown {
+		gtype = t.GetByteSlot(geometryType, geometryTypeUnknown)
+	}
+	xy := t.float64s(geometryXY)
+	ends := t.uint32s(geometryEnds)
+
+	switch gtype {
+	case geometryTypePoint:
+		if len(xy) < 2 {
+			return nil, nil
+		}
+		return geom.Point{xy[0], xy[1]}, nil
+	case geometryTypeLineString:
+		return geom.LineString(points(xy)), nil
+	case geometryTypePolygon:
+		r, err := rings(xy, ends)
+		return geom.Polygon(r), err
+	case geometryTypeMultiPoint:
+		return geom.MultiPoint(points(xy)), nil
+	case geometryTypeMultiLineString:
+		r, err := rings(xy, ends)
+		return geom.MultiLineString(r), err
+	case geometryTypeMultiPolygon:
+		var mp geom.MultiPolygon
+		for _, part := range t.tables(geometryParts) {
+			r, err := rings(part.float64s(geometryXY), part.uint32s(geometryEnds))
+			if err != nil {
+				return nil, err
+			}
+			mp = append(mp, r)
+		}
+		return mp, nil
+	case geometryTypeGeometryCollection:
+		var c geom.Collection
+		for _, part := range t.tables(geometryParts) {
+			g, err := decodeGeometry(part, geometryTypeUnknown)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, g)
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("unsupported geometry type: %d", gtype)
+}
+
+// points converts the flat xy array into points
+func points(xy []float64) [][2]float64 {
+	pts := make([][2]float64, len(xy)/2)
+	for i := range pts {
+		pts[i] = [2]float64{xy[2*i], xy[2*i+1]}
+	}
+	return pts
+}
+
+// rings splits the flat xy array into rings (or linestrings) at the ends, the ends of a corrupt
+// file not fitting the points are an error
+func rings(xy []float64, ends []uint32) ([][][2]float64, error) {
+	pts := points(xy)
+	if len(ends) == 0 {
+		return [][][2]float64{pts}, nil
+	}
+	var rings [][][2]float64
+	start := uint32(0)
+	for _, end := range ends {
+		if end < start || int(end) > len(pts) {
+			return nil, fmt.Errorf("invalid ring end %d, after %d of %d points", end, start, len(pts))
+		}
+		rings = append(rings, pts[start:end])
+		start = end
+	}
+	return rings, nil
+}
+
+// encodeGeometry adds the geometry to the builder as a FlatGeobuf Geometry table,
+// with typed the type is written, which is needed when the header type is unknown
+func encodeGeometry(b *flatbuffers.Builder, geometry geom.Geometry, typed bool) (flatbuffers.UOffsetT, error) {
+	var xy []float64
+	var ends []uint32
+	var parts []flatbuffers.UOffsetT
+
+	switch g := geometry.(type) {
+	case geom.Point:
+		xy = []float64{g[0], g[1]}
+	case geom.LineString:
+		xy = flatten(g)
+	case geom.MultiPoint:
+		xy = flatten(g)
+	case geom.Polygon:
+		xy, ends = flattenRings(g)
+	case geom.MultiLineString:
+		xy, ends = flattenRings(g)
+	case geom.MultiPolygon:
+		for _, p := range g {
+			part, err := encodeGeometry(b, geom.Polygon(p), false)
+			if err != nil {
+				return 0, err
+			}
+			parts = append(parts, part)
+		}
+	case geom.Collection:
+		for _, member := range g {
+			part, err := encodeGeometry(b, member, true)
+			if err != nil {
+				return 0, err
+			}
+			parts = append(parts, part)
+		}
+	default:
+		return 0, fmt.Errorf("unsupported geometry: %T", geometry)
+	}
+
+	var partsVector flatbuffers.UOffsetT
+	if len(parts) > 0 {
+		b.StartVector(4, len(parts), 4)
+		for i := len(parts) - 1; i >= 0; i-- {
+			b.PrependUOffsetT(parts[i])
+		}
+		partsVector = b.EndVector(len(parts))
+	}
+	xyVector := createFloat64s(b, xy)
+	endsVector := createUint32s(b, ends)
+
+	b.StartObject(geometryFields)
+	if endsVector != 0 {
+		b.PrependUOffsetTSlot(slot(geometryEnds), endsVector, 0)
+	}
+	if xyVector != 0 {
+		b.PrependUOffsetTSlot(slot(geometryXY), xyVector, 0)
+	}
+	if typed {
+		b.PrependByteSlot(slot(geometryType), typeForGeometry(geometry), geometryTypeUnknown)
+	}
+	if partsVector != 0 {
+		b.PrependUOffsetTSlot(slot(geometryParts), partsVector, 0)
+	}
+	return b.EndObject(), nil
+}
+
+func flatten(pts [][2]float64) []float64 {
+	xy := make([]float64, 0, 2*len(pts))
+	for _, pt := range pts {
+		xy = append(xy, pt[0], pt[1])
+	}
+	return xy
+}
+
+// flattenRings flattens the rings into a single xy array, the ends are only
+// needed when there is more than one ring
+func flattenRings(rings [][][2]float64) ([]float64, []uint32) {
+	var xy []float64
+	var ends []uint32
+	for _, ring := range rings {
+		xy = append(xy, flatten(ring)...)
+		ends = append(ends, uint32(len(xy)/2))
+	}
+	if len(ends) == 1 {
+		ends = nil
+	}
+	return xy, ends
+}
@@ -0,0 +1,140 @@
+package fgb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/pdok/sieve/pkg"
+)
+
+// nodeItemSize is the size of a node in the index: minx, miny, maxx, maxy and the offset
+const nodeItemSize = 40
+
+// hilbertBits is the number of bits per axis of the Hilbert curve of the index
+const hilbertBits = 16
+
+// hilbertMax is the maximum value of a coordinate on the Hilbert curve
+const hilbertMax = (1 << hilbertBits) - 1
+
+// nodeItem is a node of the packed Hilbert R-tree, for the leaves the offset is the
+// byte offset of the feature in the data section, otherwise the index of the first child
+type nodeItem struct {
+	minX, minY, maxX, maxY float64
+	offset                 uint64
+}
+
+func emptyNodeItem() nodeItem {
+	return nodeItem{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1), 0}
+}
+
+func (n *nodeItem) expand(o nodeItem) {
+	n.minX = math.Min(n.minX, o.minX)
+	n.minY = math.Min(n.minY, o.minY)
+	n.maxX = math.Max(n.maxX, o.maxX)
+	n.maxY = math.Max(n.maxY, o.maxY)
+}
+
+func (n nodeItem) write(w io.Writer) error {
+	var buf [nodeItemSize]byte
+	binary.LittleEndian.PutUint64(buf[0:], math.Float64bits(n.minX))
+	binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(n.minY))
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(n.maxX))
+	binary.LittleEndian.PutUint64(buf[24:], math.Float64bits(n.maxY))
+	binary.LittleEndian.PutUint64(buf[32:], n.offset)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// levelBounds returns the start and end node index of every level of the tree,
+// starting with the leaves, the root is the first node of the tree
+func levelBounds(numItems uint64, nodeSize uint16) [][2]uint64 {
+	n := numItems
+	numNodes := n
+	levelNumNodes := []uint64{n}
+	for {
+		n = (n + uint64(nodeSize) - 1) / uint64(nodeSize)
+		numNodes = numNodes + n
+		levelNumNodes = append(levelNumNodes, n)
+		if n == 1 {
+			break
+		}
+	}
+	var bounds [][2]uint64
+	n = numNodes
+	for _, size := range levelNumNodes {
+		bounds = append(bounds, [2]uint64{n - size, n})
+		n = n - size
+	}
+	return bounds
+}
+
+// indexSize returns the size in bytes of the index
+func indexSize(numItems uint64, nodeSize uint16) uint64 {
+	if numItems == 0 || nodeSize < 2 {
+		return 0
+	}
+	bounds := levelBounds(numItems, nodeSize)
+	return bounds[0][1] * nodeItemSize
+}
+
+// hilbertSort sorts the items on the Hilbert value of the center of their bounding box
+func hilbertSort(items []nodeItem, extent nodeItem) {
+	width := extent.maxX - extent.minX
+	height := extent.maxY - extent.minY
+	value := func(n nodeItem) uint64 {
+		var x, y uint32
+		if width > 0 {
+			x = uint32(math.Floor(hilbertMax * ((n.minX+n.maxX)/2 - extent.minX) / width))
+		}
+		if height > 0 {
+			y = uint32(math.Floor(hilbertMax * ((n.minY+n.maxY)/2 - extent.minY) / height))
+		}
+		return pkg.Hilbert(x, y, hilbertBits)
+	}
+	values := make([]uint64, len(items))
+	for i := range items {
+		values[i] = value(items[i])
+	}
+	sort.Sort(byHilbert{items, values})
+}
+
+type byHilbert struct {
+	items  []nodeItem
+	values []uint64
+}
+
+func (h byHilbert) Len() int           { return len(h.items) }
+func (h byHilbert) Less(i, j int) bool { return h.values[i] > h.values[j] }
+func (h byHilbert) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.values[i], h.values[j] = h.values[j], h.values[i]
+}
+
+// writeIndex builds the packed Hilbert R-tree from the sorted leaves and writes it
+func writeIndex(w io.Writer, leaves []nodeItem, nodeSize uint16) error {
+	bounds := levelBounds(uint64(len(leaves)), nodeSize)
+	nodes := make([]nodeItem, bounds[0][1])
+	copy(nodes[bounds[0][0]:], leaves)
+
+	for i := 0; i < len(bounds)-1; i++ {
+		pos := bounds[i+1][0]
+		for start := bounds[i][0]; start < bounds[i][1]; start = start + uint64(nodeSize) {
+			parent := emptyNodeItem()
+			parent.offset = start
+			for j := start; j < start+uint64(nodeSize) && j < bounds[i][1]; j++ {
+				parent.expand(nodes[j])
+			}
+			nodes[pos] = parent
+			pos++
+		}
+	}
+
+	for _, n := range nodes {
+		if err := n.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package fgb
+
+import (
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// The FlatGeobuf schema, https://github.com/flatgeobuf/flatgeobuf/tree/master/src/fbs
+// only the fields used by sieve are accessible
+
+// magicBytes identify a FlatGeobuf file, version 3.0
+var magicBytes = []byte{0x66, 0x67, 0x62, 0x03, 0x66, 0x67, 0x62, 0x00}
+
+// GeometryType
+const (
+	geometryTypeUnknown            = 0
+	geometryTypePoint              = 1
+	geometryTypeLineString         = 2
+	geometryTypePolygon            = 3
+	geometryTypeMultiPoint         = 4
+	geometryTypeMultiLineString    = 5
+	geometryTypeMultiPolygon       = 6
+	geometryTypeGeometryCollection = 7
+)
+
+// ColumnType
+const (
+	columnTypeByte     = 0
+	columnTypeUByte    = 1
+	columnTypeBool     = 2
+	columnTypeShort    = 3
+	columnTypeUShort   = 4
+	columnTypeInt      = 5
+	columnTypeUInt     = 6
+	columnTypeLong     = 7
+	columnTypeULong    = 8
+	columnTypeFloat    = 9
+	columnTypeDouble   = 10
+	columnTypeString   = 11
+	columnTypeJSON     = 12
+	columnTypeDateTime = 13
+	columnTypeBinary   = 14
+)
+
+// vtable offsets of the Header fields
+const (
+	headerName          = 4
+	headerEnvelope      = 6
+	headerGeometryType  = 8
+	headerColumns       = 18
+	headerFeaturesCount = 20
+	headerIndexNodeSize = 22
+	headerCrs           = 24
+	headerFields        = 14
+)
+
+// vtable offsets of the Crs fields
+const (
+	crsOrg         = 4
+	crsCode        = 6
+	crsName        = 8
+	crsDescription = 10
+	crsWkt         = 12
+	crsFields      = 6
+)
+
+// vtable offsets of the Column fields
+const (
+	columnName       = 4
+	columnType       = 6
+	columnNullable   = 18
+	columnPrimaryKey = 22
+	columnFields     = 11
+)
+
+// vtable offsets of the Geometry fields
+const (
+	geometryEnds   = 4
+	geometryXY     = 6
+	geometryType   = 16
+	geometryParts  = 18
+	geometryFields = 8
+)
+
+// vtable offsets of the Feature fields
+const (
+	featureGeometry   = 4
+	featureProperties = 6
+	featureFields     = 3
+)
+
+// slot returns the index of a field in the object from its vtable offset
+func slot(vtableOffset int) int {
+	return (vtableOffset - 4) / 2
+}
+
+// table wraps a flatbuffers.Table with accessors based on vtable offsets
+type table struct {
+	flatbuffers.Table
+}
+
+// root returns the root table of a finished flatbuffer
+func root(buf []byte) table {
+	n := flatbuffers.GetUOffsetT(buf)
+	return table{flatbuffers.Table{Bytes: buf, Pos: n}}
+}
+
+func (t table) has(field flatbuffers.VOffsetT) bool {
+	return t.Offset(field) != 0
+}
+
+func (t table) string(field flatbuffers.VOffsetT) string {
+	if o := flatbuffers.UOffsetT(t.Offset(field)); o != 0 {
+		return t.String(t.Pos + o)
+	}
+	return ``
+}
+
+func (t table) bytes(field flatbuffers.VOffsetT) []byte {
+	if o := flatbuffers.UOffsetT(t.Offset(field)); o != 0 {
+		return t.ByteVector(t.Pos + o)
+	}
+	return nil
+}
+
+func (t table) table(field flatbuffers.VOffsetT) (table, bool) {
+	if o := flatbuffers.UOffsetT(t.Offset(field)); o != 0 {
+		return table{flatbuffers.Table{Bytes: t.Bytes, Pos: t.Indirect(t.Pos + o)}}, true
+	}
+	return table{}, false
+}
+
+func (t table) tables(field flatbuffers.VOffsetT) []table {
+	o := flatbuffers.UOffsetT(t.Offset(field))
+	if o == 0 {
+		return nil
+	}
+	n := t.VectorLen(o)
+	start := t.Vector(o)
+	tables := make([]table, n)
+	for i := range tables {
+		pos := start + flatbuffers.UOffsetT(i*4)
+		tables[i] = table{flatbuffers.Table{Bytes: t.Bytes, Pos: t.Indirect(pos)}}
+	}
+	return tables
+}
+
+func (t table) float64s(field flatbuffers.VOffsetT) []float64 {
+	o := flatbuffers.UOffsetT(t.Offset(field))
+	if o == 0 {
+		return nil
+	}
+	n := t.VectorLen(o)
+	start := t.Vector(o)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = t.GetFloat64(start + flatbuffers.UOffsetT(i*8))
+	}
+	return values
+}
+
+func (t table) uint32s(field flatbuffers.VOffsetT) []uint32 {
+	o := flatbuffers.UOffsetT(t.Offset(field))
+	if o == 0 {
+		return nil
+	}
+	n := t.VectorLen(o)
+	start := t.Vector(o)
+	values := make([]uint32, n)
+	for i := range values {
+		values[i] = t.GetUint32(start + flatbuffers.UOffsetT(i*4))
+	}
+	return values
+}
+
+// header is the decoded FlatGeobuf Header
+type header struct {
+	name          string
+	envelope      []float64
+	geometryType  byte
+	columns       []column
+	featuresCount uint64
+	indexNodeSize uint16
+	crs           crs
+}
+
+type crs struct {
+	org         string
+	code        int32
+	name        string
+	description string
+	wkt         string
+}
+
+type column struct {
+	name       string
+	ctype      byte
+	nullable   bool
+	primaryKey bool
+}
+
+func decodeHeader(buf []byte) header {
+	t := root(buf)
+	h := header{
+		name:          t.string(headerName),
+		envelope:      t.float64s(headerEnvelope),
+		geometryType:  t.GetByteSlot(headerGeometryType, geometryTypeUnknown),
+		featuresCount: t.GetUint64Slot(headerFeaturesCount, 0),
+		indexNodeSize: t.GetUint16Slot(headerIndexNodeSize, 16),
+	}
+	for _, c := range t.tables(headerColumns) {
+		h.columns = append(h.columns, column{
+			name:       c.string(columnName),
+			ctype:      c.GetByteSlot(columnType, columnTypeByte),
+			nullable:   c.GetBoolSlot(columnNullable, true),
+			primaryKey: c.GetBoolSlot(columnPrimaryKey, false),
+		})
+	}
+	if c, ok := t.table(headerCrs); ok {
+		h.crs = crs{
+			org:         c.string(crsOrg),
+			code:        c.GetInt32Slot(crsCode, 0),
+			name:        c.string(crsName),
+			description: c.string(crsDescription),
+			wkt:         c.string(crsWkt),
+		}
+	}
+	return h
+}
+
+func encodeHeader(h header) []byte {
+	b := flatbuffers.NewBuilder(1024)
+
+	var columns []flatbuffers.UOffsetT
+	for _, c := range h.columns {
+		name := b.CreateString(c.name)
+		b.StartObject(columnFields)
+		b.PrependUOffsetTSlot(slot(columnName), name, 0)
+		b.PrependByteSlot(slot(columnType), c.ctype, columnTypeByte)
+		b.PrependBoolSlot(slot(columnNullable), c.nullable, true)
+		b.PrependBoolSlot(slot(columnPrimaryKey), c.primaryKey, false)
+		columns = append(columns, b.EndObject())
+	}
+	b.StartVector(4, len(columns), 4)
+	for i := len(columns) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(columns[i])
+	}
+	columnsVector := b.EndVector(len(columns))
+
+	org := b.CreateString(h.crs.org)
+	crsNameString := b.CreateString(h.crs.name)
+	description := b.CreateString(h.crs.description)
+	wkt := b.CreateString(h.crs.wkt)
+	b.StartObject(crsFields)
+	b.PrependUOffsetTSlot(slot(crsOrg), org, 0)
+	b.PrependInt32Slot(slot(crsCode), h.crs.code, 0)
+	b.PrependUOffsetTSlot(slot(crsName), crsNameString, 0)
+	b.PrependUOffsetTSlot(slot(crsDescription), description, 0)
+	b.PrependUOffsetTSlot(slot(crsWkt), wkt, 0)
+	crsTable := b.EndObject()
+
+	envelope := createFloat64s(b, h.envelope)
+	name := b.CreateString(h.name)
+
+	b.StartObject(headerFields)
+	b.PrependUOffsetTSlot(slot(headerName), name, 0)
+	if envelope != 0 {
+		b.PrependUOffsetTSlot(slot(headerEnvelope), envelope, 0)
+	}
+	b.PrependByteSlot(slot(headerGeometryType), h.geometryType, geometryTypeUnknown)
+	b.PrependUOffsetTSlot(slot(headerColumns), columnsVector, 0)
+	b.PrependUint64Slot(slot(headerFeaturesCount), h.featuresCount, 0)
+	b.PrependUint16Slot(slot(headerIndexNodeSize), h.indexNodeSize, 16)
+	b.PrependUOffsetTSlot(slot(headerCrs), crsTable, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+func createFloat64s(b *flatbuffers.Builder, values []float64) flatbuffers.UOffsetT {
+	if len(values) == 0 {
+		return 0
+	}
+	b.StartVector(8, len(values), 8)
+	for i := len(values) - 1; i >= 0; i-- {
+		b.PrependFloat64(values[i])
+	}
+	return b.EndVector(len(values))
+}
+
+func createUint32s(b *flatbuffers.Builder, values []uint32) flatbuffers.UOffsetT {
+	if len(values) == 0 {
+		return 0
+	}
+	b.StartVector(4, len(values), 4)
+	for i := len(values) - 1; i >= 0; i-- {
+		b.PrependUint32(values[i])
+	}
+	return b.EndVector(len(values))
+}
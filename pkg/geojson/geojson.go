@@ -0,0 +1,687 @@
+package geojson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/go-spatial/geom"
+	spatialjson "github.com/go-spatial/geom/encoding/geojson"
+	"github.com/pdok/sieve/pkg"
+)
+
+// fidColumn is the primary key column, filled with the id of the feature when it
+// is an integer, otherwise with the sequence number of the feature
+const fidColumn = `fid`
+
+// geometryColumn is the name of the geometry column
+const geometryColumn = `geom`
+
+// recordSeparator precedes every feature in a GeoJSON Text Sequence (RFC 8142)
+const recordSeparator = 0x1e
+
+type featureGeoJSON struct {
+	columns  []interface{}
+	geometry geom.Geometry
+}
+
+func (f featureGeoJSON) Columns() []interface{} {
+	return f.columns
+}
+
+func (f featureGeoJSON) Geometry() geom.Geometry {
+	return f.geometry
+}
+
+func (f *featureGeoJSON) UpdateGeometry(geometry geom.Geometry) {
+	f.geometry = geometry
+}
+
+// object is a GeoJSON Feature, the name is the name of the FeatureCollection it is part of
+type object struct {
+	Type       string
+	Name       string
+	ID         json.RawMessage
+	Geometry   json.RawMessage
+	Properties json.RawMessage
+}
+
+// ParseCRS parses a CRS like EPSG:28992 into a SpatialReferenceSystem, GeoJSON itself
+// has no CRS so the CRS of the features is given on the command line
+func ParseCRS(s string) (pkg.SpatialReferenceSystem, error) {
+	if s == `` {
+		s = `EPSG:4326`
+	}
+	parts := strings.Split(s, `:`)
+	if len(parts) != 2 {
+		return pkg.SpatialReferenceSystem{}, fmt.Errorf("invalid CRS: %s, expected like EPSG:4326", s)
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return pkg.SpatialReferenceSystem{}, fmt.Errorf("invalid CRS: %s, expected like EPSG:4326", s)
+	}
+	organization := strings.ToUpper(parts[0])
+	return pkg.SpatialReferenceSystem{
+		Name:                   organization + `:` + parts[1],
+		ID:                     code,
+		Organization:           organization,
+		OrganizationCoordsysID: code,
+		Definition:             `undefined`,
+	}, nil
+}
+
+// SourceGeoJSON reads GeoJSON Features, newline-delimited or as a GeoJSON Text Sequence,
+// or FeatureCollections. The columns are inferred from the first features, which are kept
+// in memory, the other features are streamed.
+type SourceGeoJSON struct {
+	Table pkg.Table
+	r     io.Reader
+	// sample are the features the columns are inferred from
+	sample []object
+	// ids is set when the features of the sample have an integer id
+	ids bool
+	// columns are the positions of the properties in the columns of a feature
+	columns map[string]int
+	stream  *stream
+}
+
+// stream is the state of reading the source, which is shared by the copies of the source
+type stream struct {
+	decoder *objectDecoder
+	// read is set when the features have been read, the source is read again from the start
+	read bool
+	// ignored are the properties that are not in the columns, logged once
+	ignored map[string]bool
+}
+
+// Init reads the first features from r to infer the columns, name is used as table name unless a
+// FeatureCollection names it. The reader is closed on Close when it is a closer.
+func (source *SourceGeoJSON) Init(r io.Reader, name string, srs pkg.SpatialReferenceSystem, sample int) {
+	source.r = r
+	source.stream = &stream{decoder: newObjectDecoder(r), ignored: make(map[string]bool)}
+
+	s := schema{ids: true}
+	for len(source.sample) < sample {
+		o, err := source.stream.decoder.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error reading GeoJSON: %s", err)
+		}
+		if o.Name != `` && len(source.sample) == 0 {
+			name = o.Name
+		}
+		if err = s.add(o); err != nil {
+			log.Fatalf("error reading GeoJSON: feature %d: %s", len(source.sample)+1, err)
+		}
+		source.sample = append(source.sample, o)
+	}
+
+	source.ids = s.ids
+	source.Table, source.columns = s.table(name, srs)
+}
+
+func (source SourceGeoJSON) Close() {
+	if closer, ok := source.r.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+func (source *SourceGeoJSON) SetTable(table pkg.Table) {
+	source.Table = table
+}
+
+func (source SourceGeoJSON) GetTableInfo() []pkg.Table {
+	return []pkg.Table{source.Table}
+}
+
+// ReadFeatures emits the features of the sample followed by the rest of the source. When
+// the features are read again, like for every zoom level, the source must be seekable.
+func (source SourceGeoJSON) ReadFeatures(preSieve chan pkg.Feature) {
+	next := source.stream.decoder.next
+	sample := source.sample
+	if source.stream.read {
+		seeker, ok := source.r.(io.Seeker)
+		if !ok {
+			log.Fatalf("error reading GeoJSON: the source can only be read once")
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			log.Fatalf("error reading GeoJSON again: %s", err)
+		}
+		next = newObjectDecoder(source.r).next
+		sample = nil
+	}
+	source.stream.read = true
+
+	var sequence int64
+	for {
+		var o object
+		var err error
+		if len(sample) > 0 {
+			o, sample = sample[0], sample[1:]
+		} else if o, err = next(); err == io.EOF {
+			break
+		} else if err != nil {
+			log.Fatalf("error reading GeoJSON: %s", err)
+		}
+		sequence++
+		feature, err := source.decodeFeature(o, sequence)
+		if err != nil {
+			log.Fatalf("error reading GeoJSON: feature %d: %s", sequence, err)
+		}
+		preSieve <- feature
+	}
+	close(preSieve)
+}
+
+// decodeFeature converts the properties to the types of the columns, missing properties
+// are returned as nil. Properties that are not in the columns are ignored, but a feature
+// that doesn't fit the primary key or the geometry type of the columns is an error.
+func (source SourceGeoJSON) decodeFeature(o object, sequence int64) (*featureGeoJSON, error) {
+	geometry, err := decodeGeometry(o.Geometry)
+	if err != nil {
+		return nil, err
+	}
+	if gtype := source.Table.GeometryType; geometry != nil && gtype != `GEOMETRY` && geometryType(geometry) != gtype {
+		return nil, fmt.Errorf("geometry type %s differs from the %s of the first %d features, increase the schema sample",
+			geometryType(geometry), gtype, len(source.sample))
+	}
+	keys, values, err := decodeProperties(o.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	f := featureGeoJSON{geometry: geometry}
+	f.columns = make([]interface{}, len(source.Table.Columns)-1)
+	f.columns[0] = sequence
+	if source.ids {
+		if f.columns[0], err = strconv.ParseInt(string(o.ID), 10, 64); err != nil {
+			return nil, fmt.Errorf("id %s is not an integer like the ids of the first %d features, increase the schema sample",
+				o.ID, len(source.sample))
+		}
+	}
+	for i, key := range keys {
+		j, ok := source.columns[key]
+		if !ok {
+			if !source.stream.ignored[key] {
+				slog.Warn(`property is not in the columns and ignored, increase the schema sample`, `table`, source.Table.Name, `property`, key)
+				source.stream.ignored[key] = true
+			}
+			continue
+		}
+		f.columns[j] = convert(values[i], source.Table.Columns[j].Type)
+	}
+	return &f, nil
+}
+
+// convert converts a decoded JSON value to a value of the column type
+func convert(value interface{}, columntype string) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		switch columntype {
+		case `INTEGER`:
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+			f, _ := v.Float64()
+			return f
+		case `REAL`:
+			f, _ := v.Float64()
+			return f
+		}
+		return v.String()
+	case bool:
+		if columntype == `BOOLEAN` {
+			return v
+		}
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	default:
+		// objects and arrays are stored as JSON text
+		buf, _ := json.Marshal(v)
+		return string(buf)
+	}
+}
+
+// schema collects the columns and the geometry type of the features
+type schema struct {
+	names        []string
+	types        map[string]string
+	geometryType string
+	ids          bool
+}
+
+func (s *schema) add(o object) error {
+	if s.types == nil {
+		s.types = make(map[string]string)
+	}
+	if _, err := strconv.ParseInt(string(o.ID), 10, 64); err != nil {
+		s.ids = false
+	}
+
+	geometry, err := decodeGeometry(o.Geometry)
+	if err != nil {
+		return err
+	}
+	if geometry != nil {
+		gtype := geometryType(geometry)
+		if s.geometryType == `` {
+			s.geometryType = gtype
+		} else if s.geometryType != gtype {
+			s.geometryType = `GEOMETRY`
+		}
+	}
+
+	keys, values, err := decodeProperties(o.Properties)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if values[i] == nil {
+			if _, ok := s.types[key]; !ok {
+				s.names = append(s.names, key)
+				s.types[key] = ``
+			}
+			continue
+		}
+		columntype := columnType(values[i])
+		previous, ok := s.types[key]
+		switch {
+		case !ok:
+			s.names = append(s.names, key)
+			s.types[key] = columntype
+		case previous == `` || previous == columntype:
+			s.types[key] = columntype
+		case previous == `INTEGER` && columntype == `REAL` || previous == `REAL` && columntype == `INTEGER`:
+			s.types[key] = `REAL`
+		default:
+			s.types[key] = `TEXT`
+		}
+	}
+	return nil
+}
+
+// table returns the table of the schema and the positions of the properties in the columns of a
+// feature. The names of the columns are unique in SQLite, which compares them case-insensitively,
+// so a property named like another column is renamed by appending a number, like fid_1.
+func (s schema) table(name string, srs pkg.SpatialReferenceSystem) (pkg.Table, map[string]int) {
+	t := pkg.Table{
+		Name:           name,
+		GeometryColumn: geometryColumn,
+		GeometryType:   s.geometryType,
+		SRS:            srs,
+	}
+	if t.GeometryType == `` {
+		t.GeometryType = `GEOMETRY`
+	}
+	t.Columns = append(t.Columns, pkg.Column{Name: fidColumn, Type: `INTEGER`, NotNull: true, PrimaryKey: true})
+	used := map[string]bool{fidColumn: true, geometryColumn: true}
+	columns := make(map[string]int)
+	for _, n := range s.names {
+		columntype := s.types[n]
+		if columntype == `` {
+			columntype = `TEXT`
+		}
+		column := n
+		for i := 1; used[strings.ToLower(column)]; i++ {
+			column = fmt.Sprintf("%s_%d", n, i)
+		}
+		if column != n {
+			slog.Warn(`property is renamed`, `table`, name, `property`, n, `column`, column)
+		}
+		used[strings.ToLower(column)] = true
+		columns[n] = len(t.Columns)
+		t.Columns = append(t.Columns, pkg.Column{Name: column, Type: columntype})
+	}
+	t.Columns = append(t.Columns, pkg.Column{Name: geometryColumn, Type: t.GeometryType})
+	return t, columns
+}
+
+// columnType returns the column type of a decoded JSON value
+func columnType(value interface{}) string {
+	switch v := value.(type) {
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return `INTEGER`
+		}
+		return `REAL`
+	case bool:
+		return `BOOLEAN`
+	default:
+		return `TEXT`
+	}
+}
+
+// geometryType returns the GeoPackage geometry type name of a geometry
+func geometryType(geometry geom.Geometry) string {
+	switch geometry.(type) {
+	case geom.Point:
+		return `POINT`
+	case geom.LineString:
+		return `LINESTRING`
+	case geom.Polygon:
+		return `POLYGON`
+	case geom.MultiPoint:
+		return `MULTIPOINT`
+	case geom.MultiLineString:
+		return `MULTILINESTRING`
+	case geom.MultiPolygon:
+		return `MULTIPOLYGON`
+	case geom.Collection:
+		return `GEOMETRYCOLLECTION`
+	default:
+		return `GEOMETRY`
+	}
+}
+
+// objectDecoder decodes the Features in a reader, which are separated by whitespace or record
+// separators. The Features of a FeatureCollection are decoded one by one, the name of the
+// FeatureCollection is only known when it precedes the features.
+type objectDecoder struct {
+	decoder *json.Decoder
+	// collection is the FeatureCollection whose features are being decoded
+	collection *object
+}
+
+func newObjectDecoder(r io.Reader) *objectDecoder {
+	return &objectDecoder{decoder: json.NewDecoder(&skipRecordSeparators{bufio.NewReader(r)})}
+}
+
+// next returns the next Feature, io.EOF at the end of the reader
+func (d *objectDecoder) next() (object, error) {
+	for {
+		if d.collection != nil {
+			if d.decoder.More() {
+				var raw json.RawMessage
+				if err := d.decoder.Decode(&raw); err != nil {
+					return object{}, err
+				}
+				f, err := decodeObject(raw)
+				if err != nil {
+					return object{}, err
+				}
+				if f.Type != `Feature` {
+					return object{}, fmt.Errorf("unexpected type %s in FeatureCollection", f.Type)
+				}
+				f.Name = d.collection.Name
+				return f, nil
+			}
+			// the end of the features, the members after them are read as well
+			if _, err := d.decoder.Token(); err != nil {
+				return object{}, err
+			}
+			o := d.collection
+			d.collection = nil
+			if err := d.members(o); err != nil {
+				return object{}, err
+			}
+			if o.Type != `FeatureCollection` {
+				return object{}, fmt.Errorf("unexpected type: %s with features, expected FeatureCollection", o.Type)
+			}
+			continue
+		}
+
+		t, err := d.decoder.Token()
+		if err != nil {
+			return object{}, err
+		}
+		if t != json.Delim('{') {
+			return object{}, fmt.Errorf("unexpected %v, expected a Feature or FeatureCollection", t)
+		}
+		var o object
+		if err = d.members(&o); err != nil {
+			return object{}, err
+		}
+		switch {
+		case d.collection != nil:
+			continue
+		case o.Type == `Feature`:
+			return o, nil
+		case o.Type == `FeatureCollection`:
+			// a FeatureCollection without features
+			continue
+		default:
+			return object{}, fmt.Errorf("unexpected type: %s, expected Feature or FeatureCollection", o.Type)
+		}
+	}
+}
+
+// members reads the members of an object up to and including the closing brace. When the
+// member features is found the reading stops at its opening bracket, so the features
+// can be decoded one by one.
+func (d *objectDecoder) members(o *object) error {
+	for d.decoder.More() {
+		t, err := d.decoder.Token()
+		if err != nil {
+			return err
+		}
+		if t == `features` {
+			if t, err = d.decoder.Token(); err != nil {
+				return err
+			}
+			if t != json.Delim('[') {
+				return errors.New("features are not an array")
+			}
+			d.collection = o
+			return nil
+		}
+		var raw json.RawMessage
+		if err = d.decoder.Decode(&raw); err != nil {
+			return err
+		}
+		if err = o.set(t.(string), raw); err != nil {
+			return err
+		}
+	}
+	_, err := d.decoder.Token()
+	return err
+}
+
+// set sets a member of the object, unknown members are ignored
+func (o *object) set(key string, raw json.RawMessage) error {
+	switch key {
+	case `type`:
+		return json.Unmarshal(raw, &o.Type)
+	case `name`:
+		return json.Unmarshal(raw, &o.Name)
+	case `id`:
+		o.ID = raw
+	case `geometry`:
+		o.Geometry = raw
+	case `properties`:
+		o.Properties = raw
+	}
+	return nil
+}
+
+// decodeObject decodes a single object, like a Feature of a FeatureCollection
+func decodeObject(raw json.RawMessage) (object, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil {
+		return object{}, err
+	}
+	var o object
+	for key, value := range members {
+		if err := o.set(key, value); err != nil {
+			return object{}, err
+		}
+	}
+	return o, nil
+}
+
+// skipRecordSeparators removes the record separators of a GeoJSON Text Sequence
+type skipRecordSeparators struct {
+	r *bufio.Reader
+}
+
+func (s *skipRecordSeparators) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	return copy(p, bytes.ReplaceAll(p[:n], []byte{recordSeparator}, []byte{' '})), err
+}
+
+func decodeGeometry(raw json.RawMessage) (geom.Geometry, error) {
+	if len(raw) == 0 || string(raw) == `null` {
+		return nil, nil
+	}
+	var g spatialjson.Geometry
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, err
+	}
+	return g.Geometry, nil
+}
+
+// decodeProperties decodes the properties keeping the order of the keys,
+// numbers are decoded as json.Number
+func decodeProperties(raw json.RawMessage) ([]string, []interface{}, error) {
+	if len(raw) == 0 || string(raw) == `null` {
+		return nil, nil, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if t, err := decoder.Token(); err != nil || t != json.Delim('{') {
+		return nil, nil, errors.New("properties are not an object")
+	}
+
+	var keys []string
+	var values []interface{}
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		var value interface{}
+		if err = decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, t.(string))
+		values = append(values, value)
+	}
+	return keys, values, nil
+}
+
+// TargetGeoJSON writes the features as newline-delimited GeoJSON, the primary key
+// becomes the id of the feature and the other columns the properties. All tables
+// are written to the same stream.
+type TargetGeoJSON struct {
+	Table pkg.Table
+	w     *bufio.Writer
+	close func() error
+}
+
+// Init sets the writer the features are written to, the closer is called on Close
+func (target *TargetGeoJSON) Init(w io.Writer, closer io.Closer) {
+	target.w = bufio.NewWriter(w)
+	target.close = func() error {
+		if err := target.w.Flush(); err != nil {
+			return err
+		}
+		if closer != nil {
+			return closer.Close()
+		}
+		return nil
+	}
+}
+
+func (target TargetGeoJSON) Close() {
+	if err := target.close(); err != nil {
+		log.Fatalf("error writing GeoJSON: %s", err)
+	}
+}
+
+func (target *TargetGeoJSON) SetTable(table pkg.Table) {
+	target.Table = table
+}
+
+func (target TargetGeoJSON) CreateTables(tables []pkg.Table) error {
+	return nil
+}
+
+func (target TargetGeoJSON) WriteFeatures(postSieve chan pkg.Feature) {
+	for {
+		feature, hasMore := <-postSieve
+		if !hasMore {
+			break
+		}
+		buf, err := target.encodeFeature(feature)
+		if err != nil {
+			log.Fatalf("error encoding feature: %s", err)
+		}
+		target.w.Write(buf)
+		if err = target.w.WriteByte('\n'); err != nil {
+			log.Fatalf("error writing GeoJSON: %s", err)
+		}
+	}
+	if err := target.w.Flush(); err != nil {
+		log.Fatalf("error writing GeoJSON: %s", err)
+	}
+}
+
+// encodeFeature encodes the feature as a single line, the properties are written
+// in the order of the columns
+func (target TargetGeoJSON) encodeFeature(feature pkg.Feature) ([]byte, error) {
+	var properties bytes.Buffer
+	var id []byte
+	properties.WriteByte('{')
+	// the columns of the feature don't include the geometry column, which isn't always the last column
+	var columns []pkg.Column
+	for _, c := range target.Table.Columns {
+		if c.Name != target.Table.GeometryColumn {
+			columns = append(columns, c)
+		}
+	}
+	for i, value := range feature.Columns() {
+		if i >= len(columns) {
+			break
+		}
+		buf, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if columns[i].PrimaryKey && id == nil {
+			id = buf
+			continue
+		}
+		if properties.Len() > 1 {
+			properties.WriteByte(',')
+		}
+		key, _ := json.Marshal(columns[i].Name)
+		properties.Write(key)
+		properties.WriteByte(':')
+		properties.Write(buf)
+	}
+	properties.WriteByte('}')
+
+	geometry := []byte(`null`)
+	if feature.Geometry() != nil {
+		var err error
+		geometry, err = json.Marshal(spatialjson.Geometry{Geometry: feature.Geometry()})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"Feature",`)
+	if id != nil {
+		buf.WriteString(`"id":`)
+		buf.Write(id)
+		buf.WriteByte(',')
+	}
+	buf.WriteString(`"geometry":`)
+	buf.Write(geometry)
+	buf.WriteString(`,"properties":`)
+	buf.Write(properties.Bytes())
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,260 @@
+package geojson
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestSieve(t *testing.T) {
+	var tests = []struct {
+		input    string
+		sample   int
+		options  pkg.Options
+		expected string
+	}{
+		// 0 small interior and small polygon are sieved
+		{input: `{"type":"Feature","id":7,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,10],[10,10],[10,0],[0,0]],[[1,1],[1,2],[2,2],[2,1],[1,1]]]},"properties":{"name":"a","n":1}}
+{"type":"Feature","id":8,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[1,0],[0,0]]]},"properties":{"name":"b","n":1.5}}
+`,
+			options: pkg.Options{Resolution: 4},
+			expected: `{"type":"Feature","id":7,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,10],[10,10],[10,0],[0,0]]]},"properties":{"name":"a","n":1}}
+`},
+		// 1 FeatureCollection, features without id are numbered, non-polygons are kept
+		{input: `{"type":"FeatureCollection","features":[
+{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"flag":true}},
+{"type":"Feature","geometry":null,"properties":{"flag":false,"extra":{"a":1}}}]}`,
+			options: pkg.Options{Resolution: 4},
+			expected: `{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"flag":true,"extra":null}}
+{"type":"Feature","id":2,"geometry":null,"properties":{"flag":false,"extra":"{\"a\":1}"}}
+`},
+		// 2 GeoJSON Text Sequence with the largest policy
+		{input: "\x1e" + `{"type":"Feature","id":1,"geometry":{"type":"MultiPolygon","coordinates":[[[[0,0],[0,1],[1,1],[1,0],[0,0]]],[[[5,5],[5,7],[7,7],[7,5],[5,5]]]]},"properties":{}}` + "\n",
+			options: pkg.Options{Resolution: 10, Policy: pkg.PolicyLargest},
+			expected: `{"type":"Feature","id":1,"geometry":{"type":"MultiPolygon","coordinates":[[[[5,5],[5,7],[7,7],[7,5],[5,5]]]]},"properties":{}}
+`},
+		// 3 computed columns are appended after the geometry column
+		{input: `{"type":"Feature","id":7,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,10],[10,10],[10,0],[0,0]],[[1,1],[1,2],[2,2],[2,1],[1,1]]]},"properties":{"name":"a"}}
+`,
+			options: pkg.Options{Resolution: 4, Computed: []pkg.Computed{pkg.ComputedOriginalArea, pkg.ComputedRemovedHoles}},
+			expected: `{"type":"Feature","id":7,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,10],[10,10],[10,0],[0,0]]]},"properties":{"name":"a","original_area":99,"removed_holes":1}}
+`},
+		// 4 annotated features are all kept as-is
+		{input: `{"type":"Feature","id":8,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,3],[3,3],[3,0],[0,0]],[[1,1],[1,2],[2,2],[2,1],[1,1]]]},"properties":{}}
+{"type":"Feature","id":9,"geometry":{"type":"Point","coordinates":[1,1]},"properties":{}}
+`,
+			options: pkg.Options{Resolution: 4, Annotate: true, Computed: pkg.Annotated(nil)},
+			expected: `{"type":"Feature","id":8,"geometry":{"type":"Polygon","coordinates":[[[0,0],[0,3],[3,3],[3,0],[0,0]],[[1,1],[1,2],[2,2],[2,1],[1,1]]]},"properties":{"max_resolution":2.8284271247461903}}
+{"type":"Feature","id":9,"geometry":{"type":"Point","coordinates":[1,1]},"properties":{"max_resolution":null}}
+`},
+		// 5 the features after the sample are streamed, a property not in the sample is ignored
+		{input: `{"type":"FeatureCollection","features":[
+{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"n":1,"fid":"a"}},
+{"type":"Feature","id":2,"geometry":{"type":"Point","coordinates":[3,4]},"properties":{"n":2.5,"extra":true}}]}`,
+			sample:  1,
+			options: pkg.Options{Resolution: 4},
+			expected: `{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"n":1,"fid_1":"a"}}
+{"type":"Feature","id":2,"geometry":{"type":"Point","coordinates":[3,4]},"properties":{"n":2.5,"fid_1":null}}
+`},
+	}
+
+	for k, test := range tests {
+		if test.sample == 0 {
+			test.sample = 1000
+		}
+		source := SourceGeoJSON{}
+		source.Init(strings.NewReader(test.input), `test`, pkg.SpatialReferenceSystem{}, test.sample)
+		var output bytes.Buffer
+		target := TargetGeoJSON{}
+		target.Init(&output, nil)
+		table, err := pkg.AddComputed(source.GetTableInfo()[0], test.options.Computed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target.SetTable(table)
+
+		pkg.Sieve(source, target, test.options)
+		target.Close()
+		source.Close()
+
+		if output.String() != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.expected, output.String())
+		}
+	}
+}
+
+func TestGetTableInfo(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected pkg.Table
+	}{
+		// 0
+		{input: `{"type":"FeatureCollection","name":"mixed","features":[
+{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"a":1,"b":"x","c":null}},
+{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[0,0]]]},"properties":{"a":1.5,"b":2,"d":true}}]}`,
+			expected: pkg.Table{
+				Name: `mixed`,
+				Columns: []pkg.Column{
+					{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+					{Name: `a`, Type: `REAL`},
+					{Name: `b`, Type: `TEXT`},
+					{Name: `c`, Type: `TEXT`},
+					{Name: `d`, Type: `BOOLEAN`},
+					{Name: `geom`, Type: `GEOMETRY`},
+				},
+				GeometryColumn: `geom`,
+				GeometryType:   `GEOMETRY`,
+			}},
+		// 1
+		{input: `{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[0,1],[1,1],[0,0]]]},"properties":null}`,
+			expected: pkg.Table{
+				Name: `test`,
+				Columns: []pkg.Column{
+					{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+					{Name: `geom`, Type: `POLYGON`},
+				},
+				GeometryColumn: `geom`,
+				GeometryType:   `POLYGON`,
+			}},
+		// 2 properties named like the other columns are renamed, the name after the features is not used
+		{input: `{"type":"FeatureCollection","features":[
+{"type":"Feature","geometry":null,"properties":{"fid":1,"GEOM":"x","a":1,"A":2,"a_1":3}}],"name":"late"}`,
+			expected: pkg.Table{
+				Name: `test`,
+				Columns: []pkg.Column{
+					{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+					{Name: `fid_1`, Type: `INTEGER`},
+					{Name: `GEOM_1`, Type: `TEXT`},
+					{Name: `a`, Type: `INTEGER`},
+					{Name: `A_1`, Type: `INTEGER`},
+					{Name: `a_1_1`, Type: `INTEGER`},
+					{Name: `geom`, Type: `GEOMETRY`},
+				},
+				GeometryColumn: `geom`,
+				GeometryType:   `GEOMETRY`,
+			}},
+	}
+
+	for k, test := range tests {
+		source := SourceGeoJSON{}
+		source.Init(strings.NewReader(test.input), `test`, pkg.SpatialReferenceSystem{}, 1000)
+		tables := source.GetTableInfo()
+		source.Close()
+		if !reflect.DeepEqual(tables, []pkg.Table{test.expected}) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, tables)
+		}
+	}
+}
+
+func TestReadFeaturesAgain(t *testing.T) {
+	input := `{"type":"Feature","id":1,"geometry":null,"properties":{}}
+{"type":"Feature","id":2,"geometry":null,"properties":{}}
+{"type":"Feature","id":3,"geometry":null,"properties":{}}`
+	source := SourceGeoJSON{}
+	source.Init(strings.NewReader(input), `test`, pkg.SpatialReferenceSystem{}, 2)
+
+	// the features are read for every zoom level
+	for k := 0; k < 2; k++ {
+		preSieve := make(chan pkg.Feature)
+		go source.ReadFeatures(preSieve)
+		var got []interface{}
+		for feature := range preSieve {
+			got = append(got, feature.Columns()[0])
+		}
+		if expected := []interface{}{int64(1), int64(2), int64(3)}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected, got)
+		}
+	}
+}
+
+func TestDecodeFeature(t *testing.T) {
+	var tests = []struct {
+		input    string
+		err      bool
+		expected []interface{}
+	}{
+		// 0
+		{input: `{"type":"Feature","id":3,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"n":4}}`,
+			expected: []interface{}{int64(3), int64(4)}},
+		// 1 an id that is not an integer like the ids of the sample
+		{input: `{"type":"Feature","id":"x","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"n":4}}`, err: true},
+		// 2 a geometry type that differs from the sample
+		{input: `{"type":"Feature","id":3,"geometry":{"type":"LineString","coordinates":[[1,2],[3,4]]},"properties":{"n":4}}`, err: true},
+	}
+
+	source := SourceGeoJSON{}
+	source.Init(strings.NewReader(`{"type":"Feature","id":1,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"n":1}}`),
+		`test`, pkg.SpatialReferenceSystem{}, 1)
+	for k, test := range tests {
+		o, err := decodeObject([]byte(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := source.decodeFeature(o, 1)
+		if (err != nil) != test.err {
+			t.Errorf("test: %d, expected error: %v \ngot: %v", k, test.err, err)
+			continue
+		}
+		if f != nil && !reflect.DeepEqual(f.columns, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, f.columns)
+		}
+	}
+}
+
+func TestEncodeFeature(t *testing.T) {
+	var tests = []struct {
+		table    pkg.Table
+		feature  featureGeoJSON
+		expected string
+	}{
+		// 0 the geometry column is the last column, like a GeoJSON source
+		{table: pkg.Table{Columns: []pkg.Column{{Name: `fid`, Type: `INTEGER`, PrimaryKey: true}, {Name: `name`, Type: `TEXT`}, {Name: `geom`, Type: `POINT`}}, GeometryColumn: `geom`},
+			feature:  featureGeoJSON{columns: []interface{}{int64(7), `a`}, geometry: geom.Point{1, 2}},
+			expected: `{"type":"Feature","id":7,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"name":"a"}}`},
+		// 1 the geometry column is the second column, like a GeoPackage source
+		{table: pkg.Table{Columns: []pkg.Column{{Name: `fid`, Type: `INTEGER`, PrimaryKey: true}, {Name: `geom`, Type: `POINT`}, {Name: `name`, Type: `TEXT`}, {Name: `n`, Type: `INTEGER`}}, GeometryColumn: `geom`},
+			feature:  featureGeoJSON{columns: []interface{}{int64(7), `a`, int64(3)}, geometry: geom.Point{1, 2}},
+			expected: `{"type":"Feature","id":7,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"name":"a","n":3}}`},
+	}
+
+	for k, test := range tests {
+		target := TargetGeoJSON{Table: test.table}
+		got, err := target.encodeFeature(&test.feature)
+		if err != nil || string(got) != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s %v", k, test.expected, got, err)
+		}
+	}
+}
+
+func TestParseCRS(t *testing.T) {
+	var tests = []struct {
+		crs      string
+		expected int
+		err      bool
+	}{
+		// 0
+		{crs: ``, expected: 4326},
+		// 1
+		{crs: `EPSG:28992`, expected: 28992},
+		// 2
+		{crs: `epsg:3857`, expected: 3857},
+		// 3
+		{crs: `28992`, err: true},
+		// 4
+		{crs: `EPSG:RD`, err: true},
+	}
+
+	for k, test := range tests {
+		srs, err := ParseCRS(test.crs)
+		if (err != nil) != test.err {
+			t.Errorf("test: %d, expected error: %v \ngot: %v", k, test.err, err)
+		}
+		if srs.ID != test.expected {
+			t.Errorf("test: %d, expected: %d \ngot: %d", k, test.expected, srs.ID)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package gpkg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+)
+
+// segmentsPerQuadrant is the number of line segments used for approximating
+// a quarter circle when linearizing curves, equal to PostGIS ST_CurveToLine
+const segmentsPerQuadrant = 32
+
+// WKB geometry type codes, including the curve types from SQL/MM
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+	wkbCircularString     = 8
+	wkbCompoundCurve      = 9
+	wkbCurvePolygon       = 10
+	wkbMultiCurve         = 11
+	wkbMultiSurface       = 12
+)
+
+// decodeGeometry decodes a GeoPackage binary geometry. Geometries the standard WKB decoder
+// cannot handle, like the curve types, are decoded here and linearized:
+// CIRCULARSTRING and COMPOUNDCURVE to a LINESTRING, CURVEPOLYGON to a POLYGON,
+// MULTICURVE to a MULTILINESTRING and MULTISURFACE to a MULTIPOLYGON.
+// Z and M values are dropped.
+func decodeGeometry(data []byte) (geom.Geometry, error) {
+	sb, err := gpkg.DecodeGeometry(data)
+	if err == nil {
+		return sb.Geometry, nil
+	}
+
+	h, herr := gpkg.DecodeBinaryHeader(data)
+	if herr != nil {
+		return nil, herr
+	}
+	r := wkbReader{data: data[h.Size():]}
+	geometry, rerr := r.geometry()
+	if rerr != nil {
+		return nil, fmt.Errorf("%s, %w", err, rerr)
+	}
+	return geometry, nil
+}
+
+// wkbReader reads (extended) WKB geometries
+type wkbReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *wkbReader) read(n int) ([]byte, error) {
+	if r.pos+n > len(r.data) {
+		return nil, errors.New("unexpected end of WKB")
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos = r.pos + n
+	return b, nil
+}
+
+// geometry reads a single WKB geometry, including its byte order and type
+func (r *wkbReader) geometry() (geom.Geometry, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if b[0] == 1 {
+		order = binary.LittleEndian
+	}
+
+	b, err = r.read(4)
+	if err != nil {
+		return nil, err
+	}
+	gtype := order.Uint32(b)
+
+	// ISO and EWKB dimensions
+	dims := 2
+	if gtype&0x80000000 != 0 {
+		dims++
+	}
+	if gtype&0x40000000 != 0 {
+		dims++
+	}
+	if gtype&0x20000000 != 0 {
+		if _, err = r.read(4); err != nil {
+			return nil, err
+		}
+	}
+	gtype = gtype & 0x0fffffff
+	switch gtype / 1000 {
+	case 1, 2:
+		dims++
+	case 3:
+		dims = dims + 2
+	}
+	gtype = gtype % 1000
+
+	switch gtype {
+	case wkbPoint:
+		pts, err := r.coordinates(1, dims, order)
+		if err != nil {
+			return nil, err
+		}
+		return geom.Point(pts[0]), nil
+	case wkbLineString:
+		pts, err := r.points(dims, order)
+		return geom.LineString(pts), err
+	case wkbCircularString:
+		pts, err := r.points(dims, order)
+		if err != nil {
+			return nil, err
+		}
+		return geom.LineString(linearize(pts)), nil
+	case wkbPolygon:
+		n, err := r.count(order)
+		if err != nil {
+			return nil, err
+		}
+		var p geom.Polygon
+		for i := 0; i < n; i++ {
+			pts, err := r.points(dims, order)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, pts)
+		}
+		return p, nil
+	}
+
+	members, err := r.members(order)
+	if err != nil {
+		return nil, err
+	}
+
+	switch gtype {
+	case wkbCompoundCurve:
+		var pts [][2]float64
+		for _, m := range members {
+			ls, ok := m.(geom.LineString)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T in COMPOUNDCURVE", m)
+			}
+			if len(pts) > 0 && len(ls) > 0 && pts[len(pts)-1] == ls[0] {
+				ls = ls[1:]
+			}
+			pts = append(pts, ls...)
+		}
+		return geom.LineString(pts), nil
+	case wkbCurvePolygon:
+		var p geom.Polygon
+		for _, m := range members {
+			ls, ok := m.(geom.LineString)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T in CURVEPOLYGON", m)
+			}
+			p = append(p, ls)
+		}
+		return p, nil
+	case wkbMultiPoint:
+		var mp geom.MultiPoint
+		for _, m := range members {
+			pt, ok := m.(geom.Point)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T in MULTIPOINT", m)
+			}
+			mp = append(mp, pt)
+		}
+		return mp, nil
+	case wkbMultiLineString, wkbMultiCurve:
+		var mls geom.MultiLineString
+		for _, m := range members {
+			ls, ok := m.(geom.LineString)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T in MULTILINESTRING or MULTICURVE", m)
+			}
+			mls = append(mls, ls)
+		}
+		return mls, nil
+	case wkbMultiPolygon, wkbMultiSurface:
+		var mp geom.MultiPolygon
+		for _, m := range members {
+			p, ok := m.(geom.Polygon)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T in MULTIPOLYGON or MULTISURFACE", m)
+			}
+			mp = append(mp, p)
+		}
+		return mp, nil
+	case wkbGeometryCollection:
+		return geom.Collection(members), nil
+	}
+	return nil, fmt.Errorf("unsupported WKB geometry type: %d", gtype)
+}
+
+func (r *wkbReader) count(order binary.ByteOrder) (int, error) {
+	b, err := r.read(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(order.Uint32(b)), nil
+}
+
+// members reads the geometries of a multi geometry, compound curve or collection
+func (r *wkbReader) members(order binary.ByteOrder) ([]geom.Geometry, error) {
+	n, err := r.count(order)
+	if err != nil {
+		return nil, err
+	}
+	var members []geom.Geometry
+	for i := 0; i < n; i++ {
+		m, err := r.geometry()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// points reads a counted list of coordinates
+func (r *wkbReader) points(dims int, order binary.ByteOrder) ([][2]float64, error) {
+	n, err := r.count(order)
+	if err != nil {
+		return nil, err
+	}
+	return r.coordinates(n, dims, order)
+}
+
+// coordinates reads n coordinates, keeping only the x and y
+func (r *wkbReader) coordinates(n int, dims int, order binary.ByteOrder) ([][2]float64, error) {
+	b, err := r.read(n * dims * 8)
+	if err != nil {
+		return nil, err
+	}
+	pts := make([][2]float64, n)
+	for i := range pts {
+		offset := i * dims * 8
+		pts[i][0] = math.Float64frombits(order.Uint64(b[offset:]))
+		pts[i][1] = math.Float64frombits(order.Uint64(b[offset+8:]))
+	}
+	return pts, nil
+}
+
+// linearize approximates the arcs of a CIRCULARSTRING with line segments,
+// every arc is defined by three points where the end point is the start of the next arc
+func linearize(pts [][2]float64) [][2]float64 {
+	if len(pts) < 3 {
+		return pts
+	}
+	linearized := [][2]float64{pts[0]}
+	for i := 2; i < len(pts); i = i + 2 {
+		linearized = append(linearized, arc(pts[i-2], pts[i-1], pts[i])...)
+	}
+	return linearized
+}
+
+// arc returns the points after p0 on the circular arc from p0 through p1 to p2
+func arc(p0, p1, p2 [2]float64) [][2]float64 {
+	var cx, cy, sweep float64
+	if p0 == p2 {
+		// full circle, p1 is opposite of p0
+		cx = (p0[0] + p1[0]) / 2
+		cy = (p0[1] + p1[1]) / 2
+		sweep = 2 * math.Pi
+	} else {
+		d := 2 * (p0[0]*(p1[1]-p2[1]) + p1[0]*(p2[1]-p0[1]) + p2[0]*(p0[1]-p1[1]))
+		if d == 0 {
+			// collinear points
+			return [][2]float64{p1, p2}
+		}
+		s0 := p0[0]*p0[0] + p0[1]*p0[1]
+		s1 := p1[0]*p1[0] + p1[1]*p1[1]
+		s2 := p2[0]*p2[0] + p2[1]*p2[1]
+		cx = (s0*(p1[1]-p2[1]) + s1*(p2[1]-p0[1]) + s2*(p0[1]-p1[1])) / d
+		cy = (s0*(p2[0]-p1[0]) + s1*(p0[0]-p2[0]) + s2*(p1[0]-p0[0])) / d
+
+		sweep = math.Atan2(p2[1]-cy, p2[0]-cx) - math.Atan2(p0[1]-cy, p0[0]-cx)
+		// d is positive for a counter-clockwise arc
+		if d > 0 && sweep <= 0 {
+			sweep = sweep + 2*math.Pi
+		} else if d < 0 && sweep >= 0 {
+			sweep = sweep - 2*math.Pi
+		}
+	}
+
+	radius := math.Hypot(p0[0]-cx, p0[1]-cy)
+	start := math.Atan2(p0[1]-cy, p0[0]-cx)
+	n := int(math.Ceil(math.Abs(sweep) / (math.Pi / 2 / segmentsPerQuadrant)))
+
+	var pts [][2]float64
+	for i := 1; i < n; i++ {
+		a := start + sweep*float64(i)/float64(n)
+		pts = append(pts, [2]float64{cx + radius*math.Cos(a), cy + radius*math.Sin(a)})
+	}
+	return append(pts, p2)
+}
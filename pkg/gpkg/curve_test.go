@@ -0,0 +1,82 @@
+package gpkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+)
+
+// gpkgBinary builds a GeoPackage binary from a header and the given little endian WKB values
+func gpkgBinary(t *testing.T, values ...interface{}) []byte {
+	h, err := gpkg.NewBinaryHeader(binary.LittleEndian, 28992, nil, gpkg.EnvelopeTypeNone, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	if err = h.EncodeTo(&data); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range values {
+		if err = binary.Write(&data, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return data.Bytes()
+}
+
+func TestDecodeGeometry(t *testing.T) {
+	// full circle with radius 10 around 0,0
+	circle := []interface{}{uint8(1), uint32(wkbCircularString), uint32(3), [2]float64{10, 0}, [2]float64{-10, 0}, [2]float64{10, 0}}
+
+	var tests = []struct {
+		data []byte
+		area float64
+	}{
+		// CURVEPOLYGON
+		0: {data: gpkgBinary(t, append([]interface{}{uint8(1), uint32(wkbCurvePolygon), uint32(1)}, circle...)...), area: math.Pi * 100},
+		// MULTISURFACE with a CURVEPOLYGON and a POLYGON
+		1: {data: gpkgBinary(t, append(append([]interface{}{uint8(1), uint32(wkbMultiSurface), uint32(2), uint8(1), uint32(wkbCurvePolygon), uint32(1)}, circle...),
+			uint8(1), uint32(wkbPolygon), uint32(1), uint32(5), [2]float64{20, 20}, [2]float64{30, 20}, [2]float64{30, 30}, [2]float64{20, 30}, [2]float64{20, 20})...), area: math.Pi*100 + 100},
+		// CURVEPOLYGON with a COMPOUNDCURVE of a half circle and a LINESTRING
+		2: {data: gpkgBinary(t, uint8(1), uint32(wkbCurvePolygon), uint32(1), uint8(1), uint32(wkbCompoundCurve), uint32(2),
+			uint8(1), uint32(wkbCircularString), uint32(3), [2]float64{10, 0}, [2]float64{0, 10}, [2]float64{-10, 0},
+			uint8(1), uint32(wkbLineString), uint32(2), [2]float64{-10, 0}, [2]float64{10, 0}), area: math.Pi * 50},
+	}
+
+	for k, test := range tests {
+		geometry, err := decodeGeometry(test.data)
+		if err != nil {
+			t.Errorf("test: %d, expected no error \ngot: %s", k, err)
+			continue
+		}
+		var polygons geom.MultiPolygon
+		switch g := geometry.(type) {
+		case geom.Polygon:
+			polygons = geom.MultiPolygon{g}
+		case geom.MultiPolygon:
+			polygons = g
+		}
+		total := 0.
+		for _, p := range polygons {
+			total = total + ringArea(p[0])
+		}
+		// the linearized circle is slightly smaller
+		if total > test.area || total < test.area*0.999 {
+			t.Errorf("test: %d, expected an area of about: %f \ngot: %f", k, test.area, total)
+		}
+	}
+}
+
+func ringArea(pts [][2]float64) float64 {
+	sum := 0.
+	p0 := pts[len(pts)-1]
+	for _, p1 := range pts {
+		sum += p0[0]*p1[1] - p1[0]*p0[1]
+		p0 = p1
+	}
+	return math.Abs(sum / 2)
+}
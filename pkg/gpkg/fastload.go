@@ -0,0 +1,132 @@
+package gpkg
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/go-spatial/geom/encoding/gpkg"
+)
+
+// FastLoad writes the tables without the RTree triggers, the RTree is populated with the envelopes
+// computed while writing the features and the triggers are installed when a table is written.
+// The SQLite pragmas are used for the duration of the load.
+type FastLoad struct {
+	JournalMode string
+	Synchronous string
+	CacheSize   int
+
+	// journalMode is the journal mode of the target before the load
+	journalMode string
+	// triggers are the CREATE TRIGGER statements of the RTree triggers by table
+	triggers map[string][]string
+}
+
+// ParseJournalMode validates the given string as a SQLite journal mode, an empty string results in MEMORY
+func ParseJournalMode(mode string) (string, error) {
+	switch strings.ToUpper(mode) {
+	case ``:
+		return `MEMORY`, nil
+	case `DELETE`, `TRUNCATE`, `PERSIST`, `MEMORY`, `WAL`, `OFF`:
+		return strings.ToUpper(mode), nil
+	}
+	return ``, fmt.Errorf("unknown journal mode: %s", mode)
+}
+
+// ParseSynchronous validates the given string as a SQLite synchronous setting, an empty string results in OFF
+func ParseSynchronous(synchronous string) (string, error) {
+	switch strings.ToUpper(synchronous) {
+	case ``:
+		return `OFF`, nil
+	case `OFF`, `NORMAL`, `FULL`, `EXTRA`:
+		return strings.ToUpper(synchronous), nil
+	}
+	return ``, fmt.Errorf("unknown synchronous setting: %s", synchronous)
+}
+
+// InitFastLoad switches the target opened with Init to fast loading. The pragmas synchronous and
+// cache_size are set per connection, so the target is limited to a single connection.
+func (target *TargetGeopackage) InitFastLoad(fastLoad FastLoad) {
+	target.handle.SetMaxOpenConns(1)
+
+	err := target.handle.QueryRow(`PRAGMA journal_mode;`).Scan(&fastLoad.journalMode)
+	if err != nil {
+		log.Fatalf("error reading the journal mode: %s", err)
+	}
+	for _, query := range []string{
+		fmt.Sprintf(`PRAGMA journal_mode = %s;`, fastLoad.JournalMode),
+		fmt.Sprintf(`PRAGMA synchronous = %s;`, fastLoad.Synchronous),
+		fmt.Sprintf(`PRAGMA cache_size = %d;`, fastLoad.CacheSize),
+	} {
+		if _, err = target.handle.Exec(query); err != nil {
+			log.Fatalf("error setting the pragmas for fast loading: %s", err)
+		}
+	}
+	fastLoad.triggers = make(map[string][]string)
+	target.fastLoad = &fastLoad
+}
+
+// closeFastLoad restores the journal mode of the target, the other pragmas end with the connection
+func (target TargetGeopackage) closeFastLoad() {
+	_, err := target.handle.Exec(fmt.Sprintf(`PRAGMA journal_mode = %s;`, target.fastLoad.journalMode))
+	if err != nil {
+		log.Fatalf("error restoring the journal mode: %s", err)
+	}
+}
+
+// dropTriggers drops the RTree triggers of the table and returns their CREATE TRIGGER statements
+func dropTriggers(h *gpkg.Handle, table string) ([]string, error) {
+	rows, err := h.Query(`SELECT name, sql FROM sqlite_master WHERE type = 'trigger' AND tbl_name = ? AND name LIKE 'rtree\_%' ESCAPE '\'`, table)
+	if err != nil {
+		return nil, err
+	}
+	var names, triggers []string
+	for rows.Next() {
+		var name, sql string
+		if err = rows.Scan(&name, &sql); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+		triggers = append(triggers, sql)
+	}
+	rows.Close()
+
+	for _, name := range names {
+		if _, err = h.Exec(fmt.Sprintf(`DROP TRIGGER "%v"`, name)); err != nil {
+			return nil, err
+		}
+	}
+	return triggers, nil
+}
+
+// installFastLoadTriggers installs the RTree triggers of the table again, after the table is written
+func (target TargetGeopackage) installFastLoadTriggers() {
+	t := target.Table
+	triggers, ok := target.fastLoad.triggers[t.Name]
+	if !ok {
+		return
+	}
+	if err := installTriggers(target.handle, triggers); err != nil {
+		log.Fatalf("error installing the RTree triggers of %s: %s", t.Name, err)
+	}
+	delete(target.fastLoad.triggers, t.Name)
+}
+
+// installTriggers executes the CREATE TRIGGER statements in a transaction
+func installTriggers(h *gpkg.Handle, triggers []string) error {
+	if len(triggers) == 0 {
+		return nil
+	}
+	tx, err := h.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range triggers {
+		if _, err = tx.Exec(query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,89 @@
+package gpkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestFastLoad(t *testing.T) {
+	handle := openTestGeopackage(t)
+	defer handle.Close()
+
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+	target := TargetGeopackage{Table: table, pagesize: 2, handle: handle}
+	target.InitFastLoad(FastLoad{JournalMode: `OFF`, Synchronous: `OFF`, CacheSize: -2000})
+	if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+		t.Fatal(err)
+	}
+
+	triggers := func() int {
+		var count int
+		if err := handle.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = 'parcels'`).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	if got := triggers(); got != 0 {
+		t.Errorf("expected no triggers while loading \ngot: %d", got)
+	}
+
+	square := func(x, y, size float64) geom.Polygon {
+		return geom.Polygon{{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}}}
+	}
+	postSieve := make(chan pkg.Feature)
+	go func() {
+		for fid := 1; fid <= 3; fid++ {
+			postSieve <- &featureGPKG{columns: []interface{}{int64(fid)}, geometry: square(float64(fid), 0, 1)}
+		}
+		close(postSieve)
+	}()
+	target.WriteFeatures(postSieve)
+
+	if got := triggers(); got != 6 {
+		t.Errorf("expected: 6 triggers after loading \ngot: %d", got)
+	}
+	expected := map[int64][4]float64{1: {1, 2, 0, 1}, 2: {2, 3, 0, 1}, 3: {3, 4, 0, 1}}
+	if got := rtreeEnvelopes(t, handle, `parcels`); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, got)
+	}
+
+	target.closeFastLoad()
+	var mode string
+	if err := handle.QueryRow(`PRAGMA journal_mode;`).Scan(&mode); err != nil || mode != `delete` {
+		t.Errorf("expected the journal mode to be restored to delete \ngot: %s %v", mode, err)
+	}
+}
+
+func TestParseJournalMode(t *testing.T) {
+	var tests = []struct {
+		mode     string
+		expected string
+		err      bool
+	}{
+		// 0
+		{mode: ``, expected: `MEMORY`},
+		// 1
+		{mode: `wal`, expected: `WAL`},
+		// 2
+		{mode: `OFF; DROP TABLE parcels`, err: true},
+	}
+
+	for k, test := range tests {
+		got, err := ParseJournalMode(test.mode)
+		if got != test.expected || (err != nil) != test.err {
+			t.Errorf("test: %d, expected: %s %v \ngot: %s %v", k, test.expected, test.err, got, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package gpkg
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+// minUserVersion is the user_version of GeoPackage 1.2, the first version with the RTree triggers used
+const minUserVersion = 10200
+
+// Finalize is run after all tables are written. It runs ANALYZE and optionally VACUUM, then checks
+// the integrity of the SQLite file and the GeoPackage conformance of the target and the written
+// tables. The violations are returned as error.
+func (target TargetGeopackage) Finalize(tables []pkg.Table, vacuum bool) error {
+	queries := []string{`ANALYZE;`}
+	if vacuum {
+		queries = append(queries, `VACUUM;`)
+	}
+	for _, query := range queries {
+		slog.Info(`finalizing`, `query`, strings.TrimSuffix(query, `;`))
+		if _, err := target.handle.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	violations, err := integrity(target.handle)
+	if err != nil {
+		return err
+	}
+	conformance, err := conformance(target.handle, tables)
+	if err != nil {
+		return err
+	}
+	violations = append(violations, conformance...)
+	if len(violations) > 0 {
+		return fmt.Errorf("%d violations: %s", len(violations), strings.Join(violations, `; `))
+	}
+	return nil
+}
+
+// queryStrings returns the first column of the rows of the query as strings
+func queryStrings(h *gpkg.Handle, query string, args ...interface{}) ([]string, error) {
+	rows, err := h.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// integrity checks the SQLite file with PRAGMA integrity_check and foreign_key_check
+func integrity(h *gpkg.Handle) ([]string, error) {
+	var violations []string
+	results, err := queryStrings(h, `PRAGMA integrity_check;`)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != 1 || results[0] != `ok` {
+		violations = append(violations, results...)
+	}
+	tables, err := queryStrings(h, `SELECT DISTINCT "table" FROM pragma_foreign_key_check;`)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		violations = append(violations, fmt.Sprintf("table %s violates a foreign key", table))
+	}
+	return violations, nil
+}
+
+// conformance checks the requirements of the GeoPackage specification on the file and
+// the extent and RTree of the written tables against their data
+func conformance(h *gpkg.Handle, tables []pkg.Table) ([]string, error) {
+	var violations []string
+
+	var applicationID, userVersion int64
+	if err := h.QueryRow(`PRAGMA application_id;`).Scan(&applicationID); err != nil {
+		return nil, err
+	}
+	if applicationID != gpkg.ApplicationID {
+		violations = append(violations, fmt.Sprintf("application_id is %d, expected %d", applicationID, gpkg.ApplicationID))
+	}
+	if err := h.QueryRow(`PRAGMA user_version;`).Scan(&userVersion); err != nil {
+		return nil, err
+	}
+	if userVersion < minUserVersion {
+		violations = append(violations, fmt.Sprintf("user_version is %d, expected at least %d", userVersion, minUserVersion))
+	}
+
+	existing, err := queryStrings(h, `SELECT name FROM sqlite_master WHERE type IN ('table', 'view');`)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[string]bool)
+	for _, name := range existing {
+		exists[name] = true
+	}
+	for _, required := range []string{`gpkg_spatial_ref_sys`, `gpkg_contents`, `gpkg_geometry_columns`} {
+		if !exists[required] {
+			violations = append(violations, fmt.Sprintf("required table %s is missing", required))
+		}
+	}
+	if len(violations) > 0 {
+		return violations, nil
+	}
+
+	for _, srs := range []int{-1, 0, 4326} {
+		var count int
+		if err = h.QueryRow(`SELECT count(*) FROM gpkg_spatial_ref_sys WHERE srs_id = ?`, srs).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			violations = append(violations, fmt.Sprintf("required srs_id %d is missing in gpkg_spatial_ref_sys", srs))
+		}
+	}
+
+	contents, err := queryStrings(h, `SELECT table_name FROM gpkg_contents;`)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range contents {
+		if !exists[table] {
+			violations = append(violations, fmt.Sprintf("table %s in gpkg_contents does not exist", table))
+		}
+	}
+	missing, err := queryStrings(h, `SELECT table_name FROM gpkg_contents WHERE data_type = 'features'
+		AND table_name NOT IN (SELECT table_name FROM gpkg_geometry_columns);`)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range missing {
+		violations = append(violations, fmt.Sprintf("features table %s is missing in gpkg_geometry_columns", table))
+	}
+	unknown, err := queryStrings(h, `SELECT table_name FROM gpkg_contents WHERE srs_id IS NOT NULL
+		AND srs_id NOT IN (SELECT srs_id FROM gpkg_spatial_ref_sys);`)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range unknown {
+		violations = append(violations, fmt.Sprintf("table %s has an srs_id missing in gpkg_spatial_ref_sys", table))
+	}
+
+	for _, table := range tables {
+		if !exists[table.Name] {
+			violations = append(violations, fmt.Sprintf("written table %s does not exist", table.Name))
+			continue
+		}
+		v, err := checkTable(h, Table(table))
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	return violations, nil
+}
+
+// checkTable checks that the extent in gpkg_contents and the RTree match the data of the table
+func checkTable(h *gpkg.Handle, t Table) ([]string, error) {
+	var violations []string
+
+	var stored [4]*float64
+	err := h.QueryRow(`SELECT min_x, min_y, max_x, max_y FROM gpkg_contents WHERE table_name = ?`, t.Name).
+		Scan(&stored[0], &stored[1], &stored[2], &stored[3])
+	if err != nil {
+		return nil, fmt.Errorf("table %s: %w", t.Name, err)
+	}
+	ext, err := h.CalculateGeometryExtent(t.Name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ext == nil && stored[0] != nil:
+		violations = append(violations, fmt.Sprintf("table %s has an extent in gpkg_contents but no geometries", t.Name))
+	case ext != nil && (stored[0] == nil || stored[1] == nil || stored[2] == nil || stored[3] == nil):
+		violations = append(violations, fmt.Sprintf("table %s has no extent in gpkg_contents", t.Name))
+	case ext != nil:
+		for i, value := range []float64{ext.MinX(), ext.MinY(), ext.MaxX(), ext.MaxY()} {
+			if !equalCoordinate(*stored[i], value) {
+				violations = append(violations, fmt.Sprintf("table %s has the extent %v in gpkg_contents, the data has %v",
+					t.Name, []float64{*stored[0], *stored[1], *stored[2], *stored[3]}, ext[:]))
+				break
+			}
+		}
+	}
+
+	var rtrees int
+	err = h.QueryRow(`SELECT count(*) FROM gpkg_extensions WHERE table_name = ? AND extension_name = 'gpkg_rtree_index'`, t.Name).Scan(&rtrees)
+	if err != nil || rtrees == 0 {
+		return violations, err
+	}
+	var indexed, geometries int
+	err = h.QueryRow(fmt.Sprintf(`SELECT count(*) FROM "rtree_%v_%v"`, t.Name, t.GeometryColumn)).Scan(&indexed)
+	if err != nil {
+		violations = append(violations, fmt.Sprintf("table %s has no RTree: %s", t.Name, err))
+		return violations, nil
+	}
+	g := `"` + t.GeometryColumn + `"`
+	err = h.QueryRow(fmt.Sprintf(`SELECT count(*) FROM "%v" WHERE %v NOT NULL AND NOT ST_IsEmpty(%v)`, t.Name, g, g)).Scan(&geometries)
+	if err != nil {
+		return nil, err
+	}
+	if indexed != geometries {
+		violations = append(violations, fmt.Sprintf("table %s has %d geometries in the RTree, expected %d", t.Name, indexed, geometries))
+	}
+	return violations, nil
+}
+
+// equalCoordinate compares coordinates with a relative tolerance, for the rounding of the stored values
+func equalCoordinate(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
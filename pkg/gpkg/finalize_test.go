@@ -0,0 +1,65 @@
+package gpkg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestFinalize(t *testing.T) {
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+
+	var tests = []struct {
+		corrupt  string
+		vacuum   bool
+		expected string
+	}{
+		// 0
+		{vacuum: true},
+		// 1
+		{corrupt: `UPDATE gpkg_contents SET min_x = -100`, expected: `extent`},
+		// 2
+		{corrupt: `DELETE FROM rtree_parcels_geom WHERE id = 1`, expected: `RTree`},
+		// 3
+		{corrupt: `PRAGMA application_id = 0`, expected: `application_id`},
+		// 4
+		{corrupt: `DELETE FROM gpkg_spatial_ref_sys WHERE srs_id = 4326`, expected: `srs_id 4326`},
+	}
+
+	for k, test := range tests {
+		handle := openTestGeopackage(t)
+		target := TargetGeopackage{Table: table, pagesize: 10, handle: handle}
+		if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+			t.Fatal(err)
+		}
+		target.writeFeatures([]interface{}{
+			&featureGPKG{columns: []interface{}{int64(1)}, geometry: geom.Polygon{{{0, 0}, {2, 0}, {2, 2}, {0, 2}}}},
+			&featureGPKG{columns: []interface{}{int64(2)}, geometry: geom.Polygon{{{5, 5}, {6, 5}, {6, 6}, {5, 6}}}},
+		}, nil)
+		if test.corrupt != `` {
+			if _, err := handle.Exec(test.corrupt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := target.Finalize([]pkg.Table{pkg.Table(table)}, test.vacuum)
+		if test.expected == `` && err != nil {
+			t.Errorf("test: %d, expected no violations \ngot: %s", k, err)
+		}
+		if test.expected != `` && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Errorf("test: %d, expected a violation with: %s \ngot: %v", k, test.expected, err)
+		}
+		handle.Close()
+	}
+}
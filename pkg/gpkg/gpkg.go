@@ -1,19 +1,26 @@
 package gpkg
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
+	"log/slog"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-spatial/geom"
 	"github.com/go-spatial/geom/encoding/gpkg"
 	"github.com/pdok/sieve/pkg"
+	"github.com/pdok/sieve/pkg/metrics"
 )
 
 type featureGPKG struct {
 	columns  []interface{}
 	geometry geom.Geometry
+	// modified is set when the geometry is updated with a different geometry
+	modified bool
 }
 
 func (f featureGPKG) Columns() []interface{} {
@@ -25,42 +32,47 @@ func (f featureGPKG) Geometry() geom.Geometry {
 }
 
 func (f *featureGPKG) UpdateGeometry(geometry geom.Geometry) {
+	f.modified = f.modified || !reflect.DeepEqual(f.geometry, geometry)
 	f.geometry = geometry
 }
 
-type column struct {
-	cid       int
-	name      string
-	ctype     string
-	notnull   int
-	dfltValue *int
-	pk        int
+// Table is the pkg.Table with the GeoPackage specific SQL builders
+type Table pkg.Table
+
+// gtype returns the GeoPackage geometry type of the table
+func (t Table) gtype() gpkg.GeometryType {
+	return geometryTypeFromString(t.GeometryType)
 }
 
-type Table struct {
-	Name    string
-	columns []column
-	gcolumn string
-	gtype   gpkg.GeometryType
-	srs     gpkg.SpatialReferenceSystem
+// srs returns the GeoPackage spatial reference system of the table
+func (t Table) srs() gpkg.SpatialReferenceSystem {
+	return gpkg.SpatialReferenceSystem{
+		Name:                   t.SRS.Name,
+		ID:                     t.SRS.ID,
+		Organization:           t.SRS.Organization,
+		OrganizationCoordsysID: t.SRS.OrganizationCoordsysID,
+		Definition:             t.SRS.Definition,
+		Description:            t.SRS.Description,
+	}
 }
 
-// geometryTypeFromString returns the numeric value of a gometry string
+// geometryTypeFromString returns the numeric value of a gometry string,
+// curve types are returned as the type they are linearized to
 func geometryTypeFromString(geometrytype string) gpkg.GeometryType {
 	switch strings.ToUpper(geometrytype) {
 	case "GEOMETRY":
 		return gpkg.Geometry
 	case "POINT":
 		return gpkg.Point
-	case "LINESTRING":
+	case "LINESTRING", "CIRCULARSTRING", "COMPOUNDCURVE", "CURVE":
 		return gpkg.Linestring
-	case "POLYGON":
+	case "POLYGON", "CURVEPOLYGON", "SURFACE":
 		return gpkg.Polygon
 	case "MULTIPOINT":
 		return gpkg.MultiPoint
-	case "MULTILINESTRING":
+	case "MULTILINESTRING", "MULTICURVE":
 		return gpkg.MultiLinestring
-	case "MULTIPOLYGON":
+	case "MULTIPOLYGON", "MULTISURFACE":
 		return gpkg.MultiPolygon
 	case "GEOMETRYCOLLECTION":
 		return gpkg.GeometryCollection
@@ -72,6 +84,8 @@ func geometryTypeFromString(geometrytype string) gpkg.GeometryType {
 type SourceGeopackage struct {
 	Table  Table
 	handle *gpkg.Handle
+	// after is the primary key after which the features of the table are read, set by ResumeAfter
+	after *int64
 }
 
 func (source *SourceGeopackage) Init(file string) {
@@ -82,9 +96,22 @@ func (source SourceGeopackage) Close() {
 	source.handle.Close()
 }
 
-func (source SourceGeopackage) ReadFeatures(preSieve chan pkg.Feature) {
+func (source *SourceGeopackage) SetTable(table pkg.Table) {
+	source.Table = Table(table)
+	source.after = nil
+}
 
-	rows, err := source.handle.Query(source.Table.selectSQL())
+// ResumeAfter makes ReadFeatures skip the features of the table up to and including the given primary key
+func (source *SourceGeopackage) ResumeAfter(fid int64) {
+	source.after = &fid
+}
+
+func (source SourceGeopackage) ReadFeatures(preSieve chan pkg.Feature) {
+	var args []interface{}
+	if source.after != nil {
+		args = append(args, *source.after)
+	}
+	rows, err := source.handle.Query(source.Table.selectSQL(source.after != nil), args...)
 	if err != nil {
 		log.Fatalf("err during closing rows: %s", err)
 	}
@@ -94,27 +121,27 @@ func (source SourceGeopackage) ReadFeatures(preSieve chan pkg.Feature) {
 		log.Fatalf("error reading the columns: %s", err)
 	}
 
+	// the scan buffers are reused for every row, the values are copied into the feature
+	vals := make([]interface{}, len(cols))
+	valPtrs := make([]interface{}, len(cols))
+	for i := 0; i < len(cols); i++ {
+		valPtrs[i] = &vals[i]
+	}
 	for rows.Next() {
-		vals := make([]interface{}, len(cols))
-		valPtrs := make([]interface{}, len(cols))
-		for i := 0; i < len(cols); i++ {
-			valPtrs[i] = &vals[i]
-		}
-
 		if err = rows.Scan(valPtrs...); err != nil {
 			log.Fatalf("err reading row values: %v", err)
 		}
 		var f featureGPKG
-		var c []interface{}
+		c := make([]interface{}, 0, len(cols)-1)
 
 		for i, colName := range cols {
 			switch colName {
-			case source.Table.gcolumn:
-				wkbgeom, err := gpkg.DecodeGeometry(vals[i].([]byte))
+			case source.Table.GeometryColumn:
+				geometry, err := decodeGeometry(vals[i].([]byte))
 				if err != nil {
 					log.Fatalf("error decoding the geometry: %s", err)
 				}
-				f.geometry = wkbgeom.Geometry
+				f.geometry = geometry
 			default:
 				switch v := vals[i].(type) {
 				case []uint8:
@@ -150,26 +177,44 @@ func (source SourceGeopackage) ReadFeatures(preSieve chan pkg.Feature) {
 	defer rows.Close()
 }
 
-func (source SourceGeopackage) GetTableInfo() []Table {
+// Count returns the number of features of the table, estimated from gpkg_ogr_contents when it is
+// available and otherwise counted. When resuming the features that are left are counted.
+func (source SourceGeopackage) Count() (int64, bool) {
+	var count *int64
+	if source.after == nil {
+		err := source.handle.QueryRow(`SELECT feature_count FROM gpkg_ogr_contents WHERE table_name = ?`, source.Table.Name).Scan(&count)
+		if err == nil && count != nil {
+			return *count, true
+		}
+	}
+	var args []interface{}
+	if source.after != nil {
+		args = append(args, *source.after)
+	}
+	if err := source.handle.QueryRow(source.Table.countSQL(source.after != nil), args...).Scan(&count); err != nil || count == nil {
+		return 0, false
+	}
+	return *count, true
+}
+
+func (source SourceGeopackage) GetTableInfo() []pkg.Table {
 	query := `SELECT table_name, column_name, geometry_type_name, srs_id FROM gpkg_geometry_columns;`
 	rows, err := source.handle.Query(query)
 	if err != nil {
 		log.Fatalf("error during closing rows: %v - %v", query, err)
 	}
-	var tables []Table
+	var tables []pkg.Table
 
 	for rows.Next() {
-		var t Table
-		var gtype string
+		var t pkg.Table
 		var srsID int
-		err := rows.Scan(&t.Name, &t.gcolumn, &gtype, &srsID)
+		err := rows.Scan(&t.Name, &t.GeometryColumn, &t.GeometryType, &srsID)
 		if err != nil {
 			log.Fatalf("error retrieving the source table information: %s", err)
 		}
 
-		t.columns = getTableColumns(source.handle, t.Name)
-		t.gtype = geometryTypeFromString(gtype)
-		t.srs = getSpatialReferenceSystem(source.handle, srsID)
+		t.Columns = getTableColumns(source.handle, t.Name)
+		t.SRS = getSpatialReferenceSystem(source.handle, srsID)
 
 		tables = append(tables, t)
 	}
@@ -181,106 +226,291 @@ type TargetGeopackage struct {
 	Table    Table
 	pagesize int
 	handle   *gpkg.Handle
+	// inPlace updates the features in the source GeoPackage instead of inserting them
+	inPlace bool
+	mode    Mode
+	// fastLoad is set by InitFastLoad
+	fastLoad *FastLoad
+	// resume is set by InitResume
+	resume bool
 }
 
-func (target *TargetGeopackage) Init(file string, pagesize int) {
+func (target *TargetGeopackage) Init(file string, pagesize int, mode Mode) {
 	target.pagesize = pagesize
+	target.mode = mode
 	target.handle = openGeopackage(file)
 }
 
 func (target TargetGeopackage) Close() {
+	if target.fastLoad != nil {
+		target.closeFastLoad()
+	}
+	if err := dropState(target.handle); err != nil {
+		log.Fatalf("error dropping the sieve_state table: %s", err)
+	}
 	target.handle.Close()
 }
 
-func (target TargetGeopackage) CreateTables(tables []Table) error {
+func (target *TargetGeopackage) SetTable(table pkg.Table) {
+	target.Table = Table(table)
+}
+
+func (target TargetGeopackage) CreateTables(tables []pkg.Table) error {
+	if _, err := target.handle.Exec(stateSQL); err != nil {
+		return err
+	}
 	for _, table := range tables {
-		err := target.handle.UpdateSRS(table.srs)
+		state, err := readState(target.handle, table.Name)
 		if err != nil {
 			return err
 		}
+		// a resumed table is continued as it is, a completed one is left alone
+		resumed := target.resume && state.Started
+		if resumed && state.Completed {
+			continue
+		}
+		// sieved in place the tables exist, only their progress is recorded
+		if target.inPlace {
+			if !resumed {
+				if err = startState(target.handle, table.Name); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 
-		err = buildTable(target.handle, table)
-		if err != nil {
-			return err
+		exists := resumed
+		if !resumed {
+			if exists, err = prepareTable(target.handle, Table(table), target.mode); err != nil {
+				return err
+			}
+		}
+		if !exists {
+			err = target.handle.UpdateSRS(Table(table).srs())
+			if err != nil {
+				return err
+			}
+
+			err = buildTable(target.handle, Table(table))
+			if err != nil {
+				return err
+			}
+		}
+
+		if !resumed {
+			if err = startState(target.handle, table.Name); err != nil {
+				return err
+			}
+		}
+
+		if target.fastLoad != nil || (resumed && len(state.triggers) > 0) {
+			triggers, err := dropTriggers(target.handle, table.Name)
+			if err != nil {
+				return err
+			}
+			// the triggers dropped by an interrupted fast load
+			if len(triggers) == 0 && resumed {
+				triggers = state.triggers
+			}
+			if target.fastLoad == nil {
+				if err = installTriggers(target.handle, triggers); err != nil {
+					return err
+				}
+				triggers = nil
+			} else if len(triggers) > 0 {
+				target.fastLoad.triggers[table.Name] = triggers
+			}
+			if err = saveTriggers(target.handle, table.Name, triggers); err != nil {
+				return err
+			}
+		}
+
+		for _, query := range Table(table).indexSQL() {
+			if _, err = target.handle.Exec(query); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
 func (target TargetGeopackage) WriteFeatures(postSieve chan pkg.Feature) {
-	var features []interface{}
+	if target.inPlace {
+		target.updateFeatures(postSieve)
+		return
+	}
+	page := pages.Get().(*[]interface{})
+	features := (*page)[:0]
+	// extent is the extent of all features of the table written so far
+	var extent *geom.Extent
 
 	for {
 		feature, hasMore := <-postSieve
 		if !hasMore {
-			target.writeFeatures(features)
+			extent = target.writeFeatures(features, extent)
+			*page = clearPage(features)
+			pages.Put(page)
+			if target.fastLoad != nil {
+				target.installFastLoadTriggers()
+			}
+			if target.mode == ModeUpsert {
+				// replaced geometries can shrink the extent
+				if err := updateExtent(target.handle, target.Table.Name); err != nil {
+					log.Fatalln("Failed to update extent:", err)
+				}
+			}
+			completeState(target.handle, target.Table.Name)
 			break
 		} else {
 			features = append(features, feature)
 
 			if len(features)%target.pagesize == 0 {
-				target.writeFeatures(features)
-				features = nil
+				extent = target.writeFeatures(features, extent)
+				features = clearPage(features)
 			}
 		}
 	}
 }
 
-func (target TargetGeopackage) writeFeatures(features []interface{}) {
+// pages reuses the pages in which the features are collected before they are written, over the tables
+var pages = sync.Pool{New: func() interface{} { return new([]interface{}) }}
+
+// clearPage empties the page for reuse, without holding on to the written features
+func clearPage(features []interface{}) []interface{} {
+	for i := range features {
+		features[i] = nil
+	}
+	return features[:0]
+}
+
+// writeFeatures writes a page of features in a transaction and returns the extent grown with their
+// envelopes. The envelope of every geometry is computed once, for the extent, the binary header
+// and, when fast loading, the RTree. The extent in gpkg_contents is updated after every page.
+// The primary key of the last feature is recorded in sieve_state in the same transaction.
+func (target TargetGeopackage) writeFeatures(features []interface{}, extent *geom.Extent) *geom.Extent {
 	tx, err := target.handle.Begin()
 	if err != nil {
 		log.Fatalf("Could not start a transaction: %s", err)
 	}
 
-	stmt, err := tx.Prepare(target.Table.insertSQL())
+	// appended features get a new primary key, the features of the source can have the keys of the target
+	pk, err := pkg.PrimaryKeyPosition(pkg.Table(target.Table))
+	if err != nil {
+		pk = -1
+	}
+	omitPrimaryKey := target.mode == ModeAppend && pk >= 0
+	stmt, err := tx.Prepare(target.Table.insertSQL(omitPrimaryKey))
 	if err != nil {
 		log.Fatalf("Could not prepare a statement: %s", err)
 	}
+	var del *sql.Stmt
+	if target.mode == ModeUpsert {
+		if del, err = tx.Prepare(target.Table.deleteSQL()); err != nil {
+			log.Fatalf("Could not prepare a statement: %s", err)
+		}
+	}
 
-	var ext *geom.Extent
+	var rtree *rtreeWriter
+	if target.fastLoad != nil && target.fastLoad.triggers[target.Table.Name] != nil {
+		rtree = newRTreeWriter(tx, target.Table)
+		if omitPrimaryKey {
+			// the id is the primary key assigned by SQLite
+			rtree.primaryKey = -1
+		}
+	}
 
+	// data is reused for the values of every feature, the statement doesn't keep them
+	var data []interface{}
+	var written int
 	for _, feature := range features {
-		f := feature.(*featureGPKG)
-		sb, err := gpkg.NewBinary(int32(target.Table.srs.ID), f.geometry)
+		f := feature.(pkg.Feature)
+		envelope := envelopeOf(f.Geometry())
+		sb, err := newBinary(int32(target.Table.SRS.ID), f.Geometry(), envelope)
 		if err != nil {
 			log.Fatalf("Could not create a binary geometry: %s", err)
 		}
 
-		data := f.columns
-		data = append(data, sb)
-
-		_, err = stmt.Exec(data...)
+		// the binary is encoded here, so its size can be counted
+		encoded, err := sb.Value()
 		if err != nil {
-			var fid interface{} = "unknown"
-			if len(data) > 0 {
-				fid = data[0]
+			log.Fatalf("Could not encode a binary geometry: %s", err)
+		}
+		data = append(data[:0], f.Columns()...)
+		data = append(data, encoded)
+		var fid interface{} = "unknown"
+		if pk >= 0 && pk < len(data)-1 {
+			fid = data[pk]
+		}
+		if del != nil {
+			if _, err = del.Exec(fid); err != nil {
+				log.Fatalf("Could not delete the feature with fid %v: %s", fid, err)
 			}
-			log.Fatalf("Could not get a result summary from the prepared statement for fid %s: %s", fid, err)
 		}
+		if omitPrimaryKey && pk < len(data)-1 {
+			data = append(data[:pk], data[pk+1:]...)
+		}
+		written += valuesSize(data)
 
-		if ext == nil {
-			ext, err = geom.NewExtentFromGeometry(f.geometry)
-			if err != nil {
-				ext = nil
-				log.Println("Failed to create new extent:", err)
-				continue
-			}
+		result, err := stmt.Exec(data...)
+		if err != nil {
+			log.Fatalf("Could not get a result summary from the prepared statement for fid %v: %s", fid, err)
+		}
+		if rtree != nil {
+			rtree.write(data, result, envelope)
+		}
+
+		if envelope == nil {
+			continue
+		}
+		if extent == nil {
+			extent = geom.NewExtent([2]float64{envelope.MinX(), envelope.MinY()}, [2]float64{envelope.MaxX(), envelope.MaxY()})
 		} else {
-			ext.AddGeometry(f.geometry)
+			extent.Add(envelope)
 		}
 	}
+	if rtree != nil {
+		rtree.close()
+	}
+	if del != nil {
+		del.Close()
+	}
 	stmt.Close()
-	tx.Commit()
+	if len(features) > 0 {
+		recordPage(tx, target.Table, features[len(features)-1].(pkg.Feature))
+	}
+	start := time.Now()
+	if err = tx.Commit(); err != nil {
+		log.Fatalf("Could not commit the transaction: %s", err)
+	}
+	metrics.PageCommit.WithLabelValues(target.Table.Name).Observe(time.Since(start).Seconds())
+	metrics.BytesWritten.WithLabelValues(target.Table.Name).Add(float64(written))
 
-	err = target.handle.UpdateGeometryExtent(target.Table.Name, ext)
+	err = target.handle.UpdateGeometryExtent(target.Table.Name, extent)
 	if err != nil {
 		log.Fatalln("Failed to update new extent:", err)
 	}
+	return extent
+}
 
+// valuesSize returns the size of the values in bytes, the size of the numbers as stored by SQLite at most
+func valuesSize(values []interface{}) int {
+	size := 0
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		case nil:
+		default:
+			size += 8
+		}
+	}
+	return size
 }
 
 func openGeopackage(file string) *gpkg.Handle {
-	handle, err := gpkg.Open(file)
+	handle, err := OpenHandle(file)
 	if err != nil {
 		log.Fatalf("error opening GeoPackage: %s", err)
 	}
@@ -290,14 +520,14 @@ func openGeopackage(file string) *gpkg.Handle {
 // createSQL creates a CREATE statement on the given table and column information
 // used for creating feature tables in the target Geopackage
 func (t Table) createSQL() string {
-	create := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%v"`, t.Name)
+	create := fmt.Sprintf(`CREATE TABLE "%v"`, t.Name)
 	var columnparts []string
-	for _, column := range t.columns {
-		columnpart := column.name + ` ` + column.ctype
-		if column.notnull == 1 {
+	for _, column := range t.Columns {
+		columnpart := column.Name + ` ` + column.Type
+		if column.NotNull {
 			columnpart = columnpart + ` NOT NULL`
 		}
-		if column.pk == 1 {
+		if column.PrimaryKey {
 			columnpart = columnpart + ` PRIMARY KEY`
 		}
 
@@ -308,36 +538,81 @@ func (t Table) createSQL() string {
 	return query
 }
 
+// indexSQL creates the CREATE INDEX statements for the indexed columns of the table,
+// existing indexes are kept
+func (t Table) indexSQL() []string {
+	var queries []string
+	for _, c := range t.Columns {
+		if c.Indexed {
+			queries = append(queries, fmt.Sprintf(`CREATE INDEX IF NOT EXISTS "%v_%v_idx" ON "%v"(%v);`, t.Name, c.Name, t.Name, c.Name))
+		}
+	}
+	return queries
+}
+
 // selectSQL build a SELECT statement based on the table and columns
-// used for reading the source features
-func (t Table) selectSQL() string {
+// used for reading the source features, ordered by the primary key,
+// with after only the features with a primary key greater than the parameter are selected
+func (t Table) selectSQL(after bool) string {
 	var csql []string
-	for _, c := range t.columns {
-		csql = append(csql, c.name)
+	for _, c := range t.Columns {
+		csql = append(csql, c.Name)
 	}
-	query := `SELECT ` + strings.Join(csql, `,`) + ` FROM "` + t.Name + `";`
-	return query
+	query := `SELECT ` + strings.Join(csql, `,`) + ` FROM "` + t.Name + `"`
+	if pk := t.primaryKey(); pk != `` {
+		if after {
+			query = query + ` WHERE ` + pk + ` > ?`
+		}
+		query = query + ` ORDER BY ` + pk
+	}
+	return query + `;`
+}
+
+// countSQL build a SELECT COUNT(*) statement counting the features read by selectSQL
+func (t Table) countSQL(after bool) string {
+	query := `SELECT COUNT(*) FROM "` + t.Name + `"`
+	if pk := t.primaryKey(); pk != `` && after {
+		query = query + ` WHERE ` + pk + ` > ?`
+	}
+	return query + `;`
+}
+
+// primaryKey returns the name of the primary key column, an empty string without one
+func (t Table) primaryKey() string {
+	for _, c := range t.Columns {
+		if c.PrimaryKey {
+			return c.Name
+		}
+	}
+	return ``
 }
 
 // insertSQL used for writing the features
-// build the INSERT statement based on the table and columns
-func (t Table) insertSQL() string {
+// build the INSERT statement based on the table and columns, without the
+// INTEGER primary key when it is omitted so SQLite assigns a new one
+func (t Table) insertSQL(omitPrimaryKey bool) string {
 	var csql, vsql []string
-	for _, c := range t.columns {
-		if c.name != t.gcolumn {
-			csql = append(csql, c.name)
-			vsql = append(vsql, `?`)
+	for _, c := range t.Columns {
+		if c.Name == t.GeometryColumn || omitPrimaryKey && c.PrimaryKey && c.Type == `INTEGER` {
+			continue
 		}
+		csql = append(csql, c.Name)
+		vsql = append(vsql, `?`)
 	}
-	csql = append(csql, t.gcolumn)
+	csql = append(csql, t.GeometryColumn)
 	vsql = append(vsql, `?`)
-	query := `INSERT INTO "` + t.Name + `"(` + strings.Join(csql, `,`) + `) VALUES(` + strings.Join(vsql, `,`) + `)`
-	return query
+	return `INSERT INTO "` + t.Name + `"(` + strings.Join(csql, `,`) + `) VALUES(` + strings.Join(vsql, `,`) + `)`
+}
+
+// deleteSQL deletes a feature by its primary key, an upsert deletes the feature before inserting it.
+// A plain DELETE and INSERT fire the RTree triggers, unlike the conflict handling of an upsert.
+func (t Table) deleteSQL() string {
+	return `DELETE FROM "` + t.Name + `" WHERE ` + t.primaryKey() + ` = ?`
 }
 
 // getSpatialReferenceSystem extracts this based on the given SRS id
-func getSpatialReferenceSystem(h *gpkg.Handle, id int) gpkg.SpatialReferenceSystem {
-	var srs gpkg.SpatialReferenceSystem
+func getSpatialReferenceSystem(h *gpkg.Handle, id int) pkg.SpatialReferenceSystem {
+	var srs pkg.SpatialReferenceSystem
 	query := `SELECT srs_name, srs_id, organization, organization_coordsys_id, definition, description FROM gpkg_spatial_ref_sys WHERE srs_id = %v;`
 
 	row := h.QueryRow(fmt.Sprintf(query, id))
@@ -351,8 +626,8 @@ func getSpatialReferenceSystem(h *gpkg.Handle, id int) gpkg.SpatialReferenceSyst
 }
 
 // getTableColumns collects the column information of a given table
-func getTableColumns(h *gpkg.Handle, table string) []column {
-	var columns []column
+func getTableColumns(h *gpkg.Handle, table string) []pkg.Column {
+	var columns []pkg.Column
 	query := `PRAGMA table_info('%v');`
 	rows, err := h.Query(fmt.Sprintf(query, table))
 
@@ -361,11 +636,15 @@ func getTableColumns(h *gpkg.Handle, table string) []column {
 	}
 
 	for rows.Next() {
-		var column column
-		err := rows.Scan(&column.cid, &column.name, &column.ctype, &column.notnull, &column.dfltValue, &column.pk)
+		var cid, notnull, pk int
+		var dfltValue *string
+		var column pkg.Column
+		err := rows.Scan(&cid, &column.Name, &column.Type, &notnull, &dfltValue, &pk)
 		if err != nil {
 			log.Fatalf("error getting the column information: %s", err)
 		}
+		column.NotNull = notnull == 1
+		column.PrimaryKey = pk == 1
 		columns = append(columns, column)
 	}
 	defer rows.Close()
@@ -384,15 +663,15 @@ func buildTable(h *gpkg.Handle, t Table) error {
 		Name:          t.Name,
 		ShortName:     t.Name,
 		Description:   t.Name,
-		GeometryField: t.gcolumn,
-		GeometryType:  t.gtype,
-		SRS:           int32(t.srs.ID),
+		GeometryField: t.GeometryColumn,
+		GeometryType:  t.gtype(),
+		SRS:           int32(t.SRS.ID),
 		//
 		Z: gpkg.Prohibited,
 		M: gpkg.Prohibited,
 	})
 	if err != nil {
-		log.Println("error adding geometry table in target GeoPackage:", err)
+		slog.Error(`error adding geometry table in target GeoPackage`, `table`, t.Name, `error`, err)
 		return err
 	}
 	return nil
@@ -0,0 +1,136 @@
+package gpkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestWriteFeaturesExtent(t *testing.T) {
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+	square := func(x, y, size float64) geom.Polygon {
+		return geom.Polygon{{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}}}
+	}
+	// pages of 2 features, the first feature of the first page has an empty geometry
+	geometries := []geom.Geometry{geom.Polygon{}, square(50, 50, 50), square(10, 10, 1), square(20, 20, 1), square(-10, 0, 1)}
+
+	for _, fastLoad := range []bool{false, true} {
+		handle := openTestGeopackage(t)
+		target := TargetGeopackage{Table: table, pagesize: 2, handle: handle}
+		if fastLoad {
+			target.InitFastLoad(FastLoad{JournalMode: `MEMORY`, Synchronous: `OFF`, CacheSize: -2000})
+		}
+		if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+			t.Fatal(err)
+		}
+		postSieve := make(chan pkg.Feature)
+		go func() {
+			for i, g := range geometries {
+				postSieve <- &featureGPKG{columns: []interface{}{int64(i + 1)}, geometry: g}
+			}
+			close(postSieve)
+		}()
+		target.WriteFeatures(postSieve)
+
+		var stored [4]float64
+		err := handle.QueryRow(`SELECT min_x, min_y, max_x, max_y FROM gpkg_contents WHERE table_name = 'parcels'`).
+			Scan(&stored[0], &stored[1], &stored[2], &stored[3])
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := handle.CalculateGeometryExtent(`parcels`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != [4]float64(*data) || stored != [4]float64{-10, 0, 100, 100} {
+			t.Errorf("fast load: %v, expected: %v \ngot: %v", fastLoad, *data, stored)
+		}
+
+		// the envelope in the binary header is minx, maxx, miny, maxy
+		var blob []byte
+		if err = handle.QueryRow(`SELECT geom FROM parcels WHERE fid = 2`).Scan(&blob); err != nil {
+			t.Fatal(err)
+		}
+		header, err := gpkg.DecodeBinaryHeader(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []float64{50, 100, 50, 100}; !reflect.DeepEqual(header.Envelope(), expected) {
+			t.Errorf("fast load: %v, expected: %v \ngot: %v", fastLoad, expected, header.Envelope())
+		}
+
+		expected := map[int64][4]float64{2: {50, 100, 50, 100}, 3: {10, 11, 10, 11}, 4: {20, 21, 20, 21}, 5: {-10, -9, 0, 1}}
+		if got := rtreeEnvelopes(t, handle, `parcels`); !reflect.DeepEqual(got, expected) {
+			t.Errorf("fast load: %v, expected: %v \ngot: %v", fastLoad, expected, got)
+		}
+		handle.Close()
+	}
+}
+
+func TestCount(t *testing.T) {
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+	handle := openTestGeopackage(t)
+	defer handle.Close()
+	target := TargetGeopackage{Table: table, pagesize: 10, handle: handle}
+	if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+		t.Fatal(err)
+	}
+	var features []interface{}
+	for fid := 1; fid <= 5; fid++ {
+		features = append(features, &featureGPKG{columns: []interface{}{int64(fid)}, geometry: geom.Polygon{{{0, 0}, {1, 0}, {1, 1}}}})
+	}
+	target.writeFeatures(features, nil)
+
+	var tests = []struct {
+		setup    string
+		after    *int64
+		expected int64
+	}{
+		// 0
+		{expected: 5},
+		// 1
+		{after: func() *int64 { fid := int64(3); return &fid }(), expected: 2},
+		// 2 the estimate of gpkg_ogr_contents is used
+		{setup: `CREATE TABLE gpkg_ogr_contents (table_name TEXT NOT NULL PRIMARY KEY, feature_count INTEGER DEFAULT NULL);
+			INSERT INTO gpkg_ogr_contents VALUES ('parcels', 7);`, expected: 7},
+		// 3 except when resuming
+		{after: func() *int64 { fid := int64(3); return &fid }(), expected: 2},
+	}
+
+	for k, test := range tests {
+		if test.setup != `` {
+			if _, err := handle.Exec(test.setup); err != nil {
+				t.Fatal(err)
+			}
+		}
+		source := SourceGeopackage{handle: handle}
+		source.SetTable(pkg.Table(table))
+		if test.after != nil {
+			source.ResumeAfter(*test.after)
+		}
+		if got, ok := source.Count(); !ok || got != test.expected {
+			t.Errorf("test: %d, expected: %d \ngot: %d %v", k, test.expected, got, ok)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package gpkg
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+	"github.com/pdok/sieve/pkg/logging"
+	"github.com/pdok/sieve/pkg/metrics"
+)
+
+// InitInPlace opens the source GeoPackage as target, the sieved features are written back in place.
+// Because the source is read while the target is written, the GeoPackage is switched to WAL mode.
+func (target *TargetGeopackage) InitInPlace(file string, pagesize int) {
+	target.pagesize = pagesize
+	target.inPlace = true
+	target.handle = openGeopackage(file)
+
+	_, err := target.handle.Exec(`PRAGMA journal_mode=WAL;`)
+	if err != nil {
+		log.Fatalf("error switching the GeoPackage to WAL mode: %s", err)
+	}
+}
+
+// inPlaceResult is the state of updating a table in place, the features are
+// read in the order of the primary key so the features in between the written
+// features are the ones removed by the sieve
+type inPlaceResult struct {
+	// next is the lowest primary key that isn't processed yet, the primary key before it
+	// is recorded in sieve_state after every page so an interrupted run can be resumed
+	next             int64
+	done             bool
+	updated, deleted int64
+}
+
+// updateFeatures updates the modified geometries and deletes the removed features,
+// in a transaction per page. The features rejected by the validation are left as they
+// are. The RTree is maintained by the triggers of the table.
+func (target TargetGeopackage) updateFeatures(postSieve chan pkg.Feature) {
+	pk := target.Table.primaryKey()
+	if pk == `` {
+		log.Fatalf("table %s has no primary key, which is needed for sieving in place", target.Table.Name)
+	}
+	// the position of the primary key in Feature.Columns(), without the geometry column
+	position := 0
+	for _, c := range target.Table.Columns {
+		if c.Name == pk {
+			break
+		}
+		if c.Name != target.Table.GeometryColumn {
+			position++
+		}
+	}
+
+	result := inPlaceResult{next: math.MinInt64}
+	if target.resume {
+		// the source is read after the recorded primary key as well
+		if state := target.State(target.Table.Name); state.LastFID != nil && *state.LastFID == math.MaxInt64 {
+			result.done = true
+		} else if state.LastFID != nil {
+			result.next = *state.LastFID + 1
+		}
+	}
+	var features []pkg.Feature
+	for {
+		feature, hasMore := <-postSieve
+		if !hasMore {
+			target.updatePage(features, position, &result, true)
+			break
+		}
+		features = append(features, feature)
+		if len(features)%target.pagesize == 0 {
+			target.updatePage(features, position, &result, false)
+			features = nil
+		}
+	}
+
+	if err := updateExtent(target.handle, target.Table.Name); err != nil {
+		log.Fatalln("Failed to update extent:", err)
+	}
+	completeState(target.handle, target.Table.Name)
+	logging.Counts(`updated in place`, target.Table.Name, `write`, `updated`, result.updated, `deleted`, result.deleted)
+}
+
+// updatePage writes a page of features, with last the features after the page are deleted
+func (target TargetGeopackage) updatePage(features []pkg.Feature, position int, result *inPlaceResult, last bool) {
+	tx, err := target.handle.Begin()
+	if err != nil {
+		log.Fatalf("Could not start a transaction: %s", err)
+	}
+	t := target.Table
+	pk := t.primaryKey()
+	update, err := tx.Prepare(`UPDATE "` + t.Name + `" SET "` + t.GeometryColumn + `" = ? WHERE ` + pk + ` = ?`)
+	if err != nil {
+		log.Fatalf("Could not prepare a statement: %s", err)
+	}
+	defer update.Close()
+
+	for _, f := range features {
+		fid, ok := f.Columns()[position].(int64)
+		if !ok {
+			log.Fatalf("unexpected primary key in table %s: %v", t.Name, f.Columns()[position])
+		}
+		if fid > result.next {
+			result.deleted += deleteRange(tx, t, result.next, fid)
+		}
+		if fid == math.MaxInt64 {
+			result.done = true
+		} else {
+			result.next = fid + 1
+		}
+
+		if _, ok := f.(pkg.RejectedFeature); ok {
+			continue
+		}
+		if g, ok := f.(*featureGPKG); ok && !g.modified {
+			continue
+		}
+		sb, err := newBinary(int32(t.SRS.ID), f.Geometry(), envelopeOf(f.Geometry()))
+		if err != nil {
+			log.Fatalf("Could not create a binary geometry: %s", err)
+		}
+		if _, err = update.Exec(sb, fid); err != nil {
+			log.Fatalf("Could not update the feature with fid %d: %s", fid, err)
+		}
+		result.updated++
+	}
+	if last && !result.done {
+		result.deleted += deleteRange(tx, t, result.next, math.MaxInt64)
+		result.done = true
+	}
+	switch {
+	case result.done:
+		recordFID(tx, t.Name, math.MaxInt64)
+	case result.next != math.MinInt64:
+		recordFID(tx, t.Name, result.next-1)
+	}
+
+	start := time.Now()
+	if err = tx.Commit(); err != nil {
+		log.Fatalf("Could not commit the transaction: %s", err)
+	}
+	metrics.PageCommit.WithLabelValues(target.Table.Name).Observe(time.Since(start).Seconds())
+}
+
+// deleteRange deletes the features with a primary key from from, up to and excluding to,
+// or including to when it is the largest possible key
+func deleteRange(tx *sql.Tx, t Table, from, to int64) int64 {
+	query := `DELETE FROM "` + t.Name + `" WHERE ` + t.primaryKey() + ` >= ? AND ` + t.primaryKey() + ` < ?`
+	if to == math.MaxInt64 {
+		query = `DELETE FROM "` + t.Name + `" WHERE ` + t.primaryKey() + ` >= ? AND ` + t.primaryKey() + ` <= ?`
+	}
+	r, err := tx.Exec(query, from, to)
+	if err != nil {
+		log.Fatalf("Could not delete the sieved features: %s", err)
+	}
+	deleted, err := r.RowsAffected()
+	if err != nil {
+		log.Fatalf("Could not delete the sieved features: %s", err)
+	}
+	return deleted
+}
+
+// updateExtent sets the extent in gpkg_contents to the extent of the remaining features,
+// unlike UpdateGeometryExtent which only grows it
+func updateExtent(h *gpkg.Handle, table string) error {
+	ext, err := h.CalculateGeometryExtent(table)
+	if err != nil {
+		return err
+	}
+	if ext == nil {
+		_, err = h.Exec(`UPDATE gpkg_contents SET min_x = NULL, min_y = NULL, max_x = NULL, max_y = NULL WHERE table_name = ?`, table)
+		return err
+	}
+	_, err = h.Exec(`UPDATE gpkg_contents SET min_x = ?, min_y = ?, max_x = ?, max_y = ? WHERE table_name = ?`,
+		ext.MinX(), ext.MinY(), ext.MaxX(), ext.MaxY(), table)
+	return err
+}
@@ -0,0 +1,222 @@
+package gpkg
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+// openTestGeopackage creates a GeoPackage with the required tables in a temporary directory
+func openTestGeopackage(t *testing.T) *gpkg.Handle {
+	handle, err := OpenHandle(filepath.Join(t.TempDir(), `test.gpkg`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handle
+}
+
+func TestUpdateFeatures(t *testing.T) {
+	handle := openTestGeopackage(t)
+	defer handle.Close()
+
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `geom`, Type: `POLYGON`},
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{ID: 28992},
+	}
+	for _, query := range []string{
+		table.createSQL(),
+		`INSERT INTO gpkg_contents (table_name, data_type, srs_id) VALUES ('parcels', 'features', 0)`,
+		`INSERT INTO gpkg_geometry_columns VALUES ('parcels', 'geom', 'POLYGON', 0, 0, 0)`,
+	} {
+		if _, err := handle.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	square := func(size float64) geom.Polygon {
+		return geom.Polygon{{{0, 0}, {size, 0}, {size, size}, {0, size}}}
+	}
+	for fid := 1; fid <= 6; fid++ {
+		sb, err := gpkg.NewBinary(28992, square(float64(fid)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = handle.Exec(`INSERT INTO parcels (fid, geom) VALUES (?, ?)`, fid, sb); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1, 4 and 6 are sieved, 3 is modified
+	modified := &featureGPKG{columns: []interface{}{int64(3)}, geometry: square(3)}
+	modified.UpdateGeometry(square(30))
+	unmodified := &featureGPKG{columns: []interface{}{int64(5)}, geometry: square(5)}
+	unmodified.UpdateGeometry(square(5))
+	features := []pkg.Feature{&featureGPKG{columns: []interface{}{int64(2)}, geometry: square(2)}, modified, unmodified}
+
+	target := TargetGeopackage{Table: table, pagesize: 2, handle: handle, inPlace: true}
+	if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+		t.Fatal(err)
+	}
+	postSieve := make(chan pkg.Feature)
+	go func() {
+		for _, f := range features {
+			postSieve <- f
+		}
+		close(postSieve)
+	}()
+	target.WriteFeatures(postSieve)
+
+	rows, err := handle.Query(`SELECT fid, geom FROM parcels ORDER BY fid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make(map[int64]geom.Geometry)
+	for rows.Next() {
+		var fid int64
+		var sb gpkg.StandardBinary
+		if err = rows.Scan(&fid, &sb); err != nil {
+			t.Fatal(err)
+		}
+		got[fid] = sb.Geometry
+	}
+	expected := map[int64]geom.Geometry{2: square(2), 3: square(30), 5: square(5)}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, got)
+	}
+
+	var extent [4]float64
+	err = handle.QueryRow(`SELECT min_x, min_y, max_x, max_y FROM gpkg_contents WHERE table_name = 'parcels'`).
+		Scan(&extent[0], &extent[1], &extent[2], &extent[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extent != [4]float64{0, 0, 30, 30} {
+		t.Errorf("expected: %v \ngot: %v", [4]float64{0, 0, 30, 30}, extent)
+	}
+}
+
+func TestSelectSQL(t *testing.T) {
+	var tests = []struct {
+		table    Table
+		after    bool
+		expected string
+	}{
+		// 0
+		{table: Table{Name: `a`, Columns: []pkg.Column{{Name: `fid`, PrimaryKey: true}, {Name: `geom`}}},
+			expected: `SELECT fid,geom FROM "a" ORDER BY fid;`},
+		// 1
+		{table: Table{Name: `b`, Columns: []pkg.Column{{Name: `id`}, {Name: `geom`}}},
+			expected: `SELECT id,geom FROM "b";`},
+		// 2
+		{table: Table{Name: `a`, Columns: []pkg.Column{{Name: `fid`, PrimaryKey: true}, {Name: `geom`}}}, after: true,
+			expected: `SELECT fid,geom FROM "a" WHERE fid > ? ORDER BY fid;`},
+	}
+
+	for k, test := range tests {
+		if got := test.table.selectSQL(test.after); got != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.expected, got)
+		}
+	}
+}
+
+func TestResumeInPlace(t *testing.T) {
+	handle := openTestGeopackage(t)
+	defer handle.Close()
+
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{ID: 28992},
+	}
+	for _, query := range []string{
+		table.createSQL(),
+		`INSERT INTO gpkg_contents (table_name, data_type, srs_id) VALUES ('parcels', 'features', 0)`,
+		`INSERT INTO gpkg_geometry_columns VALUES ('parcels', 'geom', 'POLYGON', 0, 0, 0)`,
+	} {
+		if _, err := handle.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	square := func(size float64) geom.Polygon {
+		return geom.Polygon{{{0, 0}, {size, 0}, {size, size}, {0, size}}}
+	}
+	feature := func(fid int) *featureGPKG {
+		return &featureGPKG{columns: []interface{}{int64(fid)}, geometry: square(float64(fid))}
+	}
+	for fid := 1; fid <= 6; fid++ {
+		sb, err := gpkg.NewBinary(28992, square(float64(fid)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = handle.Exec(`INSERT INTO parcels (fid, geom) VALUES (?, ?)`, fid, sb); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the interrupted run commits the first page, 1 is sieved, and stops
+	target := TargetGeopackage{Table: table, pagesize: 2, handle: handle, inPlace: true}
+	if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+		t.Fatal(err)
+	}
+	result := inPlaceResult{next: math.MinInt64}
+	target.updatePage([]pkg.Feature{feature(2), feature(3)}, 0, &result, false)
+	if state := target.State(`parcels`); state.Completed || state.LastFID == nil || *state.LastFID != 3 {
+		t.Errorf("expected: the fid 3 up to which the features are processed \ngot: %+v", state)
+	}
+
+	// the resumed run reads the source after fid 3, 4 is sieved and 6 is rejected by the validation
+	resumed := TargetGeopackage{Table: table, pagesize: 2, handle: handle, inPlace: true}
+	resumed.InitResume()
+	if err := resumed.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+		t.Fatal(err)
+	}
+	rejected := feature(6)
+	rejected.UpdateGeometry(square(60))
+	postSieve := make(chan pkg.Feature)
+	go func() {
+		postSieve <- feature(5)
+		postSieve <- pkg.RejectedFeature{Feature: rejected}
+		close(postSieve)
+	}()
+	resumed.WriteFeatures(postSieve)
+
+	var fids []int64
+	rows, err := handle.Query(`SELECT fid FROM parcels ORDER BY fid`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fid int64
+		if err = rows.Scan(&fid); err != nil {
+			t.Fatal(err)
+		}
+		fids = append(fids, fid)
+	}
+	if expected := []int64{2, 3, 5, 6}; !reflect.DeepEqual(fids, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, fids)
+	}
+	var size float64
+	if err = handle.QueryRow(`SELECT ST_MaxX(geom) FROM parcels WHERE fid = 6`).Scan(&size); err != nil || size != 6 {
+		t.Errorf("expected: the rejected feature as it is \ngot: %v %v", size, err)
+	}
+	if state := resumed.State(`parcels`); !state.Completed {
+		t.Errorf("expected: a completed table \ngot: %+v", state)
+	}
+}
@@ -0,0 +1,109 @@
+package gpkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+// Mode determines how a table is written when it already exists in the target GeoPackage
+type Mode string
+
+const (
+	// ModeCreate fails when the table exists, this is the default
+	ModeCreate Mode = `create`
+	// ModeOverwrite drops the existing table and builds it again
+	ModeOverwrite Mode = `overwrite`
+	// ModeAppend adds the features to the existing table, when its columns are compatible.
+	// The features get a new primary key, so they don't collide with the existing features.
+	ModeAppend Mode = `append`
+	// ModeUpsert adds the features to the existing table replacing the features with the same
+	// primary key, which are deleted before the features are inserted
+	ModeUpsert Mode = `upsert`
+)
+
+// ParseMode validates the given string as a Mode, an empty string results in ModeCreate
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case ``, ModeCreate:
+		return ModeCreate, nil
+	case ModeOverwrite, ModeAppend, ModeUpsert:
+		return Mode(mode), nil
+	}
+	return ``, fmt.Errorf("unknown mode: %s", mode)
+}
+
+// prepareTable prepares the target for writing the table according to the mode,
+// exists is true when the features are written to an existing table
+func prepareTable(h *gpkg.Handle, t Table, mode Mode) (exists bool, err error) {
+	if _, pkErr := pkg.PrimaryKeyPosition(pkg.Table(t)); mode == ModeUpsert && pkErr != nil {
+		return false, fmt.Errorf("table %s has no INTEGER primary key, which is needed for upsert", t.Name)
+	}
+	var count int
+	err = h.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, t.Name).Scan(&count)
+	if err != nil || count == 0 {
+		return false, err
+	}
+
+	switch mode {
+	case ModeOverwrite:
+		return false, dropTable(h, t.Name)
+	case ModeAppend, ModeUpsert:
+		return true, compatible(getTableColumns(h, t.Name), t)
+	}
+	return false, fmt.Errorf("table %s already exists in the target", t.Name)
+}
+
+// dropTable drops the table with its RTree and removes it from the gpkg_ tables, the triggers
+// are dropped with the table
+func dropTable(h *gpkg.Handle, table string) error {
+	var column string
+	err := h.QueryRow(`SELECT column_name FROM gpkg_geometry_columns WHERE table_name = ?`, table).Scan(&column)
+	if err == nil {
+		if _, err = h.Exec(fmt.Sprintf(`DROP TABLE IF EXISTS "rtree_%v_%v"`, table, column)); err != nil {
+			return err
+		}
+	}
+	if _, err = h.Exec(fmt.Sprintf(`DROP TABLE "%v"`, table)); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`DELETE FROM gpkg_extensions WHERE table_name = ?`,
+		`DELETE FROM gpkg_geometry_columns WHERE table_name = ?`,
+		`DELETE FROM gpkg_contents WHERE table_name = ?`,
+	} {
+		if _, err = h.Exec(query, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compatible checks if the features of the table can be written to the existing columns,
+// all columns of the table must exist with the same type and other columns must be nullable
+func compatible(existing []pkg.Column, t Table) error {
+	columns := make(map[string]pkg.Column)
+	for _, c := range t.Columns {
+		columns[strings.ToLower(c.Name)] = c
+	}
+	found := 0
+	for _, e := range existing {
+		c, ok := columns[strings.ToLower(e.Name)]
+		if !ok {
+			if e.NotNull && !e.PrimaryKey {
+				return fmt.Errorf("table %s in the target has the NOT NULL column %s, which is missing in the source", t.Name, e.Name)
+			}
+			continue
+		}
+		if !strings.EqualFold(c.Type, e.Type) {
+			return fmt.Errorf("column %s of table %s is %s in the target, expected %s", e.Name, t.Name, e.Type, c.Type)
+		}
+		found++
+	}
+	if found != len(t.Columns) {
+		return fmt.Errorf("table %s in the target misses columns of the source", t.Name)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package gpkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestPrepareTable(t *testing.T) {
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `name`, Type: `TEXT`},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+	}
+
+	var tests = []struct {
+		existing string
+		table    Table
+		mode     Mode
+		exists   bool
+		err      bool
+	}{
+		// 0
+		{table: table, mode: ModeCreate},
+		// 1
+		{existing: table.createSQL(), table: table, mode: ModeCreate, err: true},
+		// 2 the existing table is dropped
+		{existing: table.createSQL(), table: table, mode: ModeOverwrite},
+		// 3
+		{existing: table.createSQL(), table: table, mode: ModeAppend, exists: true},
+		// 4 extra nullable columns are allowed
+		{existing: `CREATE TABLE parcels (fid INTEGER PRIMARY KEY, name TEXT, remark TEXT, geom POLYGON)`, table: table, mode: ModeUpsert, exists: true},
+		// 5
+		{existing: `CREATE TABLE parcels (fid INTEGER PRIMARY KEY, name INTEGER, geom POLYGON)`, table: table, mode: ModeAppend, exists: true, err: true},
+		// 6
+		{existing: `CREATE TABLE parcels (fid INTEGER PRIMARY KEY, geom POLYGON)`, table: table, mode: ModeAppend, exists: true, err: true},
+		// 7
+		{existing: `CREATE TABLE parcels (fid INTEGER PRIMARY KEY, name TEXT, code TEXT NOT NULL, geom POLYGON)`, table: table, mode: ModeAppend, exists: true, err: true},
+		// 8 upsert needs a primary key
+		{table: Table{Name: `parcels`, Columns: []pkg.Column{{Name: `geom`, Type: `POLYGON`}}}, mode: ModeUpsert, err: true},
+	}
+
+	for k, test := range tests {
+		handle := openTestGeopackage(t)
+		if test.existing != `` {
+			if _, err := handle.Exec(test.existing); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := handle.Exec(`INSERT INTO gpkg_contents (table_name, data_type, srs_id) VALUES ('parcels', 'features', 0)`); err != nil {
+				t.Fatal(err)
+			}
+		}
+		exists, err := prepareTable(handle, test.table, test.mode)
+		if exists != test.exists || (err != nil) != test.err {
+			t.Errorf("test: %d, expected: %v %v \ngot: %v %v", k, test.exists, test.err, exists, err)
+		}
+		var count int
+		handle.QueryRow(`SELECT count(*) FROM gpkg_contents`).Scan(&count)
+		if test.mode == ModeOverwrite && count != 0 {
+			t.Errorf("test: %d, expected the table to be removed from gpkg_contents", k)
+		}
+		handle.Close()
+	}
+}
+
+func TestInsertSQL(t *testing.T) {
+	table := Table{
+		Name:           `parcels`,
+		Columns:        []pkg.Column{{Name: `fid`, Type: `INTEGER`, PrimaryKey: true}, {Name: `name`}, {Name: `geom`}},
+		GeometryColumn: `geom`,
+	}
+	var tests = []struct {
+		omitPrimaryKey bool
+		expected       string
+	}{
+		// 0
+		{omitPrimaryKey: false, expected: `INSERT INTO "parcels"(fid,name,geom) VALUES(?,?,?)`},
+		// 1
+		{omitPrimaryKey: true, expected: `INSERT INTO "parcels"(name,geom) VALUES(?,?)`},
+	}
+
+	for k, test := range tests {
+		if got := table.insertSQL(test.omitPrimaryKey); got != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.expected, got)
+		}
+	}
+}
+
+func TestWriteModes(t *testing.T) {
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `name`, Type: `TEXT`},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+	square := func(x, size float64) geom.Polygon {
+		return geom.Polygon{{{x, 0}, {x + size, 0}, {x + size, size}, {x, size}}}
+	}
+	// write writes the features with the fids to the table with the mode
+	write := func(handle *gpkg.Handle, mode Mode, fastLoad bool, fids []int, size float64, name string) {
+		target := TargetGeopackage{Table: table, pagesize: 2, handle: handle, mode: mode}
+		if fastLoad {
+			target.InitFastLoad(FastLoad{JournalMode: `MEMORY`, Synchronous: `OFF`, CacheSize: -2000})
+		}
+		if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+			t.Fatal(err)
+		}
+		postSieve := make(chan pkg.Feature)
+		go func() {
+			for _, fid := range fids {
+				postSieve <- &featureGPKG{columns: []interface{}{int64(fid), name}, geometry: square(float64(fid), size)}
+			}
+			close(postSieve)
+		}()
+		target.WriteFeatures(postSieve)
+		if fastLoad {
+			target.closeFastLoad()
+		}
+	}
+
+	var tests = []struct {
+		mode     Mode
+		fastLoad bool
+		fids     []int
+		expected map[int64]string
+		rtree    map[int64][4]float64
+	}{
+		// 0 the appended features get new primary keys
+		{mode: ModeAppend, fids: []int{1, 2, 3},
+			expected: map[int64]string{1: `a`, 2: `a`, 3: `a`, 4: `b`, 5: `b`, 6: `b`},
+			rtree:    map[int64][4]float64{1: {1, 2, 0, 1}, 2: {2, 3, 0, 1}, 3: {3, 4, 0, 1}, 4: {1, 3, 0, 2}, 5: {2, 4, 0, 2}, 6: {3, 5, 0, 2}}},
+		// 1
+		{mode: ModeAppend, fastLoad: true, fids: []int{1, 2, 3},
+			expected: map[int64]string{1: `a`, 2: `a`, 3: `a`, 4: `b`, 5: `b`, 6: `b`},
+			rtree:    map[int64][4]float64{1: {1, 2, 0, 1}, 2: {2, 3, 0, 1}, 3: {3, 4, 0, 1}, 4: {1, 3, 0, 2}, 5: {2, 4, 0, 2}, 6: {3, 5, 0, 2}}},
+		// 2 the existing features are replaced, maintaining the RTree with the triggers
+		{mode: ModeUpsert, fids: []int{2, 3, 4},
+			expected: map[int64]string{1: `a`, 2: `b`, 3: `b`, 4: `b`},
+			rtree:    map[int64][4]float64{1: {1, 2, 0, 1}, 2: {2, 4, 0, 2}, 3: {3, 5, 0, 2}, 4: {4, 6, 0, 2}}},
+		// 3
+		{mode: ModeUpsert, fastLoad: true, fids: []int{2, 3, 4},
+			expected: map[int64]string{1: `a`, 2: `b`, 3: `b`, 4: `b`},
+			rtree:    map[int64][4]float64{1: {1, 2, 0, 1}, 2: {2, 4, 0, 2}, 3: {3, 5, 0, 2}, 4: {4, 6, 0, 2}}},
+	}
+
+	for k, test := range tests {
+		handle := openTestGeopackage(t)
+		write(handle, ModeCreate, false, []int{1, 2, 3}, 1, `a`)
+		write(handle, test.mode, test.fastLoad, test.fids, 2, `b`)
+
+		got := make(map[int64]string)
+		rows, err := handle.Query(`SELECT fid, name FROM parcels`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var fid int64
+			var name string
+			if err = rows.Scan(&fid, &name); err != nil {
+				t.Fatal(err)
+			}
+			got[fid] = name
+		}
+		rows.Close()
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+		if envelopes := rtreeEnvelopes(t, handle, `parcels`); !reflect.DeepEqual(envelopes, test.rtree) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.rtree, envelopes)
+		}
+		handle.Close()
+	}
+}
+
+func TestIndexSQL(t *testing.T) {
+	table := Table{
+		Name:           `parcels`,
+		Columns:        []pkg.Column{{Name: `fid`, Type: `INTEGER`, PrimaryKey: true}, {Name: `max_resolution`, Type: `REAL`, Indexed: true}, {Name: `geom`, Type: `POLYGON`}},
+		GeometryColumn: `geom`,
+	}
+	handle := openTestGeopackage(t)
+	defer handle.Close()
+	if _, err := handle.Exec(table.createSQL()); err != nil {
+		t.Fatal(err)
+	}
+	// executed twice, like for an existing table
+	for i := 0; i < 2; i++ {
+		for _, query := range table.indexSQL() {
+			if _, err := handle.Exec(query); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var name string
+	err := handle.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'parcels'`).Scan(&name)
+	if err != nil || name != `parcels_max_resolution_idx` {
+		t.Errorf("expected: parcels_max_resolution_idx \ngot: %s %v", name, err)
+	}
+}
@@ -0,0 +1,209 @@
+package gpkg
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/cmp"
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pdok/sieve/pkg"
+)
+
+// driverName is the SQLite driver used for the GeoPackages. It provides the ST_IsEmpty, ST_MinX,
+// ST_MaxX, ST_MinY and ST_MaxY functions used by the GeoPackage RTree triggers, computed in Go
+// from the geometries, so only the rtree module of SQLite is needed instead of SpatiaLite.
+const driverName = `sqlite3_gpkg_rtree`
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// every connection gets its own envelope, the functions of a connection aren't called concurrently
+			e := &envelope{}
+			functions := map[string]interface{}{
+				`ST_IsEmpty`: e.isEmpty,
+				`ST_MinX`:    e.side(func(ext *geom.Extent) float64 { return ext.MinX() }),
+				`ST_MaxX`:    e.side(func(ext *geom.Extent) float64 { return ext.MaxX() }),
+				`ST_MinY`:    e.side(func(ext *geom.Extent) float64 { return ext.MinY() }),
+				`ST_MaxY`:    e.side(func(ext *geom.Extent) float64 { return ext.MaxY() }),
+			}
+			for name, f := range functions {
+				if err := conn.RegisterFunc(name, f, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+var errEmptyGeometry = errors.New("empty geometry has no envelope")
+
+// envelope computes the envelope of a GeoPackage binary geometry. The last one is kept,
+// because the triggers request the four sides of the same geometry after each other.
+type envelope struct {
+	blob   []byte
+	extent *geom.Extent
+}
+
+// of returns the envelope of the geometry, nil for an empty geometry or NULL
+func (e *envelope) of(value interface{}) (*geom.Extent, error) {
+	blob, ok := value.([]byte)
+	if !ok || blob == nil {
+		return nil, nil
+	}
+	if bytes.Equal(blob, e.blob) {
+		return e.extent, nil
+	}
+	sb, err := gpkg.DecodeGeometry(blob)
+	if err != nil {
+		return nil, err
+	}
+	e.blob = blob
+	e.extent = nil
+	if !sb.Header.IsGeometryEmpty() {
+		e.extent = sb.Extent()
+	}
+	return e.extent, nil
+}
+
+func (e *envelope) isEmpty(value interface{}) (bool, error) {
+	extent, err := e.of(value)
+	return extent == nil, err
+}
+
+func (e *envelope) side(side func(*geom.Extent) float64) func(interface{}) (float64, error) {
+	return func(value interface{}) (float64, error) {
+		extent, err := e.of(value)
+		if err != nil {
+			return 0, err
+		}
+		if extent == nil {
+			return 0, errEmptyGeometry
+		}
+		return side(extent), nil
+	}
+}
+
+// envelopeOf returns the envelope of the geometry, nil for an empty geometry or NULL
+func envelopeOf(geometry geom.Geometry) *geom.Extent {
+	if geometry == nil || cmp.IsEmptyGeo(geometry) {
+		return nil
+	}
+	envelope, err := geom.NewExtentFromGeometry(geometry)
+	if err != nil {
+		return nil
+	}
+	return envelope
+}
+
+// newBinary encodes the geometry with its envelope like gpkg.NewBinary, but with the envelope
+// in the order of the specification: minx, maxx, miny, maxy
+func newBinary(srs int32, geometry geom.Geometry, envelope *geom.Extent) (*gpkg.StandardBinary, error) {
+	if envelope == nil {
+		return gpkg.NewBinary(srs, geometry)
+	}
+	h, err := gpkg.NewBinaryHeader(binary.LittleEndian, srs,
+		[]float64{envelope.MinX(), envelope.MaxX(), envelope.MinY(), envelope.MaxY()}, gpkg.EnvelopeTypeXY, false, false)
+	if err != nil {
+		return nil, err
+	}
+	return &gpkg.StandardBinary{Header: h, SRSID: srs, Geometry: geometry}, nil
+}
+
+// rtreeWriter writes the envelopes of the features to the RTree of the table, in the
+// transaction of the page, while the triggers are dropped for a fast load
+type rtreeWriter struct {
+	insert, remove *sql.Stmt
+	// primaryKey is the position of the primary key in the inserted values, -1 without one
+	primaryKey int
+}
+
+func newRTreeWriter(tx *sql.Tx, t Table) *rtreeWriter {
+	rtree := fmt.Sprintf(`"rtree_%v_%v"`, t.Name, t.GeometryColumn)
+	insert, err := tx.Prepare(`INSERT OR REPLACE INTO ` + rtree + ` VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Fatalf("Could not prepare a statement: %s", err)
+	}
+	remove, err := tx.Prepare(`DELETE FROM ` + rtree + ` WHERE id = ?`)
+	if err != nil {
+		log.Fatalf("Could not prepare a statement: %s", err)
+	}
+	primaryKey, err := pkg.PrimaryKeyPosition(pkg.Table(t))
+	if err != nil {
+		primaryKey = -1
+	}
+	return &rtreeWriter{insert: insert, remove: remove, primaryKey: primaryKey}
+}
+
+// write adds the envelope of the inserted feature to the RTree, or removes the feature from
+// the RTree without envelope. The id is the primary key, or the rowid when it wasn't given.
+func (w *rtreeWriter) write(values []interface{}, result sql.Result, envelope *geom.Extent) {
+	var id int64
+	var err error
+	if pk, ok := w.pkValue(values); ok {
+		id = pk
+	} else if id, err = result.LastInsertId(); err != nil {
+		log.Fatalf("Could not get the id of the inserted feature: %s", err)
+	}
+	if envelope == nil {
+		_, err = w.remove.Exec(id)
+	} else {
+		_, err = w.insert.Exec(id, envelope.MinX(), envelope.MaxX(), envelope.MinY(), envelope.MaxY())
+	}
+	if err != nil {
+		log.Fatalf("Could not write the RTree for fid %d: %s", id, err)
+	}
+}
+
+func (w *rtreeWriter) pkValue(values []interface{}) (int64, bool) {
+	if w.primaryKey < 0 || w.primaryKey >= len(values) {
+		return 0, false
+	}
+	pk, ok := values[w.primaryKey].(int64)
+	return pk, ok
+}
+
+func (w *rtreeWriter) close() {
+	w.insert.Close()
+	w.remove.Close()
+}
+
+// OpenHandle opens or creates the GeoPackage with the required tables and pragmas,
+// like gpkg.Open does but with the RTree functions of driverName instead of SpatiaLite
+func OpenHandle(file string) (*gpkg.Handle, error) {
+	db, err := sql.Open(driverName, file)
+	if err != nil {
+		return nil, err
+	}
+	h := &gpkg.Handle{DB: db}
+	queries := []string{
+		fmt.Sprintf(`PRAGMA application_id = %d;`, gpkg.ApplicationID),
+		fmt.Sprintf(`PRAGMA user_version = %d;`, gpkg.UserVersion),
+		`PRAGMA foreign_keys = ON;`,
+		gpkg.TableSpatialRefSysSQL,
+		gpkg.TableContentsSQL,
+		gpkg.TableGeometryColumnsSQL,
+		gpkg.TableExtensionsSQL,
+	}
+	for _, query := range queries {
+		if _, err = h.Exec(query); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	var srss []gpkg.SpatialReferenceSystem
+	for _, srs := range gpkg.KnownSRS {
+		srss = append(srss, srs)
+	}
+	if err = h.UpdateSRS(srss...); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return h, nil
+}
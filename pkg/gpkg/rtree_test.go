@@ -0,0 +1,86 @@
+package gpkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+// rtreeEnvelopes returns the envelopes in the RTree of the table by id
+func rtreeEnvelopes(t *testing.T, handle *gpkg.Handle, table string) map[int64][4]float64 {
+	rows, err := handle.Query(`SELECT id, minx, maxx, miny, maxy FROM "rtree_` + table + `_geom"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	envelopes := make(map[int64][4]float64)
+	for rows.Next() {
+		var id int64
+		var e [4]float64
+		if err = rows.Scan(&id, &e[0], &e[1], &e[2], &e[3]); err != nil {
+			t.Fatal(err)
+		}
+		envelopes[id] = e
+	}
+	return envelopes
+}
+
+func TestRTreeTriggers(t *testing.T) {
+	handle := openTestGeopackage(t)
+	defer handle.Close()
+
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+	target := TargetGeopackage{Table: table, pagesize: 10, handle: handle}
+	if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+		t.Fatal(err)
+	}
+
+	square := func(x, y, size float64) geom.Polygon {
+		return geom.Polygon{{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}}}
+	}
+	target.writeFeatures([]interface{}{
+		&featureGPKG{columns: []interface{}{int64(1)}, geometry: square(0, 0, 1)},
+		&featureGPKG{columns: []interface{}{int64(2)}, geometry: square(10, 20, 5)},
+		&featureGPKG{columns: []interface{}{int64(3)}, geometry: square(-5, -5, 2)},
+	}, nil)
+
+	expected := map[int64][4]float64{1: {0, 1, 0, 1}, 2: {10, 15, 20, 25}, 3: {-5, -3, -5, -3}}
+	if got := rtreeEnvelopes(t, handle, `parcels`); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, got)
+	}
+
+	// the update and delete triggers maintain the RTree
+	sb, err := gpkg.NewBinary(28992, square(100, 100, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{sql: `UPDATE parcels SET geom = ? WHERE fid = 1`, args: []interface{}{sb}},
+		{sql: `UPDATE parcels SET geom = NULL WHERE fid = 2`},
+		{sql: `DELETE FROM parcels WHERE fid = 3`},
+	} {
+		if _, err = handle.Exec(query.sql, query.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected = map[int64][4]float64{1: {100, 110, 100, 110}}
+	if got := rtreeEnvelopes(t, handle, `parcels`); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, got)
+	}
+}
@@ -0,0 +1,128 @@
+package gpkg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+)
+
+// The progress of writing the tables is recorded in the sieve_state table of the target, so an
+// interrupted run can be resumed. The table is dropped when all tables in it are completed.
+const stateSQL = `CREATE TABLE IF NOT EXISTS sieve_state (
+	table_name TEXT NOT NULL PRIMARY KEY,
+	last_fid INTEGER,
+	completed BOOLEAN NOT NULL DEFAULT 0,
+	triggers TEXT
+);`
+
+// TableState is the progress of a table written to the target
+type TableState struct {
+	// Started is set when the table is created in the target
+	Started   bool
+	Completed bool
+	// LastFID is the primary key of the last feature of the last committed page, nil before the first page.
+	// Sieved in place it is the primary key up to which the features are updated or deleted.
+	LastFID *int64
+	// triggers are the RTree triggers dropped by a fast load that isn't completed
+	triggers []string
+}
+
+// InitResume makes the target continue the tables recorded in sieve_state by an interrupted run,
+// instead of handling them according to the mode
+func (target *TargetGeopackage) InitResume() {
+	target.resume = true
+}
+
+// State returns the recorded progress of the table
+func (target TargetGeopackage) State(table string) TableState {
+	state, err := readState(target.handle, table)
+	if err != nil {
+		log.Fatalf("error reading the state of %s: %s", table, err)
+	}
+	return state
+}
+
+func readState(h *gpkg.Handle, table string) (TableState, error) {
+	var state TableState
+	var triggers *string
+	err := h.QueryRow(`SELECT last_fid, completed, triggers FROM sieve_state WHERE table_name = ?`, table).
+		Scan(&state.LastFID, &state.Completed, &triggers)
+	if err == sql.ErrNoRows {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	state.Started = true
+	if triggers != nil {
+		err = json.Unmarshal([]byte(*triggers), &state.triggers)
+	}
+	return state, err
+}
+
+// startState records the table as started, a previous state of the table is reset
+func startState(h *gpkg.Handle, table string) error {
+	_, err := h.Exec(`INSERT INTO sieve_state (table_name) VALUES (?)
+		ON CONFLICT(table_name) DO UPDATE SET last_fid = NULL, completed = 0, triggers = NULL`, table)
+	return err
+}
+
+// saveTriggers records the RTree triggers dropped for a fast load, so a resumed run can install them
+func saveTriggers(h *gpkg.Handle, table string, triggers []string) error {
+	var value interface{}
+	if len(triggers) > 0 {
+		encoded, err := json.Marshal(triggers)
+		if err != nil {
+			return err
+		}
+		value = string(encoded)
+	}
+	_, err := h.Exec(`UPDATE sieve_state SET triggers = ? WHERE table_name = ?`, value, table)
+	return err
+}
+
+// recordPage records the primary key of the last feature of the page, in the transaction of the page.
+// Nothing is recorded when the primary key isn't an integer.
+func recordPage(tx *sql.Tx, t Table, last pkg.Feature) {
+	position, err := pkg.PrimaryKeyPosition(pkg.Table(t))
+	if err != nil {
+		return
+	}
+	fid, ok := last.Columns()[position].(int64)
+	if !ok {
+		return
+	}
+	recordFID(tx, t.Name, fid)
+}
+
+// recordFID records the primary key up to which the features of the table are written, or processed
+// when the table is sieved in place, in the transaction of the page
+func recordFID(tx *sql.Tx, table string, fid int64) {
+	if _, err := tx.Exec(`UPDATE sieve_state SET last_fid = ? WHERE table_name = ?`, fid, table); err != nil {
+		log.Fatalf("error recording the state of %s: %s", table, err)
+	}
+}
+
+// completeState records the table as completed
+func completeState(h *gpkg.Handle, table string) {
+	if _, err := h.Exec(`UPDATE sieve_state SET completed = 1, triggers = NULL WHERE table_name = ?`, table); err != nil {
+		log.Fatalf("error recording the state of %s: %s", table, err)
+	}
+}
+
+// dropState drops the sieve_state table when all tables in it are completed
+func dropState(h *gpkg.Handle) error {
+	var incomplete int
+	if err := h.QueryRow(`SELECT count(*) FROM sieve_state WHERE completed = 0`).Scan(&incomplete); err != nil {
+		// there is no state, the tables were never created
+		return nil
+	}
+	if incomplete > 0 {
+		return nil
+	}
+	_, err := h.Exec(`DROP TABLE sieve_state`)
+	return err
+}
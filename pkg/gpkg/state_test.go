@@ -0,0 +1,110 @@
+package gpkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestResume(t *testing.T) {
+	table := Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `Amersfoort / RD New`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992},
+	}
+	square := func(x, y, size float64) geom.Polygon {
+		return geom.Polygon{{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}}}
+	}
+	feature := func(fid int) pkg.Feature {
+		return &featureGPKG{columns: []interface{}{int64(fid)}, geometry: square(float64(fid), 0, 1)}
+	}
+
+	// the interrupted run is a normal or a fast load, the resumed run isn't a fast load
+	for k, fastLoad := range []bool{false, true} {
+		handle := openTestGeopackage(t)
+
+		// the interrupted run writes the first page and stops
+		target := TargetGeopackage{Table: table, pagesize: 2, handle: handle, mode: ModeOverwrite}
+		if fastLoad {
+			target.InitFastLoad(FastLoad{JournalMode: `MEMORY`, Synchronous: `OFF`, CacheSize: -2000})
+		}
+		if err := target.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+			t.Fatal(err)
+		}
+		target.writeFeatures([]interface{}{feature(1), feature(2)}, nil)
+
+		state := target.State(`parcels`)
+		if !state.Started || state.Completed || state.LastFID == nil || *state.LastFID != 2 {
+			t.Errorf("test: %d, expected: the last fid 2 of a started table \ngot: %+v", k, state)
+		}
+
+		// the source continues after the last written feature
+		source := SourceGeopackage{handle: handle}
+		source.SetTable(pkg.Table(table))
+		source.ResumeAfter(1)
+		preSieve := make(chan pkg.Feature)
+		go source.ReadFeatures(preSieve)
+		var fids []interface{}
+		for f := range preSieve {
+			fids = append(fids, f.Columns()[0])
+		}
+		if expected := []interface{}{int64(2)}; !reflect.DeepEqual(fids, expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected, fids)
+		}
+
+		// the resumed run keeps the written features of the overwritten table
+		resumed := TargetGeopackage{Table: table, pagesize: 2, handle: handle, mode: ModeOverwrite}
+		resumed.InitResume()
+		if err := resumed.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+			t.Fatal(err)
+		}
+		postSieve := make(chan pkg.Feature)
+		go func() {
+			for fid := 3; fid <= 5; fid++ {
+				postSieve <- feature(fid)
+			}
+			close(postSieve)
+		}()
+		resumed.WriteFeatures(postSieve)
+
+		state = resumed.State(`parcels`)
+		if !state.Completed || *state.LastFID != 5 {
+			t.Errorf("test: %d, expected: the last fid 5 of a completed table \ngot: %+v", k, state)
+		}
+		// the triggers dropped by the interrupted fast load are installed again
+		var triggers int
+		if err := handle.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = 'parcels'`).Scan(&triggers); err != nil {
+			t.Fatal(err)
+		}
+		if triggers != 6 {
+			t.Errorf("test: %d, expected: 6 triggers \ngot: %d", k, triggers)
+		}
+		expected := map[int64][4]float64{1: {1, 2, 0, 1}, 2: {2, 3, 0, 1}, 3: {3, 4, 0, 1}, 4: {4, 5, 0, 1}, 5: {5, 6, 0, 1}}
+		if got := rtreeEnvelopes(t, handle, `parcels`); !reflect.DeepEqual(got, expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected, got)
+		}
+
+		// a completed table is skipped by another resumed run, the state is dropped when all tables are completed
+		if err := resumed.CreateTables([]pkg.Table{pkg.Table(table)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := dropState(handle); err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		if err := handle.QueryRow(`SELECT count(*) FROM parcels`).Scan(&count); err != nil || count != 5 {
+			t.Errorf("test: %d, expected: 5 features \ngot: %d %v", k, count, err)
+		}
+		if err := handle.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'sieve_state'`).Scan(&count); err != nil || count != 0 {
+			t.Errorf("test: %d, expected: no sieve_state table \ngot: %d %v", k, count, err)
+		}
+		handle.Close()
+	}
+}
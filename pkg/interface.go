@@ -15,3 +15,50 @@ type Source interface {
 type Target interface {
 	WriteFeatures(chan Feature)
 }
+
+// SourceDataset is a Source containing one or more tables, like a GeoPackage
+type SourceDataset interface {
+	Source
+	GetTableInfo() []Table
+	SetTable(Table)
+	Close()
+}
+
+// TargetDataset is a Target that is able to create the tables of a SourceDataset
+type TargetDataset interface {
+	Target
+	CreateTables([]Table) error
+	SetTable(Table)
+	Close()
+}
+
+// Column describes a column of a Table, the Type is the GeoPackage (SQLite) type
+// of the column, like INTEGER, REAL, TEXT, DATETIME, BLOB or the geometry type
+type Column struct {
+	Name       string
+	Type       string
+	NotNull    bool
+	PrimaryKey bool
+	// Indexed columns get an index in the targets that support it
+	Indexed bool
+}
+
+type SpatialReferenceSystem struct {
+	Name                   string
+	ID                     int
+	Organization           string
+	OrganizationCoordsysID int
+	Definition             string
+	Description            string
+}
+
+// Table describes a feature table independent of the format it is stored in.
+// The Columns include the GeometryColumn, the values of the other columns
+// are returned by Feature.Columns() in the same order
+type Table struct {
+	Name           string
+	Columns        []Column
+	GeometryColumn string
+	GeometryType   string
+	SRS            SpatialReferenceSystem
+}
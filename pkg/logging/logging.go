@@ -0,0 +1,162 @@
+// Package logging sets up the structured logging of sieving. The records carry the table, the stage
+// and the counts as fields and are written as text, JSON or logfmt.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is the format of the log records
+type Format string
+
+const (
+	// FormatText writes the records as readable lines, with the counts aligned, this is the default
+	FormatText Format = `text`
+	// FormatJSON writes every record as a JSON object
+	FormatJSON Format = `json`
+	// FormatLogfmt writes every record as a line of key=value pairs
+	FormatLogfmt Format = `logfmt`
+)
+
+// countsKey is the group containing the counts of a stage
+const countsKey = `counts`
+
+// ParseFormat validates the given string as a Format, an empty string results in FormatText
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case ``, FormatText:
+		return FormatText, nil
+	case FormatJSON, FormatLogfmt:
+		return Format(format), nil
+	}
+	return ``, fmt.Errorf("unknown log format: %s", format)
+}
+
+// ParseLevel validates the given string as a log level: debug, info, warn or error,
+// an empty string results in info
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case ``, `info`:
+		return slog.LevelInfo, nil
+	case `debug`:
+		return slog.LevelDebug, nil
+	case `warn`:
+		return slog.LevelWarn, nil
+	case `error`:
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level: %s", level)
+}
+
+// Setup makes the records of the given level and above written in the format the default.
+// The log package is left for the fatal errors, its messages are logged at the error level.
+func Setup(w io.Writer, format Format, level slog.Level) {
+	options := &slog.HandlerOptions{AddSource: true, Level: level}
+	var handler slog.Handler
+	switch format {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case FormatLogfmt:
+		handler = slog.NewTextHandler(w, options)
+	default:
+		handler = &textHandler{mu: &sync.Mutex{}, w: w, level: level}
+	}
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+}
+
+// Counts logs the counts of a stage of a table as a single record, the counts are key-value pairs.
+// Without a table the counts are totals.
+func Counts(msg string, table string, stage string, counts ...any) {
+	logger := slog.Default()
+	if !logger.Enabled(context.Background(), slog.LevelInfo) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, msg, pcs[0])
+	if table != `` {
+		r.AddAttrs(slog.String(`table`, table))
+	}
+	r.AddAttrs(slog.String(`stage`, stage), slog.Group(countsKey, counts...))
+	_ = logger.Handler().Handle(context.Background(), r)
+}
+
+// textHandler writes the records as lines like the log package does, prefixed with the time and the
+// source. Records of a table are indented, the counts are written as aligned lines.
+type textHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{mu: h.mu, w: h.w, level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+// WithGroup isn't used by sieve, the attributes of the group are written without it
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	prefix := r.Time.Format(`2006/01/02 15:04:05 `)
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		prefix += fmt.Sprintf("%s:%d: ", filepath.Base(frame.File), frame.Line)
+	}
+	if r.Level != slog.LevelInfo {
+		prefix += r.Level.String() + ` `
+	}
+
+	attrs := append([]slog.Attr{}, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	var b strings.Builder
+	var counts []slog.Attr
+	indent := ``
+	var fields []string
+	for _, a := range attrs {
+		switch {
+		case a.Key == countsKey && a.Value.Kind() == slog.KindGroup:
+			counts = a.Value.Group()
+		case a.Key == `table`:
+			indent = `  `
+			fallthrough
+		default:
+			fields = append(fields, fmt.Sprintf("%s=%v", a.Key, a.Value))
+		}
+	}
+	if counts != nil {
+		for _, c := range counts {
+			fmt.Fprintf(&b, "%s%18s: %v\n", prefix, strings.ReplaceAll(c.Key, `_`, ` `), c.Value)
+		}
+	} else {
+		fmt.Fprintf(&b, "%s%s%s", prefix, indent, r.Message)
+		for _, field := range fields {
+			b.WriteString(` ` + field)
+		}
+		b.WriteString("\n")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	var tests = []struct {
+		format   string
+		expected Format
+		err      bool
+	}{
+		// 0
+		{format: ``, expected: FormatText},
+		// 1
+		{format: `text`, expected: FormatText},
+		// 2
+		{format: `json`, expected: FormatJSON},
+		// 3
+		{format: `logfmt`, expected: FormatLogfmt},
+		// 4
+		{format: `xml`, err: true},
+	}
+
+	for k, test := range tests {
+		got, err := ParseFormat(test.format)
+		if (err != nil) != test.err || got != test.expected {
+			t.Errorf("test: %d, expected: %s %v \ngot: %s %v", k, test.expected, test.err, got, err)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	var tests = []struct {
+		level    string
+		expected slog.Level
+		err      bool
+	}{
+		// 0
+		{level: ``, expected: slog.LevelInfo},
+		// 1
+		{level: `debug`, expected: slog.LevelDebug},
+		// 2
+		{level: `INFO`, expected: slog.LevelInfo},
+		// 3
+		{level: `warn`, expected: slog.LevelWarn},
+		// 4
+		{level: `error`, expected: slog.LevelError},
+		// 5
+		{level: `trace`, expected: slog.LevelInfo, err: true},
+	}
+
+	for k, test := range tests {
+		got, err := ParseLevel(test.level)
+		if (err != nil) != test.err || got != test.expected {
+			t.Errorf("test: %d, expected: %s %v \ngot: %s %v", k, test.expected, test.err, got, err)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	// the time and source prefixing every line
+	prefix := regexp.MustCompile(`(?m)^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} logging_test\.go:\d+: `)
+
+	var tests = []struct {
+		level    slog.Level
+		log      func()
+		expected string
+	}{
+		// 0
+		{level: slog.LevelInfo, log: func() { slog.Info(`start sieving`) },
+			expected: "start sieving\n"},
+		// 1
+		{level: slog.LevelInfo, log: func() { slog.Info(`sieving`, `table`, `parcels`) },
+			expected: "  sieving table=parcels\n"},
+		// 2
+		{level: slog.LevelInfo, log: func() { Counts(`sieved`, `parcels`, `sieve`, `total_features`, 3, `kept`, 2) },
+			expected: "    total features: 3\n              kept: 2\n"},
+		// 3
+		{level: slog.LevelInfo, log: func() { slog.Debug(`ring removed`, `ring`, 1) },
+			expected: ""},
+		// 4
+		{level: slog.LevelDebug, log: func() { slog.With(`table`, `parcels`).Debug(`ring removed`, `ring`, 1) },
+			expected: "DEBUG   ring removed table=parcels ring=1\n"},
+		// 5
+		{level: slog.LevelWarn, log: func() { Counts(`sieved`, `parcels`, `sieve`, `kept`, 2) },
+			expected: ""},
+	}
+
+	defer slog.SetDefault(slog.Default())
+	for k, test := range tests {
+		var buf bytes.Buffer
+		Setup(&buf, FormatText, test.level)
+		test.log()
+		if got := prefix.ReplaceAllString(buf.String(), ``); got != test.expected {
+			t.Errorf("test: %d, expected: %q \ngot: %q", k, test.expected, got)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	Setup(&buf, FormatJSON, slog.LevelInfo)
+	Counts(`sieved`, `parcels`, `sieve`, `total_features`, 3, `kept`, 2)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("expected: a JSON record \ngot: %s %v", buf.String(), err)
+	}
+	expected := map[string]interface{}{`table`: `parcels`, `stage`: `sieve`, `msg`: `sieved`,
+		`counts`: map[string]interface{}{`total_features`: 3., `kept`: 2.}}
+	for key, value := range expected {
+		if !reflect.DeepEqual(got[key], value) {
+			t.Errorf("expected: %s %v \ngot: %v", key, value, got[key])
+		}
+	}
+}
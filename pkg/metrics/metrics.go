@@ -0,0 +1,94 @@
+// Package metrics contains the Prometheus metrics of sieving, they are exposed with Serve
+// or pushed to a Pushgateway with Push
+package metrics
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = `sieve`
+
+var (
+	// FeaturesRead counts the features read from the source by table and zoom level. The zoom
+	// level is only set when vector tiles are written, which reads the table for every zoom level.
+	FeaturesRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `features_read_total`,
+		Help:      `Features read from the source`,
+	}, []string{`table`, `zoom`})
+	// FeaturesKept counts the features kept by the sieve by table and zoom level, the features the validation
+	// rejects afterwards are included and counted in FeaturesRejected as well
+	FeaturesKept = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `features_kept_total`,
+		Help:      `Features kept by the sieve, including those rejected by the validation`,
+	}, []string{`table`, `zoom`})
+	// FeaturesRejected counts the kept features rejected by the validation by table and zoom level
+	FeaturesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `features_rejected_total`,
+		Help:      `Features rejected by the validation`,
+	}, []string{`table`, `zoom`})
+	// FeaturesDropped counts the features removed by the sieve by table and zoom level
+	FeaturesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `features_dropped_total`,
+		Help:      `Features removed by the sieve`,
+	}, []string{`table`, `zoom`})
+	// BytesWritten counts the bytes of the values written to a GeoPackage target by table
+	BytesWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `bytes_written_total`,
+		Help:      `Bytes of the values written to the GeoPackage target`,
+	}, []string{`table`})
+	// PageCommit observes the duration of committing a page of features to a GeoPackage target by table
+	PageCommit = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      `page_commit_seconds`,
+		Help:      `Duration of committing a page of features to the GeoPackage target`,
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{`table`})
+	// StageBlocked counts the time the stages of the pipeline were blocked, receiving from the
+	// previous stage or sending to the next stage
+	StageBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      `stage_blocked_seconds_total`,
+		Help:      `Time the stages of the pipeline were blocked`,
+	}, []string{`stage`, `direction`})
+
+	// Registry contains the metrics of sieving
+	Registry = prometheus.NewRegistry()
+)
+
+func init() {
+	Registry.MustRegister(FeaturesRead, FeaturesKept, FeaturesRejected, FeaturesDropped, BytesWritten, PageCommit, StageBlocked)
+}
+
+// Handler returns the handler exposing the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes the metrics on /metrics of the given address in the background
+func Serve(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(`/metrics`, Handler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("error serving the metrics: %s", err)
+		}
+	}()
+	return server
+}
+
+// Push pushes the metrics to the Pushgateway at the given URL, replacing the metrics of the job
+func Push(url string, job string) error {
+	return push.New(url, job).Gatherer(Registry).Push()
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	FeaturesRead.WithLabelValues(`parcels`, `12`).Add(3)
+	PageCommit.WithLabelValues(`parcels`).Observe(0.002)
+	StageBlocked.WithLabelValues(`sieve`, `sending`).Add(1.5)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, `/metrics`, nil))
+	body := recorder.Body.String()
+
+	for k, expected := range []string{
+		`sieve_features_read_total{table="parcels",zoom="12"} 3`,
+		`sieve_page_commit_seconds_count{table="parcels"} 1`,
+		`sieve_stage_blocked_seconds_total{direction="sending",stage="sieve"} 1.5`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, expected, body)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	FeaturesKept.WithLabelValues(`roads`, ``).Add(2)
+
+	var method, path, body string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	if err := Push(gateway.URL, `sieve`); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != `/metrics/job/sieve` {
+		t.Errorf("expected: PUT /metrics/job/sieve \ngot: %s %s", method, path)
+	}
+	if !strings.Contains(body, `sieve_features_kept_total`) {
+		t.Errorf("expected: the metrics in the pushed body \ngot: %q", body)
+	}
+}
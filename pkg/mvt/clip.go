@@ -0,0 +1,199 @@
+package mvt
+
+import (
+	"math"
+
+	"github.com/go-spatial/geom"
+)
+
+// The geometries are transformed to tile coordinates before they are clipped,
+// with the origin at the top left of the tile and the y axis pointing down
+
+// toTile transforms the coordinates of the geometry to tile coordinates
+func toTile(geometry geom.Geometry, tile geom.Extent, extent float64) geom.Geometry {
+	scale := extent / (tile.MaxX() - tile.MinX())
+	transform := func(pts [][2]float64) [][2]float64 {
+		transformed := make([][2]float64, len(pts))
+		for i, pt := range pts {
+			transformed[i] = [2]float64{(pt[0] - tile.MinX()) * scale, (tile.MaxY() - pt[1]) * scale}
+		}
+		return transformed
+	}
+
+	switch g := geometry.(type) {
+	case geom.Point:
+		return geom.Point(transform([][2]float64{g})[0])
+	case geom.MultiPoint:
+		return geom.MultiPoint(transform(g))
+	case geom.LineString:
+		return geom.LineString(transform(g))
+	case geom.MultiLineString:
+		var ml geom.MultiLineString
+		for _, l := range g {
+			ml = append(ml, transform(l))
+		}
+		return ml
+	case geom.Polygon:
+		var p geom.Polygon
+		for _, r := range g {
+			p = append(p, transform(r))
+		}
+		return p
+	case geom.MultiPolygon:
+		var mp geom.MultiPolygon
+		for _, p := range g {
+			mp = append(mp, toTile(geom.Polygon(p), tile, extent).(geom.Polygon))
+		}
+		return mp
+	case geom.Collection:
+		var c geom.Collection
+		for _, member := range g {
+			c = append(c, toTile(member, tile, extent))
+		}
+		return c
+	}
+	return nil
+}
+
+// bounds is the clip rectangle in tile coordinates
+type bounds struct {
+	min, max float64
+}
+
+func (b bounds) contains(pt [2]float64) bool {
+	return pt[0] >= b.min && pt[0] <= b.max && pt[1] >= b.min && pt[1] <= b.max
+}
+
+// clipPoints returns the points within the bounds
+func clipPoints(pts [][2]float64, b bounds) [][2]float64 {
+	var clipped [][2]float64
+	for _, pt := range pts {
+		if b.contains(pt) {
+			clipped = append(clipped, pt)
+		}
+	}
+	return clipped
+}
+
+// clipLine clips the linestring with the Liang-Barsky algorithm, the parts outside
+// the bounds split the linestring into multiple linestrings
+func clipLine(line [][2]float64, b bounds) [][][2]float64 {
+	var lines [][][2]float64
+	var current [][2]float64
+	for i := 0; i+1 < len(line); i++ {
+		p0, p1, ok := clipSegment(line[i], line[i+1], b)
+		if !ok {
+			if len(current) > 1 {
+				lines = append(lines, current)
+			}
+			current = nil
+			continue
+		}
+		if len(current) == 0 || current[len(current)-1] != p0 {
+			if len(current) > 1 {
+				lines = append(lines, current)
+			}
+			current = [][2]float64{p0}
+		}
+		current = append(current, p1)
+		if p1 != line[i+1] {
+			// the segment leaves the bounds
+			lines = append(lines, current)
+			current = nil
+		}
+	}
+	if len(current) > 1 {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func clipSegment(p0, p1 [2]float64, b bounds) ([2]float64, [2]float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := p1[0]-p0[0], p1[1]-p0[1]
+	for _, edge := range [][2]float64{
+		{-dx, p0[0] - b.min}, {dx, b.max - p0[0]},
+		{-dy, p0[1] - b.min}, {dy, b.max - p0[1]},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return p0, p1, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return p0, p1, false
+			}
+			t0 = math.Max(t0, r)
+		} else {
+			if r < t0 {
+				return p0, p1, false
+			}
+			t1 = math.Min(t1, r)
+		}
+	}
+	c0, c1 := p0, p1
+	if t0 > 0 {
+		c0 = [2]float64{p0[0] + t0*dx, p0[1] + t0*dy}
+	}
+	if t1 < 1 {
+		c1 = [2]float64{p0[0] + t1*dx, p0[1] + t1*dy}
+	}
+	return c0, c1, true
+}
+
+// clipRing clips the ring with the Sutherland-Hodgman algorithm, parts of the ring outside
+// the bounds collapse on the edges of the bounds. Rings entirely outside return nil.
+func clipRing(ring [][2]float64, b bounds) [][2]float64 {
+	if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+		ring = ring[:len(ring)-1]
+	}
+	inside := []func([2]float64) bool{
+		func(pt [2]float64) bool { return pt[0] >= b.min },
+		func(pt [2]float64) bool { return pt[0] <= b.max },
+		func(pt [2]float64) bool { return pt[1] >= b.min },
+		func(pt [2]float64) bool { return pt[1] <= b.max },
+	}
+	intersect := []func(a, c [2]float64) [2]float64{
+		func(a, c [2]float64) [2]float64 { return atX(a, c, b.min) },
+		func(a, c [2]float64) [2]float64 { return atX(a, c, b.max) },
+		func(a, c [2]float64) [2]float64 { return atY(a, c, b.min) },
+		func(a, c [2]float64) [2]float64 { return atY(a, c, b.max) },
+	}
+
+	output := ring
+	for e := range inside {
+		input := output
+		output = nil
+		if len(input) == 0 {
+			return nil
+		}
+		prev := input[len(input)-1]
+		for _, pt := range input {
+			if inside[e](pt) {
+				if !inside[e](prev) {
+					output = append(output, intersect[e](prev, pt))
+				}
+				output = append(output, pt)
+			} else if inside[e](prev) {
+				output = append(output, intersect[e](prev, pt))
+			}
+			prev = pt
+		}
+	}
+	if len(output) < 3 {
+		return nil
+	}
+	return output
+}
+
+func atX(a, c [2]float64, x float64) [2]float64 {
+	return [2]float64{x, a[1] + (c[1]-a[1])*(x-a[0])/(c[0]-a[0])}
+}
+
+func atY(a, c [2]float64, y float64) [2]float64 {
+	return [2]float64{a[0] + (c[0]-a[0])*(y-a[1])/(c[1]-a[1]), y}
+}
@@ -0,0 +1,350 @@
+package mvt
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/go-spatial/geom"
+)
+
+// The Mapbox Vector Tile protobuf messages are encoded directly,
+// https://github.com/mapbox/vector-tile-spec/blob/master/2.1/vector_tile.proto
+
+// extent is the size of a tile in tile coordinates
+const extent = 4096
+
+// GeomType
+const (
+	geomTypePoint      = 1
+	geomTypeLineString = 2
+	geomTypePolygon    = 3
+)
+
+// geometry commands
+const (
+	commandMoveTo    = 1
+	commandLineTo    = 2
+	commandClosePath = 7
+)
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendKey(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return appendVarint(appendKey(b, field, wireVarint), v)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendVarint(appendKey(b, field, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendPackedField(b []byte, field int, values []uint32) []byte {
+	return appendBytesField(b, field, pack(values))
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+// encodeValue encodes a column value as a Value message, ok is false for
+// values that can't be stored in a vector tile
+func encodeValue(value interface{}) (b []byte, ok bool) {
+	switch v := value.(type) {
+	case string:
+		return appendBytesField(nil, 1, []byte(v)), true
+	case float64:
+		fixed := make([]byte, 8)
+		binary.LittleEndian.PutUint64(fixed, math.Float64bits(v))
+		return append(appendKey(nil, 3, wireFixed64), fixed...), true
+	case float32:
+		fixed := make([]byte, 4)
+		binary.LittleEndian.PutUint32(fixed, math.Float32bits(v))
+		return append(appendKey(nil, 2, wireFixed32), fixed...), true
+	case int64:
+		if v < 0 {
+			return appendVarintField(nil, 6, zigzag(v)), true
+		}
+		return appendVarintField(nil, 4, uint64(v)), true
+	case bool:
+		if v {
+			return appendVarintField(nil, 7, 1), true
+		}
+		return appendVarintField(nil, 7, 0), true
+	case time.Time:
+		return appendBytesField(nil, 1, []byte(v.Format(time.RFC3339))), true
+	}
+	return nil, false
+}
+
+// tileGeometry is an encoded geometry of a single GeomType
+type tileGeometry struct {
+	gtype    uint32
+	commands []uint32
+}
+
+// cursor encodes the geometry commands with coordinates relative to the previous point
+type cursor struct {
+	x, y     int64
+	commands []uint32
+}
+
+func command(id uint32, count int) uint32 {
+	return id&0x7 | uint32(count)<<3
+}
+
+func (c *cursor) points(id uint32, pts [][2]int64) {
+	c.commands = append(c.commands, command(id, len(pts)))
+	for _, pt := range pts {
+		c.commands = append(c.commands, uint32(zigzag(pt[0]-c.x)), uint32(zigzag(pt[1]-c.y)))
+		c.x, c.y = pt[0], pt[1]
+	}
+}
+
+// quantize rounds the points to integer tile coordinates, removing repeated points
+func quantize(pts [][2]float64) [][2]int64 {
+	var q [][2]int64
+	for _, pt := range pts {
+		p := [2]int64{int64(math.Round(pt[0])), int64(math.Round(pt[1]))}
+		if len(q) == 0 || q[len(q)-1] != p {
+			q = append(q, p)
+		}
+	}
+	return q
+}
+
+// ringArea returns the area of the ring in tile coordinates, positive for an exterior ring
+func ringArea(ring [][2]int64) int64 {
+	var sum int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		sum = sum + ring[i][0]*ring[j][1] - ring[j][0]*ring[i][1]
+	}
+	return sum
+}
+
+func reverse(ring [][2]int64) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
+
+// encodeGeometry clips the geometry, in tile coordinates, to the bounds and encodes it.
+// A GEOMETRYCOLLECTION results in a geometry for each GeomType of its members.
+func encodeGeometry(geometry geom.Geometry, b bounds) []tileGeometry {
+	var pts [][2]float64
+	var lines [][][2]float64
+	var polygons [][][][2]float64
+
+	var collect func(geometry geom.Geometry)
+	collect = func(geometry geom.Geometry) {
+		switch g := geometry.(type) {
+		case geom.Point:
+			pts = append(pts, clipPoints([][2]float64{g}, b)...)
+		case geom.MultiPoint:
+			pts = append(pts, clipPoints(g, b)...)
+		case geom.LineString:
+			lines = append(lines, clipLine(g, b)...)
+		case geom.MultiLineString:
+			for _, l := range g {
+				lines = append(lines, clipLine(l, b)...)
+			}
+		case geom.Polygon:
+			polygons = append(polygons, g)
+		case geom.MultiPolygon:
+			for _, p := range g {
+				polygons = append(polygons, p)
+			}
+		case geom.Collection:
+			for _, member := range g {
+				collect(member)
+			}
+		}
+	}
+	collect(geometry)
+
+	var geometries []tileGeometry
+	if len(pts) > 0 {
+		c := cursor{}
+		c.points(commandMoveTo, quantize(pts))
+		geometries = append(geometries, tileGeometry{geomTypePoint, c.commands})
+	}
+
+	c := cursor{}
+	for _, l := range lines {
+		q := quantize(l)
+		if len(q) < 2 {
+			continue
+		}
+		c.points(commandMoveTo, q[:1])
+		c.points(commandLineTo, q[1:])
+	}
+	if len(c.commands) > 0 {
+		geometries = append(geometries, tileGeometry{geomTypeLineString, c.commands})
+	}
+
+	c = cursor{}
+	for _, p := range polygons {
+		for i, r := range p {
+			clipped := clipRing(r, b)
+			if clipped == nil {
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			q := quantize(clipped)
+			if len(q) > 1 && q[0] == q[len(q)-1] {
+				q = q[:len(q)-1]
+			}
+			area := ringArea(q)
+			if len(q) < 3 || area == 0 {
+				if i == 0 {
+					break
+				}
+				continue
+			}
+			if (i == 0) != (area > 0) {
+				reverse(q)
+			}
+			c.points(commandMoveTo, q[:1])
+			c.points(commandLineTo, q[1:])
+			c.commands = append(c.commands, command(commandClosePath, 1))
+		}
+	}
+	if len(c.commands) > 0 {
+		geometries = append(geometries, tileGeometry{geomTypePolygon, c.commands})
+	}
+	return geometries
+}
+
+// layerFeature is a feature of a layer with its geometry commands packed and
+// its properties encoded as key, Value pairs, without an id it is negative
+type layerFeature struct {
+	id         int64
+	gtype      uint32
+	geometry   []byte
+	properties []byte
+}
+
+// pack encodes the commands as varints
+func pack(commands []uint32) []byte {
+	var packed []byte
+	for _, c := range commands {
+		packed = appendVarint(packed, uint64(c))
+	}
+	return packed
+}
+
+// encodeProperties encodes the properties as a sequence of length prefixed keys and Values
+func encodeProperties(keys []string, values []interface{}) []byte {
+	var b []byte
+	for i, key := range keys {
+		value, ok := encodeValue(values[i])
+		if !ok {
+			continue
+		}
+		b = appendVarint(b, uint64(len(key)))
+		b = append(b, key...)
+		b = appendVarint(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+	return b
+}
+
+// decodeProperties is the reverse of encodeProperties
+func decodeProperties(b []byte) (keys []string, values [][]byte) {
+	next := func() []byte {
+		n, size := binary.Uvarint(b)
+		v := b[size : size+int(n)]
+		b = b[size+int(n):]
+		return v
+	}
+	for len(b) > 0 {
+		keys = append(keys, string(next()))
+		values = append(values, next())
+	}
+	return keys, values
+}
+
+// encodeLayer encodes the features as a Layer message, the keys and values
+// are shared between the features
+func encodeLayer(name string, features []layerFeature) []byte {
+	keyIndex := make(map[string]uint32)
+	valueIndex := make(map[string]uint32)
+	var keys []string
+	var values [][]byte
+	var encoded [][]byte
+
+	for _, f := range features {
+		var tags []uint32
+		fkeys, fvalues := decodeProperties(f.properties)
+		for i, key := range fkeys {
+			k, ok := keyIndex[key]
+			if !ok {
+				k = uint32(len(keys))
+				keyIndex[key] = k
+				keys = append(keys, key)
+			}
+			v, ok := valueIndex[string(fvalues[i])]
+			if !ok {
+				v = uint32(len(values))
+				valueIndex[string(fvalues[i])] = v
+				values = append(values, fvalues[i])
+			}
+			tags = append(tags, k, v)
+		}
+
+		var b []byte
+		if f.id >= 0 {
+			b = appendVarintField(b, 1, uint64(f.id))
+		}
+		if len(tags) > 0 {
+			b = appendPackedField(b, 2, tags)
+		}
+		b = appendVarintField(b, 3, uint64(f.gtype))
+		b = appendBytesField(b, 4, f.geometry)
+		encoded = append(encoded, b)
+	}
+
+	var b []byte
+	b = appendVarintField(b, 15, 2)
+	b = appendBytesField(b, 1, []byte(name))
+	for _, f := range encoded {
+		b = appendBytesField(b, 2, f)
+	}
+	for _, key := range keys {
+		b = appendBytesField(b, 3, []byte(key))
+	}
+	for _, value := range values {
+		b = appendBytesField(b, 4, value)
+	}
+	return appendVarintField(b, 5, extent)
+}
+
+// encodeTile encodes the layers as a Tile message
+func encodeTile(layers [][]byte) []byte {
+	var b []byte
+	for _, layer := range layers {
+		b = appendBytesField(b, 3, layer)
+	}
+	return b
+}
@@ -0,0 +1,187 @@
+package mvt
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/gpkg"
+	"github.com/pdok/sieve/pkg"
+	sievegpkg "github.com/pdok/sieve/pkg/gpkg"
+)
+
+// The vector tiles are written to a GeoPackage tiles table with the vector tiles extensions,
+// http://www.geopackage.org/extensions.html and OGC 18-074
+
+const tileMatrixSQL = `
+CREATE TABLE IF NOT EXISTS gpkg_tile_matrix_set (
+	table_name TEXT NOT NULL PRIMARY KEY,
+	srs_id INTEGER NOT NULL,
+	min_x DOUBLE NOT NULL,
+	min_y DOUBLE NOT NULL,
+	max_x DOUBLE NOT NULL,
+	max_y DOUBLE NOT NULL,
+	CONSTRAINT fk_gtms_table_name FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+	CONSTRAINT fk_gtms_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id));
+CREATE TABLE IF NOT EXISTS gpkg_tile_matrix (
+	table_name TEXT NOT NULL,
+	zoom_level INTEGER NOT NULL,
+	matrix_width INTEGER NOT NULL,
+	matrix_height INTEGER NOT NULL,
+	tile_width INTEGER NOT NULL,
+	tile_height INTEGER NOT NULL,
+	pixel_x_size DOUBLE NOT NULL,
+	pixel_y_size DOUBLE NOT NULL,
+	CONSTRAINT pk_ttm PRIMARY KEY (table_name, zoom_level),
+	CONSTRAINT fk_tmm_table_name FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name));
+CREATE TABLE IF NOT EXISTS gpkgext_vt_layers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	table_name TEXT NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT,
+	minzoom INTEGER,
+	maxzoom INTEGER,
+	attributes_table_name TEXT,
+	CONSTRAINT fk_gvl_table_name FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+	UNIQUE (table_name, name));
+CREATE TABLE IF NOT EXISTS gpkgext_vt_fields (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	layer_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL,
+	CONSTRAINT fk_gvf_layer_id FOREIGN KEY (layer_id) REFERENCES gpkgext_vt_layers(id),
+	UNIQUE (layer_id, name));
+`
+
+const (
+	extensionVectorTiles       = `im_vector_tiles`
+	extensionMapboxVectorTiles = `im_vector_tiles_mapbox`
+	extensionDefinition        = `http://www.geopackage.org/18-074/`
+)
+
+// geopackageTiles writes the tiles to a tiles table named after the target file
+type geopackageTiles struct {
+	handle *gpkg.Handle
+	table  string
+	tms    TileMatrixSet
+	srs    pkg.SpatialReferenceSystem
+	tx     *sql.Tx
+	stmt   *sql.Stmt
+}
+
+func newGeopackageTiles(file string, tms TileMatrixSet, srs pkg.SpatialReferenceSystem) (*geopackageTiles, error) {
+	handle, err := sievegpkg.OpenHandle(file)
+	if err != nil {
+		return nil, err
+	}
+	err = handle.UpdateSRS(gpkg.SpatialReferenceSystem{
+		Name:                   srs.Name,
+		ID:                     srs.ID,
+		Organization:           srs.Organization,
+		OrganizationCoordsysID: srs.OrganizationCoordsysID,
+		Definition:             srs.Definition,
+		Description:            srs.Description,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	table := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if _, err = handle.Exec(tileMatrixSQL); err != nil {
+		return nil, err
+	}
+	_, err = handle.Exec(`CREATE TABLE "` + table + `" (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		zoom_level INTEGER NOT NULL,
+		tile_column INTEGER NOT NULL,
+		tile_row INTEGER NOT NULL,
+		tile_data BLOB NOT NULL,
+		UNIQUE (zoom_level, tile_column, tile_row))`)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := handle.Begin()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO "` + table + `" (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	return &geopackageTiles{handle: handle, table: table, tms: tms, srs: srs, tx: tx, stmt: stmt}, nil
+}
+
+func (g *geopackageTiles) writeTile(zoom, col, row int, data []byte) error {
+	_, err := g.stmt.Exec(zoom, col, row, data)
+	return err
+}
+
+// close registers the tiles table with its tile matrices, layers and the extensions
+func (g *geopackageTiles) close(layers []layer, minZoom, maxZoom int, extent *geom.Extent) error {
+	defer g.handle.Close()
+	g.stmt.Close()
+	if err := g.tx.Commit(); err != nil {
+		return err
+	}
+
+	contents := g.tms.Extent()
+	if extent != nil {
+		contents = *extent
+	}
+	_, err := g.handle.Exec(`INSERT INTO gpkg_contents (table_name, data_type, identifier, min_x, min_y, max_x, max_y, srs_id)
+		VALUES (?, 'vector-tiles', ?, ?, ?, ?, ?, ?)`,
+		g.table, g.table, contents.MinX(), contents.MinY(), contents.MaxX(), contents.MaxY(), g.srs.ID)
+	if err != nil {
+		return err
+	}
+
+	tms := g.tms.Extent()
+	_, err = g.handle.Exec(`INSERT INTO gpkg_tile_matrix_set (table_name, srs_id, min_x, min_y, max_x, max_y) VALUES (?, ?, ?, ?, ?, ?)`,
+		g.table, g.srs.ID, tms.MinX(), tms.MinY(), tms.MaxX(), tms.MaxY())
+	if err != nil {
+		return err
+	}
+	for zoom := minZoom; zoom <= maxZoom; zoom++ {
+		_, err = g.handle.Exec(`INSERT INTO gpkg_tile_matrix (table_name, zoom_level, matrix_width, matrix_height,
+			tile_width, tile_height, pixel_x_size, pixel_y_size) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			g.table, zoom, g.tms.matrixSize(zoom), g.tms.matrixSize(zoom), tileSize, tileSize,
+			g.tms.Resolution(zoom), g.tms.Resolution(zoom))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, l := range layers {
+		result, err := g.handle.Exec(`INSERT INTO gpkgext_vt_layers (table_name, name, minzoom, maxzoom) VALUES (?, ?, ?, ?)`,
+			g.table, l.name, l.minZoom, l.maxZoom)
+		if err != nil {
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, f := range l.fields {
+			_, err = g.handle.Exec(`INSERT INTO gpkgext_vt_fields (layer_id, name, type) VALUES (?, ?, ?)`, id, f.name, f.ftype)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, e := range [][3]interface{}{
+		{g.table, `tile_data`, extensionVectorTiles},
+		{g.table, `tile_data`, extensionMapboxVectorTiles},
+		{`gpkgext_vt_layers`, nil, extensionVectorTiles},
+		{`gpkgext_vt_fields`, nil, extensionVectorTiles},
+	} {
+		_, err = g.handle.Exec(`INSERT INTO gpkg_extensions (table_name, column_name, extension_name, definition, scope)
+			VALUES (?, ?, ?, ?, 'read-write')`, e[0], e[1], e[2], extensionDefinition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
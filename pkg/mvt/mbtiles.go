@@ -0,0 +1,131 @@
+package mvt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-spatial/geom"
+)
+
+// mbtiles writes the tiles gzipped to an MBTiles 1.3 file,
+// https://github.com/mapbox/mbtiles-spec/blob/master/1.3/spec.md
+type mbtiles struct {
+	// name is the name in the metadata when there are no layers
+	name string
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+func newMBTiles(file string) (*mbtiles, error) {
+	db, err := sql.Open(`sqlite3`, file)
+	if err != nil {
+		return nil, err
+	}
+	for _, query := range []string{
+		`CREATE TABLE metadata (name TEXT, value TEXT)`,
+		`CREATE TABLE tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB)`,
+		`CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)`,
+	} {
+		if _, err = db.Exec(query); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	return &mbtiles{name: name, db: db, tx: tx, stmt: stmt}, nil
+}
+
+// writeTile writes the tile with the row flipped, MBTiles uses the TMS tiling scheme
+func (m *mbtiles) writeTile(zoom, col, row int, data []byte) error {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, err := m.stmt.Exec(zoom, col, (1<<uint(zoom))-1-row, buf.Bytes())
+	return err
+}
+
+type vectorLayer struct {
+	ID      string            `json:"id"`
+	Fields  map[string]string `json:"fields"`
+	MinZoom int               `json:"minzoom"`
+	MaxZoom int               `json:"maxzoom"`
+}
+
+func (m *mbtiles) close(layers []layer, minZoom, maxZoom int, extent *geom.Extent) error {
+	defer m.db.Close()
+	m.stmt.Close()
+	if err := m.tx.Commit(); err != nil {
+		return err
+	}
+
+	name := m.name
+	if len(layers) > 0 {
+		name = layers[0].name
+	}
+	vectorLayers := []vectorLayer{}
+	for _, l := range layers {
+		fields := make(map[string]string)
+		for _, f := range l.fields {
+			fields[f.name] = f.ftype
+		}
+		vectorLayers = append(vectorLayers, vectorLayer{l.name, fields, l.minZoom, l.maxZoom})
+	}
+	vectorLayersJSON, err := json.Marshal(struct {
+		VectorLayers []vectorLayer `json:"vector_layers"`
+	}{vectorLayers})
+	if err != nil {
+		return err
+	}
+
+	metadata := [][2]string{
+		{`name`, name},
+		{`format`, `pbf`},
+		{`type`, `overlay`},
+		{`minzoom`, strconv.Itoa(minZoom)},
+		{`maxzoom`, strconv.Itoa(maxZoom)},
+		{`json`, string(vectorLayersJSON)},
+	}
+	if extent != nil {
+		minLon, minLat := toLonLat(extent.MinX(), extent.MinY())
+		maxLon, maxLat := toLonLat(extent.MaxX(), extent.MaxY())
+		metadata = append(metadata,
+			[2]string{`bounds`, fmt.Sprintf("%f,%f,%f,%f", minLon, minLat, maxLon, maxLat)},
+			[2]string{`center`, fmt.Sprintf("%f,%f,%d", (minLon+maxLon)/2, (minLat+maxLat)/2, minZoom)})
+	}
+	for _, row := range metadata {
+		if _, err = m.db.Exec(`INSERT INTO metadata (name, value) VALUES (?, ?)`, row[0], row[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// earthRadius is the radius of the sphere used by Web Mercator
+const earthRadius = 6378137.0
+
+// toLonLat transforms Web Mercator coordinates to longitude and latitude
+func toLonLat(x, y float64) (float64, float64) {
+	lon := x / earthRadius * 180 / math.Pi
+	lat := (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lon, lat
+}
@@ -0,0 +1,281 @@
+package mvt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+func TestTileRange(t *testing.T) {
+	var tests = []struct {
+		extent   geom.Extent
+		zoom     int
+		buffer   float64
+		expected [4]int
+	}{
+		// 0
+		{extent: geom.Extent{-1, -1, 1, 1}, zoom: 0, expected: [4]int{0, 0, 0, 0}},
+		// 1
+		{extent: geom.Extent{-1, -1, 1, 1}, zoom: 1, expected: [4]int{0, 0, 1, 1}},
+		// 2
+		{extent: geom.Extent{1, 1, 2, 2}, zoom: 1, expected: [4]int{1, 0, 1, 0}},
+		// 3 the buffer reaches into the neighbouring tiles
+		{extent: geom.Extent{1, 1, 2, 2}, zoom: 1, buffer: 0.1, expected: [4]int{0, 0, 1, 1}},
+		// 4 outside the tile matrix set
+		{extent: geom.Extent{-3e7, -3e7, -2.5e7, -2.5e7}, zoom: 2, expected: [4]int{0, 3, 0, 3}},
+	}
+
+	for k, test := range tests {
+		minCol, minRow, maxCol, maxRow := WebMercatorQuad.TileRange(test.extent, test.zoom, test.buffer)
+		if got := [4]int{minCol, minRow, maxCol, maxRow}; got != test.expected {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	var tests = []struct {
+		line     [][2]float64
+		expected [][][2]float64
+	}{
+		// 0 inside
+		{line: [][2]float64{{1, 1}, {5, 5}}, expected: [][][2]float64{{{1, 1}, {5, 5}}}},
+		// 1 outside
+		{line: [][2]float64{{-5, 1}, {-1, 5}}, expected: nil},
+		// 2 crossing
+		{line: [][2]float64{{-5, 5}, {15, 5}}, expected: [][][2]float64{{{0, 5}, {10, 5}}}},
+		// 3 leaving and entering splits the line
+		{line: [][2]float64{{5, 5}, {15, 5}, {15, 8}, {5, 8}},
+			expected: [][][2]float64{{{5, 5}, {10, 5}}, {{10, 8}, {5, 8}}}},
+	}
+
+	for k, test := range tests {
+		got := clipLine(test.line, bounds{0, 10})
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
+
+func TestClipRing(t *testing.T) {
+	var tests = []struct {
+		ring     [][2]float64
+		expected [][2]float64
+	}{
+		// 0 inside
+		{ring: [][2]float64{{1, 1}, {5, 1}, {5, 5}, {1, 5}, {1, 1}}, expected: [][2]float64{{1, 1}, {5, 1}, {5, 5}, {1, 5}}},
+		// 1 outside
+		{ring: [][2]float64{{11, 11}, {15, 11}, {15, 15}, {11, 15}}, expected: nil},
+		// 2 covering
+		{ring: [][2]float64{{-5, -5}, {15, -5}, {15, 15}, {-5, 15}}, expected: [][2]float64{{0, 10}, {0, 0}, {10, 0}, {10, 10}}},
+	}
+
+	for k, test := range tests {
+		got := clipRing(test.ring, bounds{0, 10})
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
+
+func TestEncodeGeometry(t *testing.T) {
+	var tests = []struct {
+		geometry geom.Geometry
+		expected []tileGeometry
+	}{
+		// 0 example from the vector tile specification
+		{geometry: geom.Point{25, 17}, expected: []tileGeometry{{geomTypePoint, []uint32{9, 50, 34}}}},
+		// 1
+		{geometry: geom.LineString{{2, 2}, {2, 10}, {10, 10}},
+			expected: []tileGeometry{{geomTypeLineString, []uint32{9, 4, 4, 18, 0, 16, 16, 0}}}},
+		// 2 the exterior is reversed to a positive area in tile coordinates
+		{geometry: geom.Polygon{{{3, 6}, {3, 12}, {8, 12}}},
+			expected: []tileGeometry{{geomTypePolygon, []uint32{9, 16, 24, 18, 9, 0, 0, 11, 15}}}},
+		// 3 collapsing in quantization
+		{geometry: geom.Polygon{{{3, 6}, {3.1, 6}, {3.1, 6.1}}}, expected: nil},
+		// 4 a collection results in a geometry per type
+		{geometry: geom.Collection{geom.Point{25, 17}, geom.LineString{{2, 2}, {2, 10}, {10, 10}}},
+			expected: []tileGeometry{{geomTypePoint, []uint32{9, 50, 34}},
+				{geomTypeLineString, []uint32{9, 4, 4, 18, 0, 16, 16, 0}}}},
+	}
+
+	for k, test := range tests {
+		got := encodeGeometry(test.geometry, bounds{-buffer, extent + buffer})
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
+
+type featureMVT struct {
+	columns  []interface{}
+	geometry geom.Geometry
+}
+
+func (f featureMVT) Columns() []interface{} {
+	return f.columns
+}
+
+func (f featureMVT) Geometry() geom.Geometry {
+	return f.geometry
+}
+
+func (f *featureMVT) UpdateGeometry(geometry geom.Geometry) {
+	f.geometry = geometry
+}
+
+type sourceMVT []featureMVT
+
+func (s sourceMVT) ReadFeatures(preSieve chan pkg.Feature) {
+	for i := range s {
+		f := s[i]
+		preSieve <- &f
+	}
+	close(preSieve)
+}
+
+func TestMBTiles(t *testing.T) {
+	table := pkg.Table{
+		Name: `buildings`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, PrimaryKey: true},
+			{Name: `name`, Type: `TEXT`},
+			{Name: `height`, Type: `REAL`},
+			{Name: `geom`, Type: `POLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `POLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Name: `EPSG:3857`, ID: 3857, OrganizationCoordsysID: 3857},
+	}
+	// a large polygon in the north east quadrant and a polygon of 100 by 100 meters
+	source := sourceMVT{
+		{columns: []interface{}{int64(1), `large`, 10.0},
+			geometry: geom.Polygon{{{1e6, 1e6}, {5e6, 1e6}, {5e6, 5e6}, {1e6, 5e6}}}},
+		{columns: []interface{}{int64(2), `small`, 2.5},
+			geometry: geom.Polygon{{{-1e6, -1e6}, {-1e6 + 100, -1e6}, {-1e6 + 100, -1e6 + 100}, {-1e6, -1e6 + 100}}}},
+	}
+
+	file := filepath.Join(t.TempDir(), `buildings.mbtiles`)
+	target := &TargetTiles{}
+	target.Init(file, WebMercatorQuad, 0, 2)
+	if err := target.CreateTables([]pkg.Table{table}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	target.SetTable(table)
+	for zoom := 0; zoom <= 2; zoom++ {
+		target.SetZoom(zoom)
+		pkg.Sieve(source, target, pkg.Options{Resolution: WebMercatorQuad.Resolution(zoom)})
+	}
+	target.Close()
+
+	db, err := sql.Open(`sqlite3`, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// the small polygon is sieved, the large one covers a single tile on every zoom level
+	rows, err := db.Query(`SELECT zoom_level, tile_column, tile_row, tile_data FROM tiles ORDER BY zoom_level`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tiles [][3]int
+	for rows.Next() {
+		var tile [3]int
+		var data []byte
+		if err = rows.Scan(&tile[0], &tile[1], &tile[2], &data); err != nil {
+			t.Fatal(err)
+		}
+		tiles = append(tiles, tile)
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, _ := io.ReadAll(r)
+		if !bytes.Contains(decoded, []byte(`buildings`)) || !bytes.Contains(decoded, []byte(`large`)) {
+			t.Errorf("expected the buildings layer with the large feature in tile %v", tile)
+		}
+	}
+	rows.Close()
+	expected := [][3]int{{0, 0, 0}, {1, 1, 1}, {2, 2, 2}}
+	if !reflect.DeepEqual(tiles, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, tiles)
+	}
+
+	var vectorLayers string
+	if err = db.QueryRow(`SELECT value FROM metadata WHERE name = 'json'`).Scan(&vectorLayers); err != nil {
+		t.Fatal(err)
+	}
+	expectedLayers := `{"vector_layers":[{"id":"buildings","fields":{"height":"Number","name":"String"},"minzoom":0,"maxzoom":2}]}`
+	if vectorLayers != expectedLayers {
+		t.Errorf("expected: %s \ngot: %s", expectedLayers, vectorLayers)
+	}
+}
+
+func TestMBTilesEmpty(t *testing.T) {
+	file := filepath.Join(t.TempDir(), `empty.mbtiles`)
+	target := &TargetTiles{}
+	target.Init(file, WebMercatorQuad, 0, 2)
+	if err := target.CreateTables(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	target.Close()
+
+	db, err := sql.Open(`sqlite3`, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		// 0 without layers the name of the file is used
+		{name: `name`, expected: `empty`},
+		// 1
+		{name: `json`, expected: `{"vector_layers":[]}`},
+	}
+
+	for k, test := range tests {
+		var value string
+		if err = db.QueryRow(`SELECT value FROM metadata WHERE name = ?`, test.name).Scan(&value); err != nil || value != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s %v", k, test.expected, value, err)
+		}
+	}
+}
+
+func TestCreateTables(t *testing.T) {
+	var tests = []struct {
+		file    string
+		tms     TileMatrixSet
+		srs     int
+		minZoom int
+		maxZoom int
+	}{
+		// 0 MBTiles only supports WebMercatorQuad
+		{file: `tiles.mbtiles`, tms: NetherlandsRDNewQuad, srs: 28992, maxZoom: 2},
+		// 1 the table must be in the CRS of the tile matrix set
+		{file: `tiles.mbtiles`, tms: WebMercatorQuad, srs: 4326, maxZoom: 2},
+		// 2
+		{file: `tiles.gpkg`, tms: NetherlandsRDNewQuad, srs: 28992, maxZoom: 17},
+		// 3
+		{file: `tiles.gpkg`, tms: NetherlandsRDNewQuad, srs: 28992, minZoom: 3, maxZoom: 2},
+	}
+
+	for k, test := range tests {
+		target := &TargetTiles{}
+		target.Init(filepath.Join(t.TempDir(), test.file), test.tms, test.minZoom, test.maxZoom)
+		err := target.CreateTables([]pkg.Table{{Name: `a`, SRS: pkg.SpatialReferenceSystem{ID: test.srs, OrganizationCoordsysID: test.srs}}})
+		if err == nil {
+			t.Errorf("test: %d, expected an error", k)
+		}
+	}
+}
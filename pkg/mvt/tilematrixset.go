@@ -0,0 +1,106 @@
+package mvt
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/go-spatial/geom"
+)
+
+// TileMatrixSet describes a quad tree tile matrix set, as defined by the
+// OGC Two Dimensional Tile Matrix Set standard, with tiles of 256 pixels
+type TileMatrixSet struct {
+	Identifier string
+	// SRS is the EPSG code of the CRS of the tile matrix set
+	SRS int
+	// OriginX and OriginY are the top left corner of the tile matrices
+	OriginX float64
+	OriginY float64
+	// CellSize is the size of a pixel at zoom level 0
+	CellSize float64
+	MaxZoom  int
+}
+
+// tileSize is the size in pixels of a tile in the tile matrix set
+const tileSize = 256
+
+var WebMercatorQuad = TileMatrixSet{
+	Identifier: `WebMercatorQuad`,
+	SRS:        3857,
+	OriginX:    -20037508.3427892,
+	OriginY:    20037508.3427892,
+	CellSize:   156543.033928041,
+	MaxZoom:    24,
+}
+
+var NetherlandsRDNewQuad = TileMatrixSet{
+	Identifier: `NetherlandsRDNewQuad`,
+	SRS:        28992,
+	OriginX:    -285401.92,
+	OriginY:    903401.92,
+	CellSize:   3440.64,
+	MaxZoom:    16,
+}
+
+// ParseTileMatrixSet returns the tile matrix set with the given identifier,
+// an empty string results in WebMercatorQuad
+func ParseTileMatrixSet(identifier string) (TileMatrixSet, error) {
+	for _, tms := range []TileMatrixSet{WebMercatorQuad, NetherlandsRDNewQuad} {
+		if identifier == `` || strings.EqualFold(identifier, tms.Identifier) {
+			return tms, nil
+		}
+	}
+	return TileMatrixSet{}, fmt.Errorf("unknown tile matrix set: %s, expected %s or %s",
+		identifier, WebMercatorQuad.Identifier, NetherlandsRDNewQuad.Identifier)
+}
+
+// Resolution returns the size of a pixel at the zoom level, used as the sieve resolution
+func (tms TileMatrixSet) Resolution(zoom int) float64 {
+	return tms.CellSize / math.Pow(2, float64(zoom))
+}
+
+// span returns the width and height of a tile at the zoom level
+func (tms TileMatrixSet) span(zoom int) float64 {
+	return tms.Resolution(zoom) * tileSize
+}
+
+// matrixSize returns the number of tiles in a row or column of the tile matrix
+func (tms TileMatrixSet) matrixSize(zoom int) int {
+	return 1 << uint(zoom)
+}
+
+// TileExtent returns the extent of the tile
+func (tms TileMatrixSet) TileExtent(zoom, col, row int) geom.Extent {
+	span := tms.span(zoom)
+	minX := tms.OriginX + float64(col)*span
+	maxY := tms.OriginY - float64(row)*span
+	return geom.Extent{minX, maxY - span, minX + span, maxY}
+}
+
+// Extent returns the extent covered by the tile matrix set
+func (tms TileMatrixSet) Extent() geom.Extent {
+	return tms.TileExtent(0, 0, 0)
+}
+
+// TileRange returns the columns and rows of the tiles intersecting the extent grown
+// with the buffer, given as fraction of the tile size
+func (tms TileMatrixSet) TileRange(extent geom.Extent, zoom int, buffer float64) (minCol, minRow, maxCol, maxRow int) {
+	span := tms.span(zoom)
+	b := buffer * span
+	clamp := func(v float64) int {
+		n := int(math.Floor(v))
+		if n < 0 {
+			return 0
+		}
+		if n >= tms.matrixSize(zoom) {
+			return tms.matrixSize(zoom) - 1
+		}
+		return n
+	}
+	minCol = clamp((extent.MinX() - b - tms.OriginX) / span)
+	maxCol = clamp((extent.MaxX() + b - tms.OriginX) / span)
+	minRow = clamp((tms.OriginY - extent.MaxY() - b) / span)
+	maxRow = clamp((tms.OriginY - extent.MinY() + b) / span)
+	return minCol, minRow, maxCol, maxRow
+}
@@ -0,0 +1,327 @@
+package mvt
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-spatial/geom"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pdok/sieve/pkg"
+	"github.com/pdok/sieve/pkg/logging"
+)
+
+// buffer is the size of the buffer around a tile in tile coordinates,
+// geometries are clipped on the buffer so the tile edges aren't rendered
+const buffer = 64
+
+// layer is the vector tile layer written for a table
+type layer struct {
+	name string
+	// fields are the columns written as properties with their type
+	fields  []field
+	columns []int
+	minZoom int
+	maxZoom int
+}
+
+type field struct {
+	name string
+	// ftype is the type of the field, Number, String or Boolean
+	ftype string
+}
+
+// newLayer determines the properties of the layer from the table, the primary key
+// is used as feature id and BLOB columns can't be stored in a vector tile
+func newLayer(table pkg.Table, minZoom, maxZoom int) layer {
+	l := layer{name: table.Name, minZoom: minZoom, maxZoom: maxZoom}
+	i := 0
+	for _, column := range table.Columns {
+		if column.Name == table.GeometryColumn {
+			continue
+		}
+		ctype := strings.ToUpper(column.Type)
+		switch {
+		case column.PrimaryKey:
+		case strings.HasPrefix(ctype, `BLOB`):
+			slog.Warn(`column is not written to the vector tiles`, `table`, table.Name, `column`, column.Name)
+		case strings.Contains(ctype, `INT`), ctype == `REAL`, ctype == `FLOAT`, ctype == `DOUBLE`:
+			l.fields = append(l.fields, field{column.Name, `Number`})
+			l.columns = append(l.columns, i)
+		case ctype == `BOOLEAN`:
+			l.fields = append(l.fields, field{column.Name, `Boolean`})
+			l.columns = append(l.columns, i)
+		default:
+			l.fields = append(l.fields, field{column.Name, `String`})
+			l.columns = append(l.columns, i)
+		}
+		i++
+	}
+	return l
+}
+
+// primaryKey returns the position of the primary key in Feature.Columns(), -1 without one
+func primaryKey(table pkg.Table) int {
+	i := 0
+	for _, column := range table.Columns {
+		if column.Name == table.GeometryColumn {
+			continue
+		}
+		if column.PrimaryKey {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// TargetTiles writes the features as Mapbox Vector Tiles, one layer per table, to an
+// MBTiles file or the tiles table of a GeoPackage. The tables are sieved for every zoom
+// level, the features of a zoom level are clipped and quantized into the tiles and
+// spooled to a temporary SQLite database. The tiles are assembled when the target is closed.
+type TargetTiles struct {
+	Table         pkg.Table
+	TileMatrixSet TileMatrixSet
+	MinZoom       int
+	MaxZoom       int
+	file          string
+	zoom          int
+	layers        []layer
+	layer         int
+	srs           pkg.SpatialReferenceSystem
+	extent        *geom.Extent
+	spool         *sql.DB
+	spoolDir      string
+}
+
+// isMBTiles determines the format of a file by its extension, other files are written as GeoPackage
+func isMBTiles(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), `.mbtiles`)
+}
+
+func (target *TargetTiles) Init(file string, tms TileMatrixSet, minZoom, maxZoom int) {
+	target.file = file
+	target.TileMatrixSet = tms
+	target.MinZoom = minZoom
+	target.MaxZoom = maxZoom
+	target.zoom = minZoom
+
+	dir, err := os.MkdirTemp(``, `sieve-tiles-`)
+	if err != nil {
+		log.Fatalf("error creating temporary directory: %s", err)
+	}
+	target.spoolDir = dir
+	target.spool, err = sql.Open(`sqlite3`, filepath.Join(dir, `spool.db`))
+	if err != nil {
+		log.Fatalf("error creating the tile spool: %s", err)
+	}
+	_, err = target.spool.Exec(`CREATE TABLE features (z INTEGER, x INTEGER, y INTEGER, layer INTEGER,
+		id INTEGER, type INTEGER, geometry BLOB, properties BLOB)`)
+	if err != nil {
+		log.Fatalf("error creating the tile spool: %s", err)
+	}
+}
+
+// SetZoom sets the zoom level of the features written next
+func (target *TargetTiles) SetZoom(zoom int) {
+	target.zoom = zoom
+}
+
+func (target *TargetTiles) SetTable(table pkg.Table) {
+	target.Table = table
+	for i := range target.layers {
+		if target.layers[i].name == table.Name {
+			target.layer = i
+		}
+	}
+}
+
+// CreateTables creates a layer for every table, the tables must be in the CRS of the tile matrix set
+func (target *TargetTiles) CreateTables(tables []pkg.Table) error {
+	if target.MinZoom < 0 || target.MinZoom > target.MaxZoom || target.MaxZoom > target.TileMatrixSet.MaxZoom {
+		return fmt.Errorf("invalid zoom levels %d to %d, expected 0 to %d", target.MinZoom, target.MaxZoom, target.TileMatrixSet.MaxZoom)
+	}
+	if isMBTiles(target.file) && target.TileMatrixSet.Identifier != WebMercatorQuad.Identifier {
+		return fmt.Errorf("MBTiles only supports %s", WebMercatorQuad.Identifier)
+	}
+	if _, err := os.Stat(target.file); isMBTiles(target.file) && err == nil {
+		return fmt.Errorf("target %s already exists", target.file)
+	}
+	for _, table := range tables {
+		if table.SRS.OrganizationCoordsysID != target.TileMatrixSet.SRS && table.SRS.ID != target.TileMatrixSet.SRS {
+			return fmt.Errorf("table %s is in %s, expected EPSG:%d for %s", table.Name, table.SRS.Name,
+				target.TileMatrixSet.SRS, target.TileMatrixSet.Identifier)
+		}
+		target.srs = table.SRS
+		target.layers = append(target.layers, newLayer(table, target.MinZoom, target.MaxZoom))
+	}
+	return nil
+}
+
+// WriteFeatures encodes the features in the tiles of the current zoom level they intersect
+func (target *TargetTiles) WriteFeatures(postSieve chan pkg.Feature) {
+	tx, err := target.spool.Begin()
+	if err != nil {
+		log.Fatalf("Could not start a transaction: %s", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO features (z, x, y, layer, id, type, geometry, properties) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		log.Fatalf("Could not prepare a statement: %s", err)
+	}
+
+	l := target.layers[target.layer]
+	pk := primaryKey(target.Table)
+	keys := make([]string, len(l.fields))
+	for i, f := range l.fields {
+		keys[i] = f.name
+	}
+	tms := target.TileMatrixSet
+	b := bounds{-buffer, extent + buffer}
+
+	for {
+		feature, hasMore := <-postSieve
+		if !hasMore {
+			break
+		}
+		if feature.Geometry() == nil {
+			continue
+		}
+		ext, err := geom.NewExtentFromGeometry(feature.Geometry())
+		if err != nil {
+			continue
+		}
+		if target.extent == nil {
+			e := *ext
+			target.extent = &e
+		} else {
+			target.extent.Add(ext)
+		}
+
+		columns := feature.Columns()
+		var id int64 = -1
+		if pk >= 0 {
+			if v, ok := columns[pk].(int64); ok && v >= 0 {
+				id = v
+			}
+		}
+		values := make([]interface{}, len(l.columns))
+		for i, c := range l.columns {
+			values[i] = columns[c]
+		}
+		properties := encodeProperties(keys, values)
+
+		minCol, minRow, maxCol, maxRow := tms.TileRange(*ext, target.zoom, float64(buffer)/extent)
+		for col := minCol; col <= maxCol; col++ {
+			for row := minRow; row <= maxRow; row++ {
+				tile := toTile(feature.Geometry(), tms.TileExtent(target.zoom, col, row), extent)
+				for _, g := range encodeGeometry(tile, b) {
+					_, err = stmt.Exec(target.zoom, col, row, target.layer, id, g.gtype, pack(g.commands), properties)
+					if err != nil {
+						log.Fatalf("Could not spool the tile feature: %s", err)
+					}
+				}
+			}
+		}
+	}
+	stmt.Close()
+	if err = tx.Commit(); err != nil {
+		log.Fatalf("Could not commit the tile features: %s", err)
+	}
+}
+
+// Close assembles the tiles from the spooled features and writes them to the target
+func (target *TargetTiles) Close() {
+	defer os.RemoveAll(target.spoolDir)
+	defer target.spool.Close()
+
+	var w tileWriter
+	var err error
+	if isMBTiles(target.file) {
+		w, err = newMBTiles(target.file)
+	} else {
+		w, err = newGeopackageTiles(target.file, target.TileMatrixSet, target.srs)
+	}
+	if err != nil {
+		log.Fatalf("error creating target: %s", err)
+	}
+
+	count, err := target.writeTiles(w)
+	if err != nil {
+		log.Fatalf("error writing tiles: %s", err)
+	}
+	if err = w.close(target.layers, target.MinZoom, target.MaxZoom, target.extent); err != nil {
+		log.Fatalf("error writing tiles: %s", err)
+	}
+	logging.Counts(`tiles written`, ``, `write`, `tiles`, count)
+}
+
+// writeTiles encodes the spooled features, ordered by tile and layer, as tiles
+func (target *TargetTiles) writeTiles(w tileWriter) (int, error) {
+	_, err := target.spool.Exec(`CREATE INDEX features_tile ON features (z, x, y, layer)`)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := target.spool.Query(`SELECT z, x, y, layer, id, type, geometry, properties FROM features ORDER BY z, x, y, layer, rowid`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	current := [3]int{-1, -1, -1}
+	currentLayer := -1
+	var layers [][]byte
+	var features []layerFeature
+
+	flushLayer := func() {
+		if len(features) > 0 {
+			layers = append(layers, encodeLayer(target.layers[currentLayer].name, features))
+		}
+		features = nil
+	}
+	flushTile := func() error {
+		flushLayer()
+		if len(layers) == 0 {
+			return nil
+		}
+		count++
+		data := encodeTile(layers)
+		layers = nil
+		return w.writeTile(current[0], current[1], current[2], data)
+	}
+
+	for rows.Next() {
+		var tile [3]int
+		var l int
+		var f layerFeature
+		if err = rows.Scan(&tile[0], &tile[1], &tile[2], &l, &f.id, &f.gtype, &f.geometry, &f.properties); err != nil {
+			return count, err
+		}
+		if tile != current {
+			if err = flushTile(); err != nil {
+				return count, err
+			}
+			current = tile
+			currentLayer = l
+		} else if l != currentLayer {
+			flushLayer()
+			currentLayer = l
+		}
+		features = append(features, f)
+	}
+	if err = rows.Err(); err != nil {
+		return count, err
+	}
+	return count, flushTile()
+}
+
+// tileWriter stores the encoded tiles in the target format
+type tileWriter interface {
+	writeTile(zoom, col, row int, data []byte) error
+	// close writes the metadata of the layers, the extent is nil when no features are written
+	close(layers []layer, minZoom, maxZoom int, extent *geom.Extent) error
+}
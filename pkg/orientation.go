@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/go-spatial/geom"
+)
+
+// Orientation is the ring orientation convention enforced on the output
+type Orientation string
+
+const (
+	// OrientationNone keeps the rings as they are, this is the default
+	OrientationNone Orientation = `none`
+	// OrientationMVT orients the exterior clockwise and the interiors counter-clockwise
+	OrientationMVT Orientation = `mvt`
+	// OrientationOGC orients the exterior counter-clockwise and the interiors clockwise
+	OrientationOGC Orientation = `ogc`
+	// OrientationRFC7946 follows the right-hand rule, like OrientationOGC
+	OrientationRFC7946 Orientation = `rfc7946`
+)
+
+// ParseOrientation validates the given string as a Orientation, an empty string results in OrientationNone
+func ParseOrientation(orientation string) (Orientation, error) {
+	switch Orientation(orientation) {
+	case ``, OrientationNone:
+		return OrientationNone, nil
+	case OrientationMVT, OrientationOGC, OrientationRFC7946:
+		return Orientation(orientation), nil
+	}
+	return ``, fmt.Errorf("unknown orientation: %s", orientation)
+}
+
+// exteriorClockwise returns if the convention orients the exterior clockwise
+func (orientation Orientation) exteriorClockwise() bool {
+	return orientation == OrientationMVT
+}
+
+// orientFeatures enforces the ring orientation on the (MULTI)POLYGON geometries
+func orientFeatures(in chan Feature, out chan Feature, orientation Orientation, stage *StageBlocking) {
+	for {
+		feature, hasMore := stage.receive(in)
+		if !hasMore {
+			break
+		}
+		feature.UpdateGeometry(orientGeometry(feature.Geometry(), orientation))
+		stage.send(out, feature)
+	}
+	close(out)
+}
+
+// orientGeometry orients the POLYGONs of a geometry, including those in a GEOMETRYCOLLECTION
+func orientGeometry(geometry geom.Geometry, orientation Orientation) geom.Geometry {
+	switch g := geometry.(type) {
+	case geom.Polygon:
+		return orientPolygon(g, orientation)
+	case geom.MultiPolygon:
+		var oriented geom.MultiPolygon
+		for _, p := range g {
+			oriented = append(oriented, orientPolygon(p, orientation))
+		}
+		return oriented
+	case geom.Collection:
+		var oriented geom.Collection
+		for _, member := range g {
+			oriented = append(oriented, orientGeometry(member, orientation))
+		}
+		return oriented
+	}
+	return geometry
+}
+
+// orientPolygon reverses the rings of the POLYGON that don't follow the orientation,
+// the direction of a ring is determined by the sign of its shoelace area
+func orientPolygon(p geom.Polygon, orientation Orientation) geom.Polygon {
+	if orientation == OrientationNone {
+		return p
+	}
+	var oriented geom.Polygon
+	for i, ring := range p {
+		clockwise := SignedArea(ring) < 0
+		if (i == 0) == (clockwise != orientation.exteriorClockwise()) {
+			ring = ReverseRing(ring)
+		}
+		oriented = append(oriented, ring)
+	}
+	return oriented
+}
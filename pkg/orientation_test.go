@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestOrientPolygon(t *testing.T) {
+	ccw := [][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	cw := [][2]float64{{2, 2}, {2, 8}, {8, 8}, {8, 2}, {2, 2}}
+
+	var tests = []struct {
+		geom        [][][2]float64
+		orientation Orientation
+		exterior    bool
+		interior    bool
+	}{
+		// OGC keeps counter-clockwise exterior and clockwise interior
+		0: {geom: [][][2]float64{ccw, cw}, orientation: OrientationOGC, exterior: true, interior: false},
+		// RFC7946 equals OGC
+		1: {geom: [][][2]float64{ReverseRing(ccw), ReverseRing(cw)}, orientation: OrientationRFC7946, exterior: true, interior: false},
+		// MVT reverses counter-clockwise exterior and clockwise interior
+		2: {geom: [][][2]float64{ccw, cw}, orientation: OrientationMVT, exterior: false, interior: true},
+		// None keeps everything as it is
+		3: {geom: [][][2]float64{ccw, ReverseRing(cw)}, orientation: OrientationNone, exterior: true, interior: true},
+	}
+
+	for k, test := range tests {
+		oriented := orientPolygon(test.geom, test.orientation)
+		if (SignedArea(oriented[0]) > 0) != test.exterior || (SignedArea(oriented[1]) > 0) != test.interior {
+			t.Errorf("test: %d, expected counter-clockwise exterior: %t and interior: %t \ngot: %f", k, test.exterior, test.interior, oriented)
+		}
+	}
+}
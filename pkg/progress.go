@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is a Source that can count the features of its table, or estimate them from its metadata.
+// The second result is false when the number of features is unknown.
+type Counter interface {
+	Count() (int64, bool)
+}
+
+// Progress reports the number of features read of a table every Interval, with the percentage, rate
+// and ETA when the total is known. The reports are logged, or drawn as a progress bar on a terminal,
+// and written as JSON lines to a stream.
+type Progress struct {
+	Interval time.Duration
+	// Bar draws a progress bar on standard error instead of logging the progress, when it is a terminal
+	Bar bool
+	// JSON receives every report as a JSON line, when nil no stream is written
+	JSON io.Writer
+
+	table string
+	// total is the number of features of the table, -1 when unknown
+	total     int64
+	processed int64
+	started   time.Time
+	stop      chan bool
+	wg        sync.WaitGroup
+}
+
+// ProgressReport is a single report of the progress of a table, as written to the JSON stream
+type ProgressReport struct {
+	Table     string `json:"table"`
+	Processed int64  `json:"processed"`
+	// Total, Percentage and ETA are only set when the number of features is known
+	Total      *int64   `json:"total,omitempty"`
+	Percentage *float64 `json:"percentage,omitempty"`
+	// Rate is the number of features per second
+	Rate    float64  `json:"rate"`
+	Elapsed float64  `json:"elapsed_seconds"`
+	ETA     *float64 `json:"eta_seconds,omitempty"`
+	Done    bool     `json:"done"`
+	Time    string   `json:"time"`
+}
+
+// Start starts reporting the progress of a table with the given number of features, -1 when unknown
+func (p *Progress) Start(table string, total int64) {
+	p.table = table
+	p.total = total
+	atomic.StoreInt64(&p.processed, 0)
+	p.started = time.Now()
+	p.stop = make(chan bool)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report(false)
+			case <-p.stop:
+				p.report(true)
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting the progress of the table, after a final report
+func (p *Progress) Stop() {
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// add counts a processed feature
+func (p *Progress) add() {
+	atomic.AddInt64(&p.processed, 1)
+}
+
+// Report returns the current progress of the table
+func (p *Progress) Report(done bool) ProgressReport {
+	now := time.Now()
+	elapsed := now.Sub(p.started).Seconds()
+	report := ProgressReport{
+		Table:     p.table,
+		Processed: atomic.LoadInt64(&p.processed),
+		Elapsed:   elapsed,
+		Done:      done,
+		Time:      now.UTC().Format(time.RFC3339),
+	}
+	if elapsed > 0 {
+		report.Rate = float64(report.Processed) / elapsed
+	}
+	if p.total >= 0 {
+		total := p.total
+		percentage := 100.
+		if total > 0 {
+			percentage = 100 * float64(report.Processed) / float64(total)
+		}
+		report.Total = &total
+		report.Percentage = &percentage
+		if report.Rate > 0 {
+			eta := float64(total-report.Processed) / report.Rate
+			if eta < 0 {
+				eta = 0
+			}
+			report.ETA = &eta
+		}
+	}
+	return report
+}
+
+// report writes the current progress to the log or the progress bar and to the JSON stream
+func (p *Progress) report(done bool) {
+	report := p.Report(done)
+	if p.Bar && isTerminal(os.Stderr) {
+		fmt.Fprintf(os.Stderr, "\r%s", report.bar(30))
+		if done {
+			fmt.Fprintln(os.Stderr)
+		}
+	} else {
+		slog.Info(`progress`, report.attrs()...)
+	}
+	if p.JSON != nil {
+		line, err := json.Marshal(report)
+		if err != nil {
+			log.Fatalf("error encoding the progress: %s", err)
+		}
+		if _, err = p.JSON.Write(append(line, '\n')); err != nil {
+			log.Fatalf("error writing the progress: %s", err)
+		}
+	}
+}
+
+// attrs returns the fields of the report for the structured logging
+func (r ProgressReport) attrs() []any {
+	attrs := []any{`table`, r.Table, `processed`, r.Processed}
+	if r.Total != nil {
+		attrs = append(attrs, `total`, *r.Total, `percentage`, math.Round(*r.Percentage*10)/10)
+	}
+	attrs = append(attrs, `rate`, math.Round(r.Rate))
+	if r.ETA != nil && !r.Done {
+		attrs = append(attrs, `eta_seconds`, math.Round(*r.ETA))
+	}
+	if r.Done {
+		attrs = append(attrs, `elapsed_seconds`, math.Round(r.Elapsed))
+	}
+	return append(attrs, `done`, r.Done)
+}
+
+// String formats the report as a progress line
+func (r ProgressReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %d", r.Table, r.Processed)
+	if r.Total != nil {
+		fmt.Fprintf(&b, " of %d features (%.1f%%)", *r.Total, *r.Percentage)
+	} else {
+		b.WriteString(" features")
+	}
+	fmt.Fprintf(&b, ", %.0f features/s", r.Rate)
+	if r.ETA != nil && !r.Done {
+		fmt.Fprintf(&b, ", ETA %v", seconds(*r.ETA))
+	}
+	if r.Done {
+		fmt.Fprintf(&b, ", done in %v", seconds(r.Elapsed))
+	}
+	return b.String()
+}
+
+// bar formats the report as a progress bar of the given width, without a total it is a progress line
+func (r ProgressReport) bar(width int) string {
+	if r.Percentage == nil {
+		return r.String()
+	}
+	filled := int(*r.Percentage / 100 * float64(width))
+	if filled > width {
+		filled = width
+	}
+	return fmt.Sprintf("[%s%s] %s", strings.Repeat(`#`, filled), strings.Repeat(`.`, width-filled), r)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
+
+// isTerminal reports whether the file is a character device, like a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReport(t *testing.T) {
+	total := int64(200)
+	percentage := 25.
+	eta := 15.
+
+	var tests = []struct {
+		report   ProgressReport
+		expected string
+		bar      string
+	}{
+		// 0
+		{report: ProgressReport{Table: `parcels`, Processed: 50, Total: &total, Percentage: &percentage, Rate: 10, Elapsed: 5, ETA: &eta},
+			expected: `parcels 50 of 200 features (25.0%), 10 features/s, ETA 15s`,
+			bar:      `[##........] parcels 50 of 200 features (25.0%), 10 features/s, ETA 15s`},
+		// 1
+		{report: ProgressReport{Table: `parcels`, Processed: 50, Rate: 10, Elapsed: 5},
+			expected: `parcels 50 features, 10 features/s`,
+			bar:      `parcels 50 features, 10 features/s`},
+		// 2
+		{report: ProgressReport{Table: `parcels`, Processed: 50, Rate: 10, Elapsed: 5, Done: true},
+			expected: `parcels 50 features, 10 features/s, done in 5s`,
+			bar:      `parcels 50 features, 10 features/s, done in 5s`},
+	}
+
+	for k, test := range tests {
+		if got := test.report.String(); got != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.expected, got)
+		}
+		if got := test.report.bar(10); got != test.bar {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.bar, got)
+		}
+	}
+}
+
+func TestProgressJSON(t *testing.T) {
+	var tests = []struct {
+		total int64
+		// expected is the last report written, without the times and rate
+		expected ProgressReport
+	}{
+		// 0
+		{total: 4, expected: ProgressReport{Table: `parcels`, Processed: 4, Done: true}},
+		// 1
+		{total: -1, expected: ProgressReport{Table: `parcels`, Processed: 4, Done: true}},
+	}
+
+	for k, test := range tests {
+		var stream bytes.Buffer
+		progress := &Progress{Interval: time.Hour, JSON: &stream}
+		progress.Start(`parcels`, test.total)
+		for i := 0; i < 4; i++ {
+			progress.add()
+		}
+		progress.Stop()
+
+		lines := strings.Split(strings.TrimSpace(stream.String()), "\n")
+		if len(lines) != 1 {
+			t.Fatalf("test: %d, expected: 1 report \ngot: %d", k, len(lines))
+		}
+		var got ProgressReport
+		if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if test.total >= 0 {
+			if got.Total == nil || *got.Total != test.total || got.Percentage == nil || *got.Percentage != 100 || got.ETA == nil || *got.ETA != 0 {
+				t.Errorf("test: %d, expected: a total of %d at 100%% \ngot: %s", k, test.total, lines[0])
+			}
+		} else if got.Total != nil || got.Percentage != nil || got.ETA != nil {
+			t.Errorf("test: %d, expected: no total \ngot: %s", k, lines[0])
+		}
+		if got.Table != test.expected.Table || got.Processed != test.expected.Processed || got.Done != test.expected.Done {
+			t.Errorf("test: %d, expected: %+v \ngot: %+v", k, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package pkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnConfig selects a column for the target, optionally renamed (As) and cast
+// to INTEGER, REAL, TEXT or BOOLEAN (Cast)
+type ColumnConfig struct {
+	Name string `json:"name"`
+	As   string `json:"as,omitempty"`
+	Cast string `json:"cast,omitempty"`
+}
+
+// parseCast validates the given string as a type to cast to, an empty string keeps the type
+func parseCast(cast string) (string, error) {
+	switch strings.ToUpper(cast) {
+	case ``, `INTEGER`, `REAL`, `TEXT`, `BOOLEAN`:
+		return strings.ToUpper(cast), nil
+	}
+	return ``, fmt.Errorf("unknown cast: %s, expected INTEGER, REAL, TEXT or BOOLEAN", cast)
+}
+
+// Projection maps the values of the columns of a source table to the selected columns of the target table
+type Projection struct {
+	// positions are the positions in Feature.Columns() of the source of the selected columns
+	positions []int
+	casts     []string
+	// columns is the number of columns of the source, values after these are appended as-is
+	columns int
+}
+
+// NewProjection selects the columns of the table, the geometry column and the primary key
+// are always kept. The target table is returned with the selected, renamed and cast columns.
+func NewProjection(table Table, columns []ColumnConfig) (*Projection, Table, error) {
+	positions := make(map[string]int)
+	i := 0
+	for _, c := range table.Columns {
+		if c.Name != table.GeometryColumn {
+			positions[strings.ToLower(c.Name)] = i
+			i++
+		}
+	}
+
+	projection := &Projection{columns: i}
+	target := table
+	target.Columns = nil
+	selected := make(map[string]bool)
+	add := func(column Column, position int, config ColumnConfig) error {
+		if config.As != `` {
+			column.Name = config.As
+		}
+		if selected[strings.ToLower(column.Name)] {
+			return fmt.Errorf("column %s is selected twice in table %s", column.Name, table.Name)
+		}
+		selected[strings.ToLower(column.Name)] = true
+		cast, err := parseCast(config.Cast)
+		if err != nil {
+			return err
+		}
+		if cast != `` {
+			column.Type = cast
+			column.NotNull = column.PrimaryKey
+		}
+		target.Columns = append(target.Columns, column)
+		projection.positions = append(projection.positions, position)
+		projection.casts = append(projection.casts, cast)
+		return nil
+	}
+
+	// the primary key comes first when it is not selected
+	for _, c := range table.Columns {
+		if !c.PrimaryKey || c.Name == table.GeometryColumn {
+			continue
+		}
+		found := false
+		for _, config := range columns {
+			found = found || strings.EqualFold(config.Name, c.Name)
+		}
+		if !found {
+			if err := add(c, positions[strings.ToLower(c.Name)], ColumnConfig{}); err != nil {
+				return nil, table, err
+			}
+		}
+	}
+	for _, config := range columns {
+		position, ok := positions[strings.ToLower(config.Name)]
+		if !ok {
+			return nil, table, fmt.Errorf("unknown column %s in table %s", config.Name, table.Name)
+		}
+		for _, c := range table.Columns {
+			if strings.EqualFold(c.Name, config.Name) {
+				if err := add(c, position, config); err != nil {
+					return nil, table, err
+				}
+			}
+		}
+	}
+	for _, c := range table.Columns {
+		if c.Name == table.GeometryColumn {
+			target.Columns = append(target.Columns, c)
+		}
+	}
+	return projection, target, nil
+}
+
+// Project returns the values of the selected columns, cast when needed. Values added
+// after the columns of the source, like the computed columns, are kept.
+func (projection Projection) Project(values []interface{}) []interface{} {
+	projected := make([]interface{}, len(projection.positions))
+	for i, position := range projection.positions {
+		projected[i] = castValue(values[position], projection.casts[i])
+	}
+	if len(values) > projection.columns {
+		projected = append(projected, values[projection.columns:]...)
+	}
+	return projected
+}
+
+// castValue casts the value to the given type, values that can't be cast become NULL
+func castValue(value interface{}, cast string) interface{} {
+	if value == nil || cast == `` {
+		return value
+	}
+	switch cast {
+	case `INTEGER`:
+		switch v := value.(type) {
+		case int64:
+			return v
+		case float64:
+			return int64(v)
+		case bool:
+			if v {
+				return int64(1)
+			}
+			return int64(0)
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return int64(f)
+			}
+		}
+	case `REAL`:
+		switch v := value.(type) {
+		case int64:
+			return float64(v)
+		case float64:
+			return v
+		case bool:
+			if v {
+				return 1.0
+			}
+			return 0.0
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return f
+			}
+		}
+	case `TEXT`:
+		switch v := value.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10)
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(v)
+		case string:
+			return v
+		case time.Time:
+			return v.Format(time.RFC3339)
+		case []byte:
+			return string(v)
+		}
+	case `BOOLEAN`:
+		switch v := value.(type) {
+		case int64:
+			return v != 0
+		case float64:
+			return v != 0
+		case bool:
+			return v
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+type projectedFeature struct {
+	Feature
+	columns []interface{}
+}
+
+func (f projectedFeature) Columns() []interface{} {
+	return f.columns
+}
+
+// projectFeatures replaces the columns of the features by the selected columns
+func projectFeatures(in chan Feature, out chan Feature, projection *Projection, stage *StageBlocking) {
+	for {
+		feature, hasMore := stage.receive(in)
+		if !hasMore {
+			break
+		}
+		stage.send(out, &projectedFeature{feature, projection.Project(feature.Columns())})
+	}
+	close(out)
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewProjection(t *testing.T) {
+	table := Table{
+		Name: `roads`,
+		Columns: []Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `naam`, Type: `TEXT`, NotNull: true},
+			{Name: `geom`, Type: `LINESTRING`},
+			{Name: `width`, Type: `TEXT`},
+			{Name: `remark`, Type: `TEXT`},
+		},
+		GeometryColumn: `geom`,
+	}
+	values := []interface{}{int64(1), `Dorpsstraat`, `3.5`, `unused`}
+
+	var tests = []struct {
+		columns  []ColumnConfig
+		expected []Column
+		values   []interface{}
+		err      bool
+	}{
+		// 0 the primary key and geometry column are kept
+		{columns: []ColumnConfig{{Name: `naam`, As: `name`}, {Name: `width`, Cast: `real`}},
+			expected: []Column{{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true}, {Name: `name`, Type: `TEXT`, NotNull: true},
+				{Name: `width`, Type: `REAL`}, {Name: `geom`, Type: `LINESTRING`}},
+			values: []interface{}{int64(1), `Dorpsstraat`, 3.5}},
+		// 1 the selected order is kept
+		{columns: []ColumnConfig{{Name: `Width`, Cast: `INTEGER`}, {Name: `fid`, Cast: `TEXT`}},
+			expected: []Column{{Name: `width`, Type: `INTEGER`}, {Name: `fid`, Type: `TEXT`, NotNull: true, PrimaryKey: true},
+				{Name: `geom`, Type: `LINESTRING`}},
+			values: []interface{}{int64(3), `1`}},
+		// 2
+		{columns: []ColumnConfig{{Name: `unknown`}}, err: true},
+		// 3
+		{columns: []ColumnConfig{{Name: `naam`, As: `remark`}, {Name: `remark`}}, err: true},
+		// 4
+		{columns: []ColumnConfig{{Name: `naam`, Cast: `DATE`}}, err: true},
+	}
+
+	for k, test := range tests {
+		projection, target, err := NewProjection(table, test.columns)
+		if (err != nil) != test.err {
+			t.Errorf("test: %d, expected error: %v \ngot: %v", k, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(target.Columns, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, target.Columns)
+		}
+		if got := projection.Project(values); !reflect.DeepEqual(got, test.values) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.values, got)
+		}
+	}
+}
+
+func TestCastValue(t *testing.T) {
+	var tests = []struct {
+		value    interface{}
+		cast     string
+		expected interface{}
+	}{
+		// 0
+		{value: ` 12 `, cast: `INTEGER`, expected: int64(12)},
+		// 1
+		{value: `12.9`, cast: `INTEGER`, expected: int64(12)},
+		// 2
+		{value: `twelve`, cast: `INTEGER`, expected: nil},
+		// 3
+		{value: int64(2), cast: `REAL`, expected: 2.0},
+		// 4
+		{value: 2.5, cast: `TEXT`, expected: `2.5`},
+		// 5
+		{value: `true`, cast: `BOOLEAN`, expected: true},
+		// 6
+		{value: int64(0), cast: `BOOLEAN`, expected: false},
+		// 7
+		{value: nil, cast: `TEXT`, expected: nil},
+		// 8
+		{value: `kept`, cast: ``, expected: `kept`},
+	}
+
+	for k, test := range tests {
+		if got := castValue(test.value, test.cast); got != test.expected {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/go-spatial/geom/encoding/wkt"
+)
+
+// Rejects writes the features rejected by the validation as newline-delimited JSON,
+// together with the table they originate from and the reason they are rejected.
+// When vector tiles are written the zoom level is added, a feature can be rejected
+// at every zoom level.
+type Rejects struct {
+	Table   string
+	Zoom    *int
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+type reject struct {
+	Table    string        `json:"table"`
+	Zoom     *int          `json:"zoom,omitempty"`
+	Reason   string        `json:"reason"`
+	Columns  []interface{} `json:"columns"`
+	Geometry string        `json:"geometry"`
+}
+
+// Init creates the rejects file, when resuming the rejects are appended to the
+// rejects of the interrupted run
+func (rejects *Rejects) Init(file string, resume bool) {
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(file, flag, 0666)
+	if err != nil {
+		log.Fatalf("error creating rejects file: %s", err)
+	}
+	rejects.file = f
+	rejects.encoder = json.NewEncoder(f)
+}
+
+func (rejects *Rejects) Close() {
+	rejects.file.Close()
+}
+
+// Reject writes the feature with the reason to the rejects file
+func (rejects *Rejects) Reject(feature Feature, reason error) {
+	geometry, err := wkt.EncodeString(feature.Geometry())
+	if err != nil {
+		geometry = ``
+	}
+
+	rejects.mutex.Lock()
+	defer rejects.mutex.Unlock()
+	err = rejects.encoder.Encode(reject{
+		Table:    rejects.Table,
+		Zoom:     rejects.Zoom,
+		Reason:   reason.Error(),
+		Columns:  feature.Columns(),
+		Geometry: geometry,
+	})
+	if err != nil {
+		log.Fatalf("error writing to the rejects file: %s", err)
+	}
+}
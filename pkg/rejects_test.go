@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-spatial/geom"
+)
+
+func TestRejectsResume(t *testing.T) {
+	var tests = []struct {
+		resume   bool
+		expected int
+	}{
+		// 0 a new run truncates the rejects of the previous run
+		{resume: false, expected: 1},
+		// 1 a resumed run appends to them
+		{resume: true, expected: 2},
+	}
+
+	for k, test := range tests {
+		file := filepath.Join(t.TempDir(), `rejects.jsonl`)
+		for _, resume := range []bool{false, test.resume} {
+			rejects := &Rejects{Table: `parcels`}
+			rejects.Init(file, resume)
+			rejects.Reject(&testFeature{columns: []interface{}{int64(k)}, geometry: geom.Point{0, 0}}, errors.New(`invalid`))
+			rejects.Close()
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(string(content), "\n"); got != test.expected {
+			t.Errorf("test: %d, expected: %d \ngot: %d", k, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RenameTemplate maps the name of a source table to the name of the table in the target,
+// {table} is replaced by the name of the source table and {resolution} by the resolution,
+// like {table}_sieved_{resolution}. An empty template keeps the name.
+type RenameTemplate string
+
+var placeholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// ParseRenameTemplate validates the placeholders of the given template
+func ParseRenameTemplate(template string) (RenameTemplate, error) {
+	for _, p := range placeholder.FindAllString(template, -1) {
+		if p != `{table}` && p != `{resolution}` {
+			return ``, fmt.Errorf("unknown placeholder %s in rename template: %s, expected {table} or {resolution}", p, template)
+		}
+	}
+	return RenameTemplate(template), nil
+}
+
+// Name returns the name of the table in the target. The decimal point of the resolution
+// is replaced by an underscore, because the name is also used in the names of the RTree triggers.
+func (template RenameTemplate) Name(table string, resolution float64) string {
+	if template == `` {
+		return table
+	}
+	r := strings.ReplaceAll(strconv.FormatFloat(resolution, 'f', -1, 64), `.`, `_`)
+	return strings.NewReplacer(`{table}`, table, `{resolution}`, r).Replace(string(template))
+}
+
+// Rename returns the tables as they are named in the target, the names must be unique
+func (template RenameTemplate) Rename(tables []Table, resolution func(table string) float64) ([]Table, error) {
+	renamed := make([]Table, len(tables))
+	names := make(map[string]string)
+	for i, table := range tables {
+		renamed[i] = table
+		renamed[i].Name = template.Name(table.Name, resolution(table.Name))
+		if other, ok := names[strings.ToLower(renamed[i].Name)]; ok {
+			return nil, fmt.Errorf("tables %s and %s are both renamed to %s", other, table.Name, renamed[i].Name)
+		}
+		names[strings.ToLower(renamed[i].Name)] = table.Name
+	}
+	return renamed, nil
+}
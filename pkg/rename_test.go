@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRenameTemplate(t *testing.T) {
+	var tests = []struct {
+		template   string
+		resolution float64
+		expected   string
+		err        bool
+	}{
+		// 0
+		{template: ``, resolution: 50, expected: `parcels`},
+		// 1
+		{template: `{table}_sieved_{resolution}`, resolution: 50, expected: `parcels_sieved_50`},
+		// 2
+		{template: `sieved_{table}_{resolution}`, resolution: 0.25, expected: `sieved_parcels_0_25`},
+		// 3
+		{template: `{table}_{zoom}`, err: true},
+	}
+
+	for k, test := range tests {
+		template, err := ParseRenameTemplate(test.template)
+		if (err != nil) != test.err {
+			t.Errorf("test: %d, expected error: %v \ngot: %v", k, test.err, err)
+			continue
+		}
+		if got := template.Name(`parcels`, test.resolution); err == nil && got != test.expected {
+			t.Errorf("test: %d, expected: %s \ngot: %s", k, test.expected, got)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	tables := []Table{{Name: `parcels`}, {Name: `buildings`}}
+	resolution := func(table string) float64 { return 10 }
+
+	renamed, err := RenameTemplate(`{table}_{resolution}`).Rename(tables, resolution)
+	expected := []Table{{Name: `parcels_10`}, {Name: `buildings_10`}}
+	if err != nil || !reflect.DeepEqual(renamed, expected) {
+		t.Errorf("expected: %v \ngot: %v %v", expected, renamed, err)
+	}
+	if tables[0].Name != `parcels` {
+		t.Errorf("expected the source tables to keep their names")
+	}
+
+	if _, err = RenameTemplate(`sieved_{resolution}`).Rename(tables, resolution); err == nil {
+		t.Errorf("expected an error for tables renamed to the same name")
+	}
+}
@@ -0,0 +1,380 @@
+package pkg
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-spatial/geom"
+)
+
+// repairPolygons makes the polygons valid. Every polygon is repaired with the even-odd rule: the
+// area covered an odd number of times by its rings is kept, so self-intersecting rings are split
+// and overlapping holes cancel out. The repaired polygons are unioned afterwards.
+func repairPolygons(polygons []geom.Polygon) geom.MultiPolygon {
+	if len(polygons) == 1 {
+		return overlay(polygons[0], evenOdd)
+	}
+	var rings [][][2]float64
+	for _, p := range polygons {
+		for _, repaired := range overlay(p, evenOdd) {
+			rings = append(rings, repaired...)
+		}
+	}
+	return overlay(rings, positive)
+}
+
+// evenOdd determines the area inside by the even-odd rule
+func evenOdd(winding int) bool {
+	return winding%2 != 0
+}
+
+// positive determines the area inside the counter-clockwise exteriors of valid polygons, unioning
+// the polygons when they overlap
+func positive(winding int) bool {
+	return winding > 0
+}
+
+// halfEdge is a directed edge of the planar graph of the noded rings, the weight is the number
+// of times the rings pass it in its direction minus the number of times in the opposite direction
+type halfEdge struct {
+	from, to [2]float64
+	weight   int
+	angle    float64
+}
+
+// overlay computes the valid polygons covering the area of the rings for which the winding number
+// is inside. The rings are noded into a planar graph, the winding number of the faces of that graph
+// is derived from the weights of the edges and the boundary between the faces inside and outside is
+// traced into rings again. The exteriors of the polygons are counter-clockwise, the interiors clockwise.
+func overlay(rings [][][2]float64, inside func(winding int) bool) geom.MultiPolygon {
+	edges := graphEdges(rings)
+
+	// every edge is stored as two half-edges, h and its twin h^1, sorted by angle around a vertex
+	var halfEdges []halfEdge
+	for _, e := range edges {
+		halfEdges = append(halfEdges,
+			halfEdge{e.from, e.to, e.weight, math.Atan2(e.to[1]-e.from[1], e.to[0]-e.from[0])},
+			halfEdge{e.to, e.from, -e.weight, math.Atan2(e.from[1]-e.to[1], e.from[0]-e.to[0])})
+	}
+	if len(halfEdges) == 0 {
+		return nil
+	}
+	outgoing := make(map[[2]float64][]int)
+	for h, he := range halfEdges {
+		outgoing[he.from] = append(outgoing[he.from], h)
+	}
+	position := make([]int, len(halfEdges))
+	for _, out := range outgoing {
+		sort.Slice(out, func(i, j int) bool { return halfEdges[out[i]].angle < halfEdges[out[j]].angle })
+		for i, h := range out {
+			position[h] = i
+		}
+	}
+	// clockwise returns the k-th half-edge clockwise from the twin of h around the end of h
+	clockwise := func(h int, k int) int {
+		out := outgoing[halfEdges[h].to]
+		return out[((position[h^1]-k)%len(out)+len(out))%len(out)]
+	}
+
+	// the faces lie left of their half-edges: bounded faces are counter-clockwise, the
+	// unbounded face around a connected part of the graph is clockwise
+	face := make([]int, len(halfEdges))
+	for h := range face {
+		face[h] = -1
+	}
+	var faceArea []float64
+	for h := range halfEdges {
+		if face[h] != -1 {
+			continue
+		}
+		f := len(faceArea)
+		a := 0.
+		for e := h; face[e] == -1; e = clockwise(e, 1) {
+			face[e] = f
+			a += halfEdges[e].from[0]*halfEdges[e].to[1] - halfEdges[e].to[0]*halfEdges[e].from[1]
+		}
+		faceArea = append(faceArea, a/2)
+	}
+	faceEdges := make([][]int, len(faceArea))
+	for h := range halfEdges {
+		faceEdges[face[h]] = append(faceEdges[face[h]], h)
+	}
+
+	// the winding number of the unbounded face of every connected part is determined by the other
+	// parts, from there it changes by the weight of every crossed edge
+	winding := make([]int, len(faceArea))
+	component := make([]int, len(faceArea))
+	for f := range component {
+		component[f] = -1
+	}
+	for start := range faceArea {
+		if component[start] != -1 {
+			continue
+		}
+		c := start
+		faces := []int{start}
+		component[start] = c
+		for i := 0; i < len(faces); i++ {
+			for _, h := range faceEdges[faces[i]] {
+				if twin := face[h^1]; component[twin] == -1 {
+					component[twin] = c
+					faces = append(faces, twin)
+				}
+			}
+		}
+		outer := faces[0]
+		for _, f := range faces {
+			if faceArea[f] < faceArea[outer] {
+				outer = f
+			}
+		}
+		pt := halfEdges[faceEdges[outer][0]].from
+		wn := 0
+		for h := 0; h < len(halfEdges); h += 2 {
+			if component[face[h]] != c {
+				wn += crossing(halfEdges[h], pt)
+			}
+		}
+		winding[outer] = wn
+		done := map[int]bool{outer: true}
+		queue := []int{outer}
+		for i := 0; i < len(queue); i++ {
+			for _, h := range faceEdges[queue[i]] {
+				if twin := face[h^1]; !done[twin] {
+					done[twin] = true
+					winding[twin] = winding[queue[i]] - halfEdges[h].weight
+					queue = append(queue, twin)
+				}
+			}
+		}
+	}
+
+	// the boundary half-edges have the inside on their left, traced by turning as far left as possible
+	boundary := make([]bool, len(halfEdges))
+	for h := range halfEdges {
+		boundary[h] = inside(winding[face[h]]) && !inside(winding[face[h^1]])
+	}
+	var shells, holes [][][2]float64
+	visited := make([]bool, len(halfEdges))
+	for h := range halfEdges {
+		if !boundary[h] || visited[h] {
+			continue
+		}
+		var ring [][2]float64
+		for e := h; !visited[e]; {
+			visited[e] = true
+			ring = append(ring, halfEdges[e].from)
+			for k := 1; ; k++ {
+				if next := clockwise(e, k); boundary[next] {
+					e = next
+					break
+				}
+			}
+		}
+		for _, loop := range splitLoops(ring) {
+			switch a := SignedArea(loop); {
+			case a > 0:
+				shells = append(shells, loop)
+			case a < 0:
+				holes = append(holes, loop)
+			}
+		}
+	}
+	return assemble(shells, holes)
+}
+
+// edge is an edge of the planar graph with its weight in the direction from - to
+type edge struct {
+	from, to [2]float64
+	weight   int
+}
+
+// graphEdges nodes the segments of the rings at their intersections, the edges passed in both
+// directions equally often are left out
+func graphEdges(rings [][][2]float64) []edge {
+	var segments [][2][2]float64
+	for _, ring := range rings {
+		ring = closeRing(ring)
+		for i := 1; i < len(ring); i++ {
+			segments = append(segments, [2][2]float64{ring[i-1], ring[i]})
+		}
+	}
+
+	// the segments are swept by their smallest x, to compare only those with overlapping envelopes
+	order := make([]int, len(segments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return math.Min(segments[order[i]][0][0], segments[order[i]][1][0]) < math.Min(segments[order[j]][0][0], segments[order[j]][1][0])
+	})
+	splits := make([][][2]float64, len(segments))
+	for i, si := range order {
+		s := segments[si]
+		maxX := math.Max(s[0][0], s[1][0])
+		for _, ti := range order[i+1:] {
+			t := segments[ti]
+			if math.Min(t[0][0], t[1][0]) > maxX {
+				break
+			}
+			if math.Max(s[0][1], s[1][1]) < math.Min(t[0][1], t[1][1]) || math.Max(t[0][1], t[1][1]) < math.Min(s[0][1], s[1][1]) {
+				continue
+			}
+			for _, pt := range segmentIntersections(s, t) {
+				if pt != s[0] && pt != s[1] {
+					splits[si] = append(splits[si], pt)
+				}
+				if pt != t[0] && pt != t[1] {
+					splits[ti] = append(splits[ti], pt)
+				}
+			}
+		}
+	}
+
+	weights := make(map[[2][2]float64]int)
+	var keys [][2][2]float64
+	for i, s := range segments {
+		pts := append([][2]float64{s[0]}, splits[i]...)
+		sort.Slice(pts[1:], func(a, b int) bool {
+			return distance(s[0], pts[1+a]) < distance(s[0], pts[1+b])
+		})
+		pts = append(pts, s[1])
+		for j := 1; j < len(pts); j++ {
+			from, to := pts[j-1], pts[j]
+			if from == to {
+				continue
+			}
+			key, weight := [2][2]float64{from, to}, 1
+			if to[0] < from[0] || (to[0] == from[0] && to[1] < from[1]) {
+				key, weight = [2][2]float64{to, from}, -1
+			}
+			if _, ok := weights[key]; !ok {
+				keys = append(keys, key)
+			}
+			weights[key] += weight
+		}
+	}
+	var edges []edge
+	for _, key := range keys {
+		if weights[key] != 0 {
+			edges = append(edges, edge{key[0], key[1], weights[key]})
+		}
+	}
+	return edges
+}
+
+// segmentIntersections returns the intersection of two segments, or the end points of each lying
+// on the other for collinear segments
+func segmentIntersections(s, t [2][2]float64) [][2]float64 {
+	d1 := [2]float64{s[1][0] - s[0][0], s[1][1] - s[0][1]}
+	d2 := [2]float64{t[1][0] - t[0][0], t[1][1] - t[0][1]}
+	w := [2]float64{t[0][0] - s[0][0], t[0][1] - s[0][1]}
+	denominator := cross(d1, d2)
+	if denominator == 0 {
+		if cross(w, d1) != 0 {
+			return nil
+		}
+		var pts [][2]float64
+		for _, pt := range []([2]float64){t[0], t[1]} {
+			if inEnvelope(s, pt) {
+				pts = append(pts, pt)
+			}
+		}
+		for _, pt := range []([2]float64){s[0], s[1]} {
+			if inEnvelope(t, pt) {
+				pts = append(pts, pt)
+			}
+		}
+		return pts
+	}
+	ts := cross(w, d2) / denominator
+	us := cross(w, d1) / denominator
+	switch {
+	case ts < 0 || ts > 1 || us < 0 || us > 1:
+		return nil
+	case ts == 0:
+		return [][2]float64{s[0]}
+	case ts == 1:
+		return [][2]float64{s[1]}
+	case us == 0:
+		return [][2]float64{t[0]}
+	case us == 1:
+		return [][2]float64{t[1]}
+	}
+	return [][2]float64{{s[0][0] + ts*d1[0], s[0][1] + ts*d1[1]}}
+}
+
+// crossing returns the change of the winding number around the point by the half-edge, following
+// the crossing rules of a ray to the right of the point
+func crossing(he halfEdge, pt [2]float64) int {
+	side := cross([2]float64{he.to[0] - he.from[0], he.to[1] - he.from[1]}, [2]float64{pt[0] - he.from[0], pt[1] - he.from[1]})
+	if he.from[1] <= pt[1] {
+		if he.to[1] > pt[1] && side > 0 {
+			return he.weight
+		}
+	} else if he.to[1] <= pt[1] && side < 0 {
+		return -he.weight
+	}
+	return 0
+}
+
+// splitLoops splits a ring passing a point more than once into closed rings that don't
+func splitLoops(ring [][2]float64) [][][2]float64 {
+	var loops [][][2]float64
+	var path [][2]float64
+	seen := make(map[[2]float64]int)
+	for _, pt := range append(ring, ring[0]) {
+		if i, ok := seen[pt]; ok {
+			loop := append(append([][2]float64{}, path[i:]...), pt)
+			for _, p := range path[i+1:] {
+				delete(seen, p)
+			}
+			path = path[:i+1]
+			if len(loop) >= 4 {
+				loops = append(loops, loop)
+			}
+			continue
+		}
+		seen[pt] = len(path)
+		path = append(path, pt)
+	}
+	return loops
+}
+
+// assemble adds every hole to the smallest shell containing it, the polygons are returned
+// from large to small
+func assemble(shells [][][2]float64, holes [][][2]float64) geom.MultiPolygon {
+	sort.SliceStable(shells, func(i, j int) bool { return SignedArea(shells[i]) < SignedArea(shells[j]) })
+	polygons := make(geom.MultiPolygon, len(shells))
+	for i, shell := range shells {
+		polygons[i] = geom.Polygon{shell}
+	}
+	for _, hole := range holes {
+		for i, shell := range shells {
+			if ringContains(shell, hole) {
+				polygons[i] = append(polygons[i], hole)
+				break
+			}
+		}
+	}
+	for i, j := 0, len(polygons)-1; i < j; i, j = i+1, j-1 {
+		polygons[i], polygons[j] = polygons[j], polygons[i]
+	}
+	return polygons
+}
+
+// cross returns the cross product of two vectors
+func cross(a, b [2]float64) float64 {
+	return a[0]*b[1] - a[1]*b[0]
+}
+
+// inEnvelope determines if the point lies within the envelope of the segment
+func inEnvelope(s [2][2]float64, pt [2]float64) bool {
+	return pt[0] >= math.Min(s[0][0], s[1][0]) && pt[0] <= math.Max(s[0][0], s[1][0]) &&
+		pt[1] >= math.Min(s[0][1], s[1][1]) && pt[1] <= math.Max(s[0][1], s[1][1])
+}
+
+// distance returns the squared distance between two points, sufficient for ordering
+func distance(a, b [2]float64) float64 {
+	return (a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1])
+}
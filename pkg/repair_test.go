@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/go-spatial/geom"
+)
+
+func TestRepairPolygons(t *testing.T) {
+	square := func(min, max float64) [][2]float64 {
+		return [][2]float64{{min, min}, {max, min}, {max, max}, {min, max}, {min, min}}
+	}
+
+	var tests = []struct {
+		polygons []geom.Polygon
+		parts    int
+		holes    int
+		area     float64
+	}{
+		// 0 valid polygon with a hole
+		0: {polygons: []geom.Polygon{{square(0, 10), square(2, 8)}}, parts: 1, holes: 1, area: 64},
+		// 1 bow tie
+		1: {polygons: []geom.Polygon{{{{0, 0}, {10, 10}, {10, 0}, {0, 10}, {0, 0}}}}, parts: 2, area: 50},
+		// 2 figure-eight
+		2: {polygons: []geom.Polygon{{{{0, 0}, {0, 2}, {1, 1}, {2, 2}, {2, 0}, {1, 1}, {0, 0}}}}, parts: 2, area: 2},
+		// 3 hole touching the exterior at two vertices splits the polygon
+		3: {polygons: []geom.Polygon{{square(0, 10), {{0, 0}, {5, 4}, {10, 10}, {4, 5}, {0, 0}}}}, parts: 2, area: 90},
+		// 4 two holes sharing an edge become one hole
+		4: {polygons: []geom.Polygon{{square(0, 10), {{2, 2}, {2, 8}, {5, 8}, {5, 2}, {2, 2}}, {{5, 2}, {5, 8}, {8, 8}, {8, 2}, {5, 2}}}},
+			parts: 1, holes: 1, area: 64},
+		// 5 hole crossing the exterior, the even-odd rule keeps the part of the hole outside
+		5: {polygons: []geom.Polygon{{square(0, 10), square(5, 15)}}, parts: 2, area: 150},
+		// 6 overlapping parts are unioned
+		6: {polygons: []geom.Polygon{{square(0, 10)}, {square(5, 15)}}, parts: 1, area: 175},
+		// 7 island in the hole of another part
+		7: {polygons: []geom.Polygon{{square(0, 10), square(2, 8)}, {square(4, 6)}}, parts: 2, holes: 1, area: 68},
+		// 8 degenerate ring without area
+		8: {polygons: []geom.Polygon{{{{0, 0}, {10, 0}, {5, 0}, {0, 0}}}}, parts: 0},
+	}
+
+	for k, test := range tests {
+		repaired := repairPolygons(test.polygons)
+		holes := 0
+		total := 0.
+		for _, p := range repaired {
+			holes += len(p) - 1
+			total += area(p)
+			if err := polygonValid(normalizePolygon(p)); err != nil {
+				t.Errorf("test: %d, expected a valid polygon \ngot: %s %v", k, err, p)
+			}
+		}
+		if len(repaired) != test.parts || holes != test.holes || total != test.area {
+			t.Errorf("test: %d, expected: %d parts, %d holes with area %f \ngot: %v", k, test.parts, test.holes, test.area, repaired)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package shp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxFieldName is the maximum length in bytes of a dBASE field name
+const maxFieldName = 10
+
+// maxCharacterLength is the maximum length of a character field
+const maxCharacterLength = 254
+
+// field is a dBASE field descriptor
+type field struct {
+	name     string
+	ftype    byte
+	length   int
+	decimals int
+}
+
+// columnType returns the GeoPackage column type of the field
+func (f field) columnType() string {
+	switch f.ftype {
+	case 'N':
+		if f.decimals == 0 && f.length < 19 {
+			return "INTEGER"
+		}
+		return "REAL"
+	case 'F':
+		return "REAL"
+	case 'L':
+		return "BOOLEAN"
+	case 'D':
+		return "DATE"
+	default:
+		return "TEXT"
+	}
+}
+
+// fieldForColumn returns the field of a GeoPackage column type, ok is false when the
+// column type can't be stored in a dBASE file
+func fieldForColumn(name string, columntype string) (f field, ok bool) {
+	columntype = strings.ToUpper(columntype)
+	switch {
+	case columntype == "BOOLEAN":
+		return field{name: name, ftype: 'L', length: 1}, true
+	case strings.Contains(columntype, "INT"):
+		return field{name: name, ftype: 'N', length: 18}, true
+	case columntype == "REAL" || columntype == "DOUBLE" || columntype == "FLOAT":
+		return field{name: name, ftype: 'N', length: 24, decimals: 15}, true
+	case columntype == "DATE":
+		return field{name: name, ftype: 'D', length: 8}, true
+	case strings.HasPrefix(columntype, "BLOB"):
+		return field{}, false
+	default:
+		return field{name: name, ftype: 'C', length: maxCharacterLength}, true
+	}
+}
+
+// fieldNames truncates the names to the maximum length of dBASE field names, keeping them
+// unique by replacing the last characters with a number. The names that fit are reserved first,
+// so a truncated name never takes the name of a later field. The truncated names are returned.
+func fieldNames(fields []field) map[string]string {
+	truncated := make(map[string]string)
+	used := make(map[string]bool)
+	for _, f := range fields {
+		if len(f.name) <= maxFieldName {
+			used[strings.ToUpper(f.name)] = true
+		}
+	}
+	for i := range fields {
+		name := fields[i].name
+		if len(name) <= maxFieldName {
+			continue
+		}
+		short := truncate(name, maxFieldName)
+		for n := 1; used[strings.ToUpper(short)]; n++ {
+			suffix := strconv.Itoa(n)
+			short = truncate(name, maxFieldName-len(suffix)) + suffix
+		}
+		fields[i].name = short
+		truncated[name] = short
+		used[strings.ToUpper(short)] = true
+	}
+	return truncated
+}
+
+// truncate truncates s to at most n bytes without splitting a multibyte character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+type dbfReader struct {
+	r          *bufio.Reader
+	fields     []field
+	count      int
+	recordSize int
+	// latin1 is set when the .cpg declares a single byte encoding
+	latin1 bool
+}
+
+func newDbfReader(r io.Reader) (*dbfReader, error) {
+	d := dbfReader{r: bufio.NewReader(r)}
+	header := make([]byte, 32)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return nil, err
+	}
+	d.count = int(binary.LittleEndian.Uint32(header[4:]))
+	headerSize := int(binary.LittleEndian.Uint16(header[8:]))
+	d.recordSize = int(binary.LittleEndian.Uint16(header[10:]))
+
+	descriptors := make([]byte, headerSize-32)
+	if _, err := io.ReadFull(d.r, descriptors); err != nil {
+		return nil, err
+	}
+	for i := 0; i+32 <= len(descriptors) && descriptors[i] != 0x0D; i = i + 32 {
+		b := descriptors[i : i+32]
+		d.fields = append(d.fields, field{
+			name:     string(bytes.TrimRight(b[:11], "\x00 ")),
+			ftype:    b[11],
+			length:   int(b[16]),
+			decimals: int(b[17]),
+		})
+	}
+	return &d, nil
+}
+
+// read reads the next record, io.EOF is returned after the last record
+func (d *dbfReader) read() ([]interface{}, bool, error) {
+	if d.count == 0 {
+		return nil, false, io.EOF
+	}
+	d.count--
+	record := make([]byte, d.recordSize)
+	if _, err := io.ReadFull(d.r, record); err != nil {
+		return nil, false, err
+	}
+	deleted := record[0] == '*'
+
+	values := make([]interface{}, len(d.fields))
+	pos := 1
+	for i, f := range d.fields {
+		if pos+f.length > len(record) {
+			return nil, false, errors.New("invalid record")
+		}
+		values[i] = d.decodeValue(f, record[pos:pos+f.length])
+		pos = pos + f.length
+	}
+	return values, deleted, nil
+}
+
+func (d *dbfReader) decodeValue(f field, b []byte) interface{} {
+	s := strings.TrimSpace(string(b))
+	switch f.columnType() {
+	case "INTEGER":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		return nil
+	case "REAL":
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v
+		}
+		return nil
+	case "BOOLEAN":
+		switch s {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		}
+		return nil
+	case "DATE":
+		if t, err := time.Parse("20060102", s); err == nil {
+			return t.Format("2006-01-02")
+		}
+		return nil
+	}
+	if d.latin1 {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	} else {
+		s = string(b)
+	}
+	return strings.TrimRight(s, " \x00")
+}
+
+type dbfWriter struct {
+	w          io.WriteSeeker
+	buf        *bufio.Writer
+	fields     []field
+	count      int
+	recordSize int
+	// truncated counts the values of the character fields that are truncated by field name
+	truncated map[string]int
+}
+
+func newDbfWriter(w io.WriteSeeker, fields []field) (*dbfWriter, error) {
+	d := dbfWriter{w: w, buf: bufio.NewWriter(w), fields: fields, recordSize: 1, truncated: make(map[string]int)}
+	for _, f := range fields {
+		d.recordSize = d.recordSize + f.length
+	}
+	if err := d.writeHeader(); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		b := make([]byte, 32)
+		copy(b[:11], f.name)
+		b[11] = f.ftype
+		b[16] = byte(f.length)
+		b[17] = byte(f.decimals)
+		d.buf.Write(b)
+	}
+	return &d, d.buf.WriteByte(0x0D)
+}
+
+// size returns the size of the file in bytes
+func (d *dbfWriter) size() int64 {
+	return int64(32+32*len(d.fields)+1) + int64(d.count)*int64(d.recordSize) + 1
+}
+
+func (d *dbfWriter) writeHeader() error {
+	now := time.Now()
+	header := make([]byte, 32)
+	header[0] = 0x03
+	header[1] = byte(now.Year() - 1900)
+	header[2] = byte(now.Month())
+	header[3] = byte(now.Day())
+	binary.LittleEndian.PutUint32(header[4:], uint32(d.count))
+	binary.LittleEndian.PutUint16(header[8:], uint16(32+32*len(d.fields)+1))
+	binary.LittleEndian.PutUint16(header[10:], uint16(d.recordSize))
+	_, err := d.buf.Write(header)
+	return err
+}
+
+func (d *dbfWriter) write(values []interface{}) error {
+	record := bytes.Repeat([]byte{' '}, d.recordSize)
+	pos := 1
+	for i, f := range d.fields {
+		if i < len(values) && values[i] != nil {
+			s, err := encodeValue(f, values[i])
+			if err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+			if f.ftype == 'C' && len(characterValue(values[i])) > f.length {
+				d.truncated[f.name]++
+			}
+			copy(record[pos:pos+f.length], s)
+		}
+		pos = pos + f.length
+	}
+	d.count++
+	_, err := d.buf.Write(record)
+	return err
+}
+
+// close writes the end of file marker and the final record count
+func (d *dbfWriter) close() error {
+	if err := d.buf.WriteByte(0x1A); err != nil {
+		return err
+	}
+	if err := d.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := d.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := d.writeHeader(); err != nil {
+		return err
+	}
+	return d.buf.Flush()
+}
+
+// encodeValue formats the value for the field, numbers are right aligned
+func encodeValue(f field, value interface{}) (string, error) {
+	switch f.ftype {
+	case 'L':
+		switch v := value.(type) {
+		case bool:
+			if v {
+				return "T", nil
+			}
+			return "F", nil
+		case int64:
+			if v != 0 {
+				return "T", nil
+			}
+			return "F", nil
+		}
+		return "?", nil
+	case 'D':
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format("20060102"), nil
+		case string:
+			t, err := time.Parse("2006-01-02", truncate(v, 10))
+			if err != nil {
+				return "", err
+			}
+			return t.Format("20060102"), nil
+		}
+		return "", fmt.Errorf("unexpected date %v", value)
+	case 'N':
+		var s string
+		switch v := value.(type) {
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		case float64:
+			s = formatFloat(v, f)
+		case bool:
+			s = "0"
+			if v {
+				s = "1"
+			}
+		case string:
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return "", err
+			}
+			s = v
+		default:
+			return "", fmt.Errorf("unexpected number %v", value)
+		}
+		if len(s) > f.length {
+			return "", fmt.Errorf("number %s too long", s)
+		}
+		return strings.Repeat(" ", f.length-len(s)) + s, nil
+	}
+
+	return truncate(characterValue(value), f.length), nil
+}
+
+// characterValue returns the value of a character field before it is truncated
+func characterValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatFloat formats the float with the decimals of the field, using less
+// decimals when the number doesn't fit
+func formatFloat(v float64, f field) string {
+	if f.decimals == 0 {
+		return strconv.FormatFloat(math.Round(v), 'f', 0, 64)
+	}
+	for decimals := f.decimals; decimals >= 0; decimals-- {
+		s := strconv.FormatFloat(v, 'f', decimals, 64)
+		if len(s) <= f.length {
+			return s
+		}
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,337 @@
+package shp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+// Shape types, the Z and M variants are read as their 2D equivalent
+const (
+	shapeNull        = 0
+	shapePoint       = 1
+	shapePolyLine    = 3
+	shapePolygon     = 5
+	shapeMultiPoint  = 8
+	shapePointZ      = 11
+	shapePolyLineZ   = 13
+	shapePolygonZ    = 15
+	shapeMultiPointZ = 18
+	shapePointM      = 21
+	shapePolyLineM   = 23
+	shapePolygonM    = 25
+	shapeMultiPointM = 28
+)
+
+// shapeType2D returns the 2D shape type of a Z or M shape type
+func shapeType2D(shapeType int32) int32 {
+	switch shapeType {
+	case shapePointZ, shapePointM:
+		return shapePoint
+	case shapePolyLineZ, shapePolyLineM:
+		return shapePolyLine
+	case shapePolygonZ, shapePolygonM:
+		return shapePolygon
+	case shapeMultiPointZ, shapeMultiPointM:
+		return shapeMultiPoint
+	}
+	return shapeType
+}
+
+// geometryTypeForShapeType returns the GeoPackage geometry type name of a shape type,
+// because every Polygon or PolyLine shape can have multiple parts the multi type is used
+func geometryTypeForShapeType(shapeType int32) string {
+	switch shapeType2D(shapeType) {
+	case shapePoint:
+		return "POINT"
+	case shapePolyLine:
+		return "MULTILINESTRING"
+	case shapePolygon:
+		return "MULTIPOLYGON"
+	case shapeMultiPoint:
+		return "MULTIPOINT"
+	default:
+		return "GEOMETRY"
+	}
+}
+
+// shapeTypeForGeometryType returns the shape type of a GeoPackage geometry type name,
+// shapeNull when the shape type can't be determined from the name
+func shapeTypeForGeometryType(geometrytype string) int32 {
+	switch geometrytype {
+	case "POINT":
+		return shapePoint
+	case "LINESTRING", "MULTILINESTRING":
+		return shapePolyLine
+	case "POLYGON", "MULTIPOLYGON":
+		return shapePolygon
+	case "MULTIPOINT":
+		return shapeMultiPoint
+	default:
+		return shapeNull
+	}
+}
+
+// shapeTypeForGeometry returns the shape type of a geometry, a GEOMETRYCOLLECTION
+// has the shape type of its members when they have the same
+func shapeTypeForGeometry(geometry geom.Geometry) int32 {
+	switch g := geometry.(type) {
+	case geom.Point:
+		return shapePoint
+	case geom.LineString, geom.MultiLineString:
+		return shapePolyLine
+	case geom.Polygon, geom.MultiPolygon:
+		return shapePolygon
+	case geom.MultiPoint:
+		return shapeMultiPoint
+	case geom.Collection:
+		shapeType := int32(shapeNull)
+		for _, member := range g {
+			t := shapeTypeForGeometry(member)
+			if t == shapePoint {
+				t = shapeMultiPoint
+			}
+			if shapeType != shapeNull && t != shapeType {
+				return shapeNull
+			}
+			shapeType = t
+		}
+		return shapeType
+	}
+	return shapeNull
+}
+
+// decodeShape decodes the content of a shape record, only the X and Y coordinates are read
+func decodeShape(b []byte) (geom.Geometry, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("invalid shape record")
+	}
+	shapeType := shapeType2D(int32(binary.LittleEndian.Uint32(b)))
+	b = b[4:]
+
+	switch shapeType {
+	case shapeNull:
+		return nil, nil
+	case shapePoint:
+		if len(b) < 16 {
+			return nil, fmt.Errorf("invalid point")
+		}
+		return geom.Point{float64le(b), float64le(b[8:])}, nil
+	case shapeMultiPoint:
+		if len(b) < 36 {
+			return nil, fmt.Errorf("invalid multipoint")
+		}
+		n := int(binary.LittleEndian.Uint32(b[32:]))
+		pts, err := decodePoints(b[36:], n)
+		if err != nil {
+			return nil, err
+		}
+		return geom.MultiPoint(pts), nil
+	case shapePolyLine, shapePolygon:
+		if len(b) < 40 {
+			return nil, fmt.Errorf("invalid polyline or polygon")
+		}
+		numParts := int(binary.LittleEndian.Uint32(b[32:]))
+		numPoints := int(binary.LittleEndian.Uint32(b[36:]))
+		b = b[40:]
+		if len(b) < 4*numParts {
+			return nil, fmt.Errorf("invalid parts")
+		}
+		parts := make([]int, numParts+1)
+		for i := 0; i < numParts; i++ {
+			parts[i] = int(binary.LittleEndian.Uint32(b[4*i:]))
+		}
+		parts[numParts] = numPoints
+		pts, err := decodePoints(b[4*numParts:], numPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		var lines [][][2]float64
+		for i := 0; i < numParts; i++ {
+			if parts[i] > parts[i+1] || parts[i+1] > numPoints {
+				return nil, fmt.Errorf("invalid parts")
+			}
+			lines = append(lines, pts[parts[i]:parts[i+1]])
+		}
+		if shapeType == shapePolyLine {
+			return geom.MultiLineString(lines), nil
+		}
+		return polygons(lines), nil
+	}
+	return nil, fmt.Errorf("unsupported shape type: %d", shapeType)
+}
+
+func decodePoints(b []byte, n int) ([][2]float64, error) {
+	if n < 0 || len(b) < 16*n {
+		return nil, fmt.Errorf("invalid points")
+	}
+	pts := make([][2]float64, n)
+	for i := range pts {
+		pts[i] = [2]float64{float64le(b[16*i:]), float64le(b[16*i+8:])}
+	}
+	return pts, nil
+}
+
+func float64le(b []byte) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+// polygons groups the rings into polygons, every clockwise ring is the exterior of a new
+// polygon, the counter-clockwise rings are the interiors of the exterior containing them
+func polygons(rings [][][2]float64) geom.MultiPolygon {
+	var mp geom.MultiPolygon
+	var interiors [][][2]float64
+	for _, ring := range rings {
+		if pkg.SignedArea(ring) <= 0 {
+			mp = append(mp, [][][2]float64{ring})
+		} else {
+			interiors = append(interiors, ring)
+		}
+	}
+	if len(mp) == 0 {
+		// only counter-clockwise rings, treat them as exteriors
+		for _, ring := range interiors {
+			mp = append(mp, [][][2]float64{pkg.ReverseRing(ring)})
+		}
+		return mp
+	}
+
+	for _, interior := range interiors {
+		owner := len(mp) - 1
+		for i, p := range mp {
+			if len(interior) == 0 {
+				break
+			}
+			if inside, onBoundary := pkg.PointInRing(p[0], interior[0]); inside || onBoundary {
+				owner = i
+				break
+			}
+		}
+		mp[owner] = append(mp[owner], interior)
+	}
+	return mp
+}
+
+// encodeShape encodes the geometry as the content of a shape record of the shape type, the
+// rings of polygons are oriented as the shapefile requires: exterior clockwise and interiors
+// counter-clockwise. It returns the extent of the geometry, nil for a null shape.
+func encodeShape(geometry geom.Geometry, shapeType int32) ([]byte, *geom.Extent, error) {
+	if geometry == nil {
+		return le(int32(shapeNull)), nil, nil
+	}
+
+	var parts [][][2]float64
+	switch g := geometry.(type) {
+	case geom.Point:
+		if shapeType != shapePoint {
+			return nil, nil, fmt.Errorf("unexpected geometry %T for shape type %d", g, shapeType)
+		}
+		b := le(int32(shapePoint))
+		b = append(b, le(g[0])...)
+		b = append(b, le(g[1])...)
+		extent := geom.NewExtent([2]float64(g))
+		return b, extent, nil
+	case geom.MultiPoint:
+		parts = [][][2]float64{g}
+	case geom.LineString:
+		parts = [][][2]float64{g}
+	case geom.MultiLineString:
+		parts = g
+	case geom.Polygon:
+		parts = orientedRings(g)
+	case geom.MultiPolygon:
+		for _, p := range g {
+			parts = append(parts, orientedRings(p)...)
+		}
+	case geom.Collection:
+		for _, member := range g {
+			switch m := member.(type) {
+			case geom.Point:
+				parts = append(parts, [][2]float64{m})
+			case geom.MultiPoint:
+				parts = append(parts, m)
+			case geom.LineString:
+				parts = append(parts, m)
+			case geom.MultiLineString:
+				parts = append(parts, m...)
+			case geom.Polygon:
+				parts = append(parts, orientedRings(m)...)
+			case geom.MultiPolygon:
+				for _, p := range m {
+					parts = append(parts, orientedRings(p)...)
+				}
+			}
+		}
+	}
+	if shapeTypeForGeometry(geometry) != shapeType {
+		return nil, nil, fmt.Errorf("unexpected geometry %T for shape type %d", geometry, shapeType)
+	}
+
+	var pts [][2]float64
+	var offsets []int32
+	for _, part := range parts {
+		offsets = append(offsets, int32(len(pts)))
+		pts = append(pts, part...)
+	}
+	if len(pts) == 0 {
+		return le(int32(shapeNull)), nil, nil
+	}
+	extent := geom.NewExtent(pts...)
+
+	b := le(shapeType)
+	b = append(b, le(extent.MinX())...)
+	b = append(b, le(extent.MinY())...)
+	b = append(b, le(extent.MaxX())...)
+	b = append(b, le(extent.MaxY())...)
+	if shapeType != shapeMultiPoint {
+		b = append(b, le(int32(len(parts)))...)
+	}
+	b = append(b, le(int32(len(pts)))...)
+	if shapeType != shapeMultiPoint {
+		for _, offset := range offsets {
+			b = append(b, le(offset)...)
+		}
+	}
+	for _, pt := range pts {
+		b = append(b, le(pt[0])...)
+		b = append(b, le(pt[1])...)
+	}
+	return b, extent, nil
+}
+
+func le(v interface{}) []byte {
+	switch n := v.(type) {
+	case int32:
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(n))
+		return b
+	case float64:
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(n))
+		return b
+	}
+	return nil
+}
+
+// orientedRings returns the closed rings of the polygon with the exterior clockwise
+// and the interiors counter-clockwise
+func orientedRings(p geom.Polygon) [][][2]float64 {
+	rings := make([][][2]float64, 0, len(p))
+	for i, ring := range p {
+		if len(ring) == 0 {
+			continue
+		}
+		if ring[0] != ring[len(ring)-1] {
+			ring = append(append([][2]float64{}, ring...), ring[0])
+		}
+		if (i == 0) != (pkg.SignedArea(ring) < 0) {
+			ring = pkg.ReverseRing(ring)
+		}
+		rings = append(rings, ring)
+	}
+	return rings
+}
@@ -0,0 +1,478 @@
+package shp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+// fidColumn is the primary key column, an integer field with this name is used
+// as primary key, otherwise the record number is added as primary key
+const fidColumn = `fid`
+
+// geometryColumn is the name of the geometry column
+const geometryColumn = `geom`
+
+// headerSize is the size of the .shp and .shx header
+const headerSize = 100
+
+// maxFileSize is the size the .shp and .dbf files can't exceed, when it would be
+// exceeded the output is continued in a new shapefile
+var maxFileSize int64 = math.MaxInt32
+
+// authority matches the EPSG authority of the CRS at the end of a WKT definition
+var authority = regexp.MustCompile(`AUTHORITY\["EPSG",\s*"?(\d+)"?\]\]\s*$`)
+
+type featureSHP struct {
+	columns  []interface{}
+	geometry geom.Geometry
+}
+
+func (f featureSHP) Columns() []interface{} {
+	return f.columns
+}
+
+func (f featureSHP) Geometry() geom.Geometry {
+	return f.geometry
+}
+
+func (f *featureSHP) UpdateGeometry(geometry geom.Geometry) {
+	f.geometry = geometry
+}
+
+// sidecar returns the path of the file with the same name and the given extension
+func sidecar(file string, ext string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ext
+}
+
+type SourceShapefile struct {
+	Table pkg.Table
+	file  string
+	// addFid is set when there is no fid field and the record number is added as fid
+	addFid bool
+	latin1 bool
+}
+
+// Init reads the shape type and the fields of the shapefile, the CRS is read from the .prj
+// and when it doesn't contain an EPSG code the given CRS is used
+func (source *SourceShapefile) Init(file string, crs pkg.SpatialReferenceSystem) {
+	source.file = file
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("error opening shapefile: %s", err)
+	}
+	defer f.Close()
+	header := make([]byte, headerSize)
+	if _, err = io.ReadFull(f, header); err != nil || binary.BigEndian.Uint32(header) != 9994 {
+		log.Fatalf("error reading shapefile header: %s", file)
+	}
+	shapeType := int32(binary.LittleEndian.Uint32(header[32:]))
+
+	d, err := os.Open(sidecar(file, `.dbf`))
+	if err != nil {
+		log.Fatalf("error opening dBASE file: %s", err)
+	}
+	defer d.Close()
+	dbf, err := newDbfReader(d)
+	if err != nil {
+		log.Fatalf("error reading dBASE file: %s", err)
+	}
+
+	if cpg, err := os.ReadFile(sidecar(file, `.cpg`)); err == nil {
+		encoding := strings.ToUpper(string(cpg))
+		source.latin1 = strings.Contains(encoding, `1252`) || strings.Contains(encoding, `8859`)
+	}
+
+	t := pkg.Table{
+		Name:           strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		GeometryColumn: geometryColumn,
+		GeometryType:   geometryTypeForShapeType(shapeType),
+		SRS:            crs,
+	}
+	source.addFid = true
+	for _, field := range dbf.fields {
+		if strings.EqualFold(field.name, fidColumn) && field.columnType() == `INTEGER` {
+			source.addFid = false
+		}
+	}
+	if source.addFid {
+		t.Columns = append(t.Columns, pkg.Column{Name: fidColumn, Type: `INTEGER`, NotNull: true, PrimaryKey: true})
+	}
+	for _, field := range dbf.fields {
+		c := pkg.Column{Name: field.name, Type: field.columnType()}
+		if !source.addFid && strings.EqualFold(field.name, fidColumn) {
+			c.NotNull = true
+			c.PrimaryKey = true
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	t.Columns = append(t.Columns, pkg.Column{Name: geometryColumn, Type: t.GeometryType})
+
+	if prj, err := os.ReadFile(sidecar(file, `.prj`)); err == nil {
+		definition := strings.TrimSpace(string(prj))
+		if m := authority.FindStringSubmatch(definition); m != nil {
+			code, _ := strconv.Atoi(m[1])
+			t.SRS = pkg.SpatialReferenceSystem{
+				Name:                   `EPSG:` + m[1],
+				ID:                     code,
+				Organization:           `EPSG`,
+				OrganizationCoordsysID: code,
+			}
+		}
+		t.SRS.Definition = definition
+	}
+	source.Table = t
+}
+
+func (source SourceShapefile) Close() {
+}
+
+func (source *SourceShapefile) SetTable(table pkg.Table) {
+	source.Table = table
+}
+
+func (source SourceShapefile) GetTableInfo() []pkg.Table {
+	return []pkg.Table{source.Table}
+}
+
+// ReadFeatures reads the shapes and the dBASE records side by side, deleted records are skipped
+func (source SourceShapefile) ReadFeatures(preSieve chan pkg.Feature) {
+	f, err := os.Open(source.file)
+	if err != nil {
+		log.Fatalf("error opening shapefile: %s", err)
+	}
+	defer f.Close()
+	d, err := os.Open(sidecar(source.file, `.dbf`))
+	if err != nil {
+		log.Fatalf("error opening dBASE file: %s", err)
+	}
+	defer d.Close()
+
+	r := bufio.NewReader(f)
+	if _, err = r.Discard(headerSize); err != nil {
+		log.Fatalf("error reading shapefile: %s", err)
+	}
+	dbf, err := newDbfReader(d)
+	if err != nil {
+		log.Fatalf("error reading dBASE file: %s", err)
+	}
+	dbf.latin1 = source.latin1
+
+	for {
+		record := make([]byte, 8)
+		_, err := io.ReadFull(r, record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("error reading shapefile: %s", err)
+		}
+		number := int64(binary.BigEndian.Uint32(record))
+		content := make([]byte, 2*int(binary.BigEndian.Uint32(record[4:])))
+		if _, err = io.ReadFull(r, content); err != nil {
+			log.Fatalf("error reading shape %d: %s", number, err)
+		}
+		geometry, err := decodeShape(content)
+		if err != nil {
+			log.Fatalf("error decoding shape %d: %s", number, err)
+		}
+
+		values, deleted, err := dbf.read()
+		if err != nil {
+			log.Fatalf("error reading dBASE record %d: %s", number, err)
+		}
+		if deleted {
+			continue
+		}
+		if source.addFid {
+			values = append([]interface{}{number}, values...)
+		}
+		preSieve <- &featureSHP{columns: values, geometry: geometry}
+	}
+	close(preSieve)
+}
+
+type TargetShapefile struct {
+	Table pkg.Table
+	file  string
+	files map[string]string
+}
+
+// Init sets the target file, when more then one table is written the
+// table name is added to the file name: target_table.shp
+func (target *TargetShapefile) Init(file string) {
+	target.file = file
+}
+
+func (target TargetShapefile) Close() {
+}
+
+func (target *TargetShapefile) SetTable(table pkg.Table) {
+	target.Table = table
+}
+
+func (target *TargetShapefile) CreateTables(tables []pkg.Table) error {
+	target.files = make(map[string]string)
+	for _, table := range tables {
+		file := target.file
+		if len(tables) > 1 {
+			file = sidecar(target.file, `_`+table.Name+filepath.Ext(target.file))
+		}
+		if err := target.available(file); err != nil {
+			return err
+		}
+		target.files[table.Name] = file
+	}
+	return nil
+}
+
+// available checks that the shapefile doesn't exist and isn't the file of another table,
+// like a part of a table that continues in the file of a table named like the part
+func (target TargetShapefile) available(file string) error {
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("target shapefile already exists: %s", file)
+	}
+	for _, f := range target.files {
+		if strings.EqualFold(f, file) {
+			return fmt.Errorf("target shapefile is the file of another table: %s", file)
+		}
+	}
+	return nil
+}
+
+// WriteFeatures writes the features to the shapefile, when the shape type can't be
+// determined from the geometry type of the table it is taken from the first geometry.
+// Geometries not matching the shape type are written as null shapes.
+func (target TargetShapefile) WriteFeatures(postSieve chan pkg.Feature) {
+	file := target.files[target.Table.Name]
+	fields, columns := target.fields()
+	shapeType := shapeTypeForGeometryType(target.Table.GeometryType)
+
+	var part *shapefile
+	var mismatched int
+	truncated := make(map[string]int)
+	n := 1
+	for {
+		feature, hasMore := <-postSieve
+		if !hasMore {
+			break
+		}
+		if shapeType == shapeNull && feature.Geometry() != nil {
+			shapeType = shapeTypeForGeometry(feature.Geometry())
+		}
+
+		content, extent, err := encodeShape(feature.Geometry(), shapeType)
+		if err != nil {
+			mismatched++
+			content, extent, _ = encodeShape(nil, shapeType)
+		}
+		values := make([]interface{}, len(fields))
+		for i, column := range columns {
+			if column < len(feature.Columns()) {
+				values[i] = feature.Columns()[column]
+			}
+		}
+
+		if part != nil && part.full(len(content)) {
+			if err = part.close(); err != nil {
+				log.Fatalf("error writing shapefile: %s", err)
+			}
+			for name, count := range part.dbf.truncated {
+				truncated[name] += count
+			}
+			n++
+			file = sidecar(target.files[target.Table.Name], fmt.Sprintf("_%d.shp", n))
+			if err = target.available(file); err != nil {
+				log.Fatalf("error continuing the shapefile: %s", err)
+			}
+			slog.Info(`the 2GB limit is reached, continuing in a new file`, `table`, target.Table.Name, `file`, file)
+			part = nil
+		}
+		if part == nil {
+			part, err = createShapefile(file, shapeType, fields, target.Table.SRS)
+			if err != nil {
+				log.Fatalf("error creating shapefile: %s", err)
+			}
+		}
+		part.shapeType = shapeType
+		if err = part.write(content, extent, values); err != nil {
+			log.Fatalf("error writing shapefile: %s", err)
+		}
+	}
+
+	if part == nil {
+		var err error
+		part, err = createShapefile(file, shapeType, fields, target.Table.SRS)
+		if err != nil {
+			log.Fatalf("error creating shapefile: %s", err)
+		}
+	}
+	if err := part.close(); err != nil {
+		log.Fatalf("error writing shapefile: %s", err)
+	}
+	for name, count := range part.dbf.truncated {
+		truncated[name] += count
+	}
+	for _, f := range fields {
+		if truncated[f.name] > 0 {
+			slog.Warn(`character values are truncated to the field length`, `table`, target.Table.Name, `field`, f.name, `length`, f.length, `count`, truncated[f.name])
+		}
+	}
+	if mismatched > 0 {
+		slog.Warn(`geometries not matching the shape type are written as null shapes`, `table`, target.Table.Name, `count`, mismatched)
+	}
+}
+
+// fields returns the dBASE fields of the table and for each field the index
+// in the feature columns, field names longer than 10 characters are truncated
+func (target TargetShapefile) fields() ([]field, []int) {
+	var fields []field
+	var columns []int
+	var i int
+	for _, c := range target.Table.Columns {
+		if c.Name == target.Table.GeometryColumn {
+			continue
+		}
+		f, ok := fieldForColumn(c.Name, c.Type)
+		if ok {
+			fields = append(fields, f)
+			columns = append(columns, i)
+		} else {
+			slog.Warn(`column can't be written to a shapefile`, `table`, target.Table.Name, `column`, c.Name, `type`, c.Type)
+		}
+		i++
+	}
+	for name, truncated := range fieldNames(fields) {
+		slog.Warn(`field name is truncated`, `table`, target.Table.Name, `field`, name, `truncated`, truncated)
+	}
+	return fields, columns
+}
+
+// shapefile is the set of .shp, .shx and .dbf files being written
+type shapefile struct {
+	shp, shx  *os.File
+	shpBuf    *bufio.Writer
+	shxBuf    *bufio.Writer
+	dbf       *dbfWriter
+	dbfFile   *os.File
+	shapeType int32
+	extent    *geom.Extent
+	size      int64
+	count     int32
+}
+
+func createShapefile(file string, shapeType int32, fields []field, srs pkg.SpatialReferenceSystem) (*shapefile, error) {
+	var s shapefile
+	var err error
+	s.shapeType = shapeType
+	s.size = headerSize
+	if s.shp, err = os.Create(file); err != nil {
+		return nil, err
+	}
+	if s.shx, err = os.Create(sidecar(file, `.shx`)); err != nil {
+		return nil, err
+	}
+	if s.dbfFile, err = os.Create(sidecar(file, `.dbf`)); err != nil {
+		return nil, err
+	}
+	if s.dbf, err = newDbfWriter(s.dbfFile, fields); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(sidecar(file, `.cpg`), []byte(`UTF-8`), 0644); err != nil {
+		return nil, err
+	}
+	if srs.Definition != `` && srs.Definition != `undefined` {
+		if err = os.WriteFile(sidecar(file, `.prj`), []byte(srs.Definition), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	s.shpBuf = bufio.NewWriter(s.shp)
+	s.shxBuf = bufio.NewWriter(s.shx)
+	// the headers are written again with the file lengths and the extent on close
+	s.shpBuf.Write(make([]byte, headerSize))
+	s.shxBuf.Write(make([]byte, headerSize))
+	return &s, nil
+}
+
+// full determines if a record with the given content length would exceed the maximum file size
+func (s *shapefile) full(contentLength int) bool {
+	return s.count > 0 &&
+		(s.size+8+int64(contentLength) > maxFileSize || s.dbf.size()+int64(s.dbf.recordSize) > maxFileSize)
+}
+
+func (s *shapefile) write(content []byte, extent *geom.Extent, values []interface{}) error {
+	s.count++
+	record := make([]byte, 8)
+	binary.BigEndian.PutUint32(record, uint32(s.count))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(content)/2))
+	index := make([]byte, 8)
+	binary.BigEndian.PutUint32(index, uint32(s.size/2))
+	binary.BigEndian.PutUint32(index[4:], uint32(len(content)/2))
+
+	s.shpBuf.Write(record)
+	s.shpBuf.Write(content)
+	if _, err := s.shxBuf.Write(index); err != nil {
+		return err
+	}
+	s.size = s.size + 8 + int64(len(content))
+
+	if extent != nil {
+		if s.extent == nil {
+			e := *extent
+			s.extent = &e
+		} else {
+			s.extent.Add(extent)
+		}
+	}
+	return s.dbf.write(values)
+}
+
+func (s *shapefile) close() error {
+	defer s.shp.Close()
+	defer s.shx.Close()
+	defer s.dbfFile.Close()
+
+	if err := s.shpBuf.Flush(); err != nil {
+		return err
+	}
+	if err := s.shxBuf.Flush(); err != nil {
+		return err
+	}
+	if err := s.writeHeader(s.shp, s.size); err != nil {
+		return err
+	}
+	if err := s.writeHeader(s.shx, headerSize+8*int64(s.count)); err != nil {
+		return err
+	}
+	return s.dbf.close()
+}
+
+// writeHeader writes the header of the .shp or .shx file
+func (s *shapefile) writeHeader(f *os.File, size int64) error {
+	header := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(header, 9994)
+	binary.BigEndian.PutUint32(header[24:], uint32(size/2))
+	binary.LittleEndian.PutUint32(header[28:], 1000)
+	binary.LittleEndian.PutUint32(header[32:], uint32(s.shapeType))
+	if s.extent != nil {
+		for i, v := range []float64{s.extent.MinX(), s.extent.MinY(), s.extent.MaxX(), s.extent.MaxY()} {
+			binary.LittleEndian.PutUint64(header[36+8*i:], math.Float64bits(v))
+		}
+	}
+	_, err := f.WriteAt(header, 0)
+	return err
+}
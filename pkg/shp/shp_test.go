@@ -0,0 +1,226 @@
+package shp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg"
+)
+
+// write writes the features to the shapefile and reads them back
+func write(t *testing.T, file string, table pkg.Table, features []featureSHP) (pkg.Table, []pkg.Feature) {
+	target := TargetShapefile{}
+	target.Init(file)
+	if err := target.CreateTables([]pkg.Table{table}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	target.SetTable(table)
+	postSieve := make(chan pkg.Feature)
+	go func() {
+		for i := range features {
+			postSieve <- &features[i]
+		}
+		close(postSieve)
+	}()
+	target.WriteFeatures(postSieve)
+
+	return read(file)
+}
+
+func read(file string) (pkg.Table, []pkg.Feature) {
+	source := SourceShapefile{}
+	source.Init(file, pkg.SpatialReferenceSystem{Name: `EPSG:4326`, ID: 4326})
+	preSieve := make(chan pkg.Feature)
+	go source.ReadFeatures(preSieve)
+	var got []pkg.Feature
+	for {
+		feature, hasMore := <-preSieve
+		if !hasMore {
+			break
+		}
+		got = append(got, feature)
+	}
+	return source.GetTableInfo()[0], got
+}
+
+func TestRoundTrip(t *testing.T) {
+	table := pkg.Table{
+		Name: `parcels`,
+		Columns: []pkg.Column{
+			{Name: `fid`, Type: `INTEGER`, NotNull: true, PrimaryKey: true},
+			{Name: `name`, Type: `TEXT`},
+			{Name: `area`, Type: `REAL`},
+			{Name: `registered`, Type: `DATE`},
+			{Name: `active`, Type: `BOOLEAN`},
+			{Name: `geom`, Type: `MULTIPOLYGON`},
+		},
+		GeometryColumn: `geom`,
+		GeometryType:   `MULTIPOLYGON`,
+		SRS:            pkg.SpatialReferenceSystem{Definition: `PROJCS["Amersfoort / RD New",AUTHORITY["EPSG","28992"]]`},
+	}
+	features := []featureSHP{
+		// counter-clockwise exterior and clockwise interior are reversed on writing
+		{columns: []interface{}{int64(10), `één`, 1.5, `2020-01-02`, true},
+			geometry: geom.Polygon{{{0, 0}, {10, 0}, {10, 10}, {0, 10}}, {{2, 2}, {2, 4}, {4, 4}, {4, 2}}}},
+		{columns: []interface{}{int64(11), nil, nil, nil, nil},
+			geometry: geom.MultiPolygon{{{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}}, {{{5, 5}, {5, 6}, {6, 6}, {6, 5}, {5, 5}}}}},
+		{columns: []interface{}{int64(12), `null`, 2.0, nil, false}},
+	}
+
+	got, result := write(t, filepath.Join(t.TempDir(), `parcels.shp`), table, features)
+
+	expectedTable := table
+	expectedTable.SRS = pkg.SpatialReferenceSystem{Name: `EPSG:28992`, ID: 28992, Organization: `EPSG`, OrganizationCoordsysID: 28992, Definition: table.SRS.Definition}
+	if !reflect.DeepEqual(got, expectedTable) {
+		t.Errorf("expected: %v \ngot: %v", expectedTable, got)
+	}
+
+	expected := []featureSHP{
+		{columns: []interface{}{int64(10), `één`, 1.5, `2020-01-02`, true},
+			geometry: geom.MultiPolygon{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}, {{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}}}}},
+		{columns: []interface{}{int64(11), ``, nil, nil, nil},
+			geometry: geom.MultiPolygon{{{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}}, {{{5, 5}, {5, 6}, {6, 6}, {6, 5}, {5, 5}}}}},
+		{columns: []interface{}{int64(12), `null`, 2.0, nil, false}},
+	}
+	if len(result) != len(expected) {
+		t.Fatalf("expected: %d features \ngot: %d", len(expected), len(result))
+	}
+	for k, feature := range result {
+		if !reflect.DeepEqual(feature.Columns(), expected[k].columns) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected[k].columns, feature.Columns())
+		}
+		if !reflect.DeepEqual(feature.Geometry(), expected[k].geometry) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected[k].geometry, feature.Geometry())
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	defer func(size int64) { maxFileSize = size }(maxFileSize)
+	// header and 2 point records of 28 bytes
+	maxFileSize = headerSize + 2*28
+
+	table := pkg.Table{
+		Name:           `points`,
+		Columns:        []pkg.Column{{Name: `id`, Type: `INTEGER`}, {Name: `geom`, Type: `POINT`}},
+		GeometryColumn: `geom`,
+		GeometryType:   `POINT`,
+	}
+	var features []featureSHP
+	for i := 0; i < 5; i++ {
+		features = append(features, featureSHP{columns: []interface{}{int64(i)}, geometry: geom.Point{float64(i), 0}})
+	}
+
+	dir := t.TempDir()
+	_, first := write(t, filepath.Join(dir, `points.shp`), table, features)
+	_, second := read(filepath.Join(dir, `points_2.shp`))
+	_, third := read(filepath.Join(dir, `points_3.shp`))
+	if len(first) != 2 || len(second) != 2 || len(third) != 1 {
+		t.Errorf("expected: 2, 2 and 1 features \ngot: %d, %d and %d", len(first), len(second), len(third))
+	}
+	if third[0].Geometry() != (geom.Point{4, 0}) {
+		t.Errorf("expected: %v \ngot: %v", geom.Point{4, 0}, third[0].Geometry())
+	}
+}
+
+func TestFieldNames(t *testing.T) {
+	var tests = []struct {
+		names     []string
+		expected  []string
+		truncated map[string]string
+	}{
+		// 0
+		{names: []string{`short`, `exactly_10`}, expected: []string{`short`, `exactly_10`}, truncated: map[string]string{}},
+		// 1
+		{names: []string{`municipality_code`, `municipality_name`, `municipal`},
+			expected:  []string{`municipali`, `municipal1`, `municipal`},
+			truncated: map[string]string{`municipality_code`: `municipali`, `municipality_name`: `municipal1`}},
+		// 2
+		{names: []string{`straatnamé_1`}, expected: []string{`straatnam`}, truncated: map[string]string{`straatnamé_1`: `straatnam`}},
+		// 3 a name that fits is kept when an earlier name truncates to it
+		{names: []string{`population_a`, `population`},
+			expected:  []string{`populatio1`, `population`},
+			truncated: map[string]string{`population_a`: `populatio1`}},
+	}
+
+	for k, test := range tests {
+		var fields []field
+		for _, name := range test.names {
+			fields = append(fields, field{name: name})
+		}
+		truncated := fieldNames(fields)
+		var names []string
+		for _, f := range fields {
+			names = append(names, f.name)
+		}
+		if !reflect.DeepEqual(names, test.expected) || !reflect.DeepEqual(truncated, test.truncated) {
+			t.Errorf("test: %d, expected: %v %v \ngot: %v %v", k, test.expected, test.truncated, names, truncated)
+		}
+	}
+}
+
+func TestTruncatedValues(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), `test.dbf`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d, err := newDbfWriter(f, []field{{name: `name`, ftype: 'C', length: maxCharacterLength}, {name: `n`, ftype: 'N', length: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{`a`, strings.Repeat(`a`, maxCharacterLength), strings.Repeat(`a`, maxCharacterLength+1), strings.Repeat(`é`, maxCharacterLength), strings.Repeat(`€`, maxCharacterLength)} {
+		if err = d.write([]interface{}{value, int64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := map[string]int{`name`: 3}; !reflect.DeepEqual(d.truncated, expected) {
+		t.Errorf("expected: %v \ngot: %v", expected, d.truncated)
+	}
+}
+
+func TestCreateTablesExisting(t *testing.T) {
+	file := filepath.Join(t.TempDir(), `test.shp`)
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := TargetShapefile{}
+	target.Init(file)
+	if err := target.CreateTables([]pkg.Table{{Name: `a`}}); err == nil {
+		t.Errorf("expected an error for an existing target")
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, `points_2.shp`), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := TargetShapefile{}
+	target.Init(filepath.Join(dir, `target.shp`))
+	if err := target.CreateTables([]pkg.Table{{Name: `a`}, {Name: `a_2`}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var tests = []struct {
+		file string
+		err  bool
+	}{
+		// 0
+		{file: filepath.Join(dir, `points_3.shp`)},
+		// 1 an existing part
+		{file: filepath.Join(dir, `points_2.shp`), err: true},
+		// 2 the second part of table a is the file of table a_2
+		{file: filepath.Join(dir, `target_a_2.shp`), err: true},
+	}
+
+	for k, test := range tests {
+		if err := target.available(test.file); (err != nil) != test.err {
+			t.Errorf("test: %d, expected error: %v \ngot: %v", k, test.err, err)
+		}
+	}
+}
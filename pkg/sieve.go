@@ -1,12 +1,108 @@
 package pkg
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"math"
+	"strconv"
 
 	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg/logging"
+	"github.com/pdok/sieve/pkg/metrics"
 )
 
+// Policy determines what is retained of a feature when all its parts are sieved
+type Policy string
+
+const (
+	// PolicyDrop removes the feature completely, this is the default
+	PolicyDrop Policy = `drop`
+	// PolicyLargest retains the largest part of the feature
+	PolicyLargest Policy = `largest`
+	// PolicyPoint retains a minimal polygon, the size of a single 'pixel',
+	// around a representative point of the largest part of the feature
+	PolicyPoint Policy = `point`
+)
+
+// ParsePolicy validates the given string as a Policy, an empty string results in PolicyDrop
+func ParsePolicy(policy string) (Policy, error) {
+	switch Policy(policy) {
+	case ``, PolicyDrop:
+		return PolicyDrop, nil
+	case PolicyLargest, PolicyPoint:
+		return Policy(policy), nil
+	}
+	return ``, fmt.Errorf("unknown policy: %s", policy)
+}
+
+// MultiPolygonMode determines how the parts of a MULTIPOLYGON are evaluated
+type MultiPolygonMode string
+
+const (
+	// MultiPolygonParts evaluates every part on its own, this is the default
+	MultiPolygonParts MultiPolygonMode = `parts`
+	// MultiPolygonWhole evaluates the summed area of all the parts
+	MultiPolygonWhole MultiPolygonMode = `whole`
+)
+
+// ParseMultiPolygonMode validates the given string as a MultiPolygonMode, an empty string results in MultiPolygonParts
+func ParseMultiPolygonMode(mode string) (MultiPolygonMode, error) {
+	switch MultiPolygonMode(mode) {
+	case ``, MultiPolygonParts:
+		return MultiPolygonParts, nil
+	case MultiPolygonWhole:
+		return MultiPolygonWhole, nil
+	}
+	return ``, fmt.Errorf("unknown multipolygon mode: %s", mode)
+}
+
+// Options contains the settings used for sieving the features of a single table
+type Options struct {
+	// Table is the name of the sieved table, used for the metrics and the logging
+	Table string
+	// Zoom is the zoom level the table is sieved for when writing vector tiles, nil otherwise
+	Zoom *int
+	// PrimaryKey is the position of the primary key in the columns of the source features, -1 without
+	// one. It identifies the features in the debug logging.
+	PrimaryKey       int
+	Resolution       float64
+	Policy           Policy
+	MultiPolygonMode MultiPolygonMode
+	// FilterParts removes the small parts of a MULTIPOLYGON that is kept as a whole
+	FilterParts bool
+	Validation  Validation
+	Orientation Orientation
+	// Rejects receives the features rejected by the validation, when nil they are dropped
+	Rejects *Rejects
+	// KeepRejected passes the features rejected by the validation on as a RejectedFeature, so a
+	// GeoPackage sieved in place keeps them as they are instead of deleting them
+	KeepRejected bool
+	// Projection selects the columns written to the target, when nil all columns are written
+	Projection *Projection
+	// Computed are the columns computed while sieving, appended to the columns of the target
+	Computed []Computed
+	// Annotate keeps all features as-is, instead of sieving them the resolution at which they
+	// would be sieved is written to the ComputedMaxResolution column
+	Annotate bool
+	// Sort orders the features written to the target by a space filling curve
+	Sort Sort
+	// ChannelBuffer is the capacity of the channels between the stages, 0 for unbuffered channels
+	ChannelBuffer int
+	// Blocking accumulates the time the stages were blocked, when set the blocking of every table is logged
+	Blocking *Blocking
+	// Progress counts the features read, when set the progress is reported while sieving
+	Progress *Progress
+}
+
+// zoomLabel returns the zoom level as label of the metrics, empty when no vector tiles are written
+func (options Options) zoomLabel() string {
+	if options.Zoom == nil {
+		return ``
+	}
+	return strconv.Itoa(*options.Zoom)
+}
+
 // readFeatures reads the features from the given Geopackage table
 // and decodes the WKB geometry to a geom.Polygon
 func readFeaturesFromSource(source Source, preSieve chan Feature) {
@@ -17,47 +113,124 @@ func readFeaturesFromSource(source Source, preSieve chan Feature) {
 // the two steps that are done are:
 // 1. filter features with a area smaller then the (resolution*resolution)
 // 2. removes interior rings with a area smaller then the (resolution*resolution)
-func sieveFeatures(preSieve chan Feature, postSieve chan Feature, resolution float64) {
-	var preSieveCount, postSieveCount, nonPolygonCount, multiPolygonCount uint64
+// When the whole feature would be removed the Policy from the options decides what is retained
+func sieveFeatures(preSieve chan Feature, postSieve chan Feature, options Options, stage *StageBlocking) {
+	var preSieveCount, postSieveCount, nonPolygonCount, multiPolygonCount, collectionCount, retainedCount uint64
+	read := metrics.FeaturesRead.WithLabelValues(options.Table, options.zoomLabel())
+	kept := metrics.FeaturesKept.WithLabelValues(options.Table, options.zoomLabel())
+	dropped := metrics.FeaturesDropped.WithLabelValues(options.Table, options.zoomLabel())
+	logger := slog.With(`table`, options.Table, `stage`, `sieve`)
+	debug := logger.Enabled(context.Background(), slog.LevelDebug)
 	for {
-		feature, hasMore := <-preSieve
+		feature, hasMore := stage.receive(preSieve)
 		if !hasMore {
 			break
 		} else {
 			preSieveCount++
+			read.Inc()
+			if options.Progress != nil {
+				options.Progress.add()
+			}
+			original := feature.Geometry()
+			send := func(feature Feature) {
+				if len(options.Computed) > 0 {
+					feature = withComputed(feature, original, options)
+				}
+				kept.Inc()
+				stage.send(postSieve, feature)
+			}
+			if options.Annotate {
+				postSieveCount++
+				send(feature)
+				continue
+			}
 			switch feature.Geometry().(type) {
 			case geom.Polygon:
 				var p geom.Polygon
 				p = feature.Geometry().(geom.Polygon)
-				if p := polygonSieve(p, resolution); p != nil {
-					feature.UpdateGeometry(p)
+				sieved := polygonSieve(p, options.Resolution)
+				retained := false
+				if sieved == nil {
+					if sieved = polygonRetain(p, options); sieved != nil {
+						retainedCount++
+						retained = true
+					}
+				}
+				if debug {
+					explain(logger, preSieveCount, feature, sieved != nil, retained, options)
+				}
+				if sieved != nil {
+					feature.UpdateGeometry(sieved)
 					postSieveCount++
-					postSieve <- feature
+					send(feature)
+				} else {
+					dropped.Inc()
 				}
 			case geom.MultiPolygon:
 				var mp geom.MultiPolygon
 				mp = feature.Geometry().(geom.MultiPolygon)
-				if mp := multiPolygonSieve(mp, resolution); mp != nil {
-					feature.UpdateGeometry(mp)
+				sieved := multiPolygonModeSieve(mp, options)
+				retained := false
+				if sieved == nil {
+					if sieved = multiPolygonRetain(mp, options); sieved != nil {
+						retainedCount++
+						retained = true
+					}
+				}
+				if debug {
+					explain(logger, preSieveCount, feature, sieved != nil, retained, options)
+				}
+				if sieved != nil {
+					feature.UpdateGeometry(sieved)
 					multiPolygonCount++
 					postSieveCount++
-					postSieve <- feature
+					send(feature)
+				} else {
+					dropped.Inc()
+				}
+			case geom.Collection:
+				var c geom.Collection
+				c = feature.Geometry().(geom.Collection)
+				sieved := collectionSieve(c, options)
+				retained := false
+				if sieved == nil {
+					if sieved = collectionRetain(c, options); sieved != nil {
+						retainedCount++
+						retained = true
+					}
+				}
+				if debug {
+					explain(logger, preSieveCount, feature, sieved != nil, retained, options)
+				}
+				if sieved != nil {
+					feature.UpdateGeometry(sieved)
+					collectionCount++
+					postSieveCount++
+					send(feature)
+				} else {
+					dropped.Inc()
 				}
 			default:
 				postSieveCount++
 				nonPolygonCount++
-				postSieve <- feature
+				send(feature)
 			}
 		}
 	}
 	close(postSieve)
 
-	log.Printf("    total features: %d", preSieveCount)
-	log.Printf("      non-polygons: %d", nonPolygonCount)
+	counts := []any{`total_features`, preSieveCount, `non-polygons`, nonPolygonCount}
 	if preSieveCount != nonPolygonCount {
-		log.Printf("     multipolygons: %d", multiPolygonCount)
+		counts = append(counts, `multipolygons`, multiPolygonCount)
+	}
+	if collectionCount > 0 {
+		counts = append(counts, `collections`, collectionCount)
 	}
-	log.Printf("              kept: %d", postSieveCount)
+	if retainedCount > 0 {
+		counts = append(counts, `retained`, retainedCount)
+	}
+	counts = append(counts, `kept`, postSieveCount)
+	logging.Counts(`sieved`, options.Table, `sieve`, counts...)
 }
 
 // writeFeatures collects the processed features by the sieveFeatures and
@@ -80,25 +253,189 @@ func multiPolygonSieve(mp geom.MultiPolygon, resolution float64) geom.MultiPolyg
 	return sievedMultiPolygon
 }
 
+// multiPolygonModeSieve will sieve a MULTIPOLYGON based on the MultiPolygonMode from the options
+func multiPolygonModeSieve(mp geom.MultiPolygon, options Options) geom.MultiPolygon {
+	if options.MultiPolygonMode == MultiPolygonWhole {
+		return multiPolygonWholeSieve(mp, options.Resolution, options.FilterParts)
+	}
+	return multiPolygonSieve(mp, options.Resolution)
+}
+
+// collectionSieve will sieve the (MULTI)POLYGON members of a GEOMETRYCOLLECTION, including those of
+// nested collections. Other members are kept as-is, when no members are left nil is returned
+func collectionSieve(c geom.Collection, options Options) geom.Collection {
+	var sievedCollection geom.Collection
+	for _, g := range c {
+		switch member := g.(type) {
+		case geom.Polygon:
+			if p := polygonSieve(member, options.Resolution); p != nil {
+				sievedCollection = append(sievedCollection, p)
+			}
+		case geom.MultiPolygon:
+			if mp := multiPolygonModeSieve(member, options); mp != nil {
+				sievedCollection = append(sievedCollection, mp)
+			}
+		case geom.Collection:
+			if sc := collectionSieve(member, options); sc != nil {
+				sievedCollection = append(sievedCollection, sc)
+			}
+		default:
+			sievedCollection = append(sievedCollection, g)
+		}
+	}
+	return sievedCollection
+}
+
+// multiPolygonWholeSieve will sieve a MULTIPOLYGON based on the summed area of all its parts,
+// comparable to the PostGIS Sieve function. When the MULTIPOLYGON is kept the interior rings of
+// the parts are sieved and, with filterParts, also the parts itself. If that would remove
+// all the parts the largest part is kept.
+func multiPolygonWholeSieve(mp geom.MultiPolygon, resolution float64, filterParts bool) geom.MultiPolygon {
+	minArea := resolution * resolution
+	total := 0.
+	for _, p := range mp {
+		total = total + area(p)
+	}
+	if total <= minArea {
+		return nil
+	}
+
+	var sievedMultiPolygon geom.MultiPolygon
+	for _, p := range mp {
+		if filterParts {
+			if sievedPolygon := polygonSieve(p, resolution); sievedPolygon != nil {
+				sievedMultiPolygon = append(sievedMultiPolygon, sievedPolygon)
+			}
+		} else {
+			sievedMultiPolygon = append(sievedMultiPolygon, interiorSieve(p, minArea))
+		}
+	}
+	if sievedMultiPolygon == nil {
+		sievedMultiPolygon = geom.MultiPolygon{interiorSieve(largestPolygon(mp), minArea)}
+	}
+	return sievedMultiPolygon
+}
+
 // polygonSieve will sieve a given POLYGON
 func polygonSieve(p geom.Polygon, resolution float64) geom.Polygon {
 	minArea := resolution * resolution
 	if area(p) > minArea {
-		if len(p) > 1 {
-			var sievedPolygon geom.Polygon
-			sievedPolygon = append(sievedPolygon, p[0])
-			for _, interior := range p[1:] {
-				if shoelace(interior) > minArea {
-					sievedPolygon = append(sievedPolygon, interior)
-				}
+		return interiorSieve(p, minArea)
+	}
+	return nil
+}
+
+// interiorSieve removes the interior rings with a area smaller then the minArea
+func interiorSieve(p geom.Polygon, minArea float64) geom.Polygon {
+	if len(p) > 1 {
+		var sievedPolygon geom.Polygon
+		sievedPolygon = append(sievedPolygon, p[0])
+		for _, interior := range p[1:] {
+			if shoelace(interior) > minArea {
+				sievedPolygon = append(sievedPolygon, interior)
 			}
-			return sievedPolygon
 		}
-		return p
+		return sievedPolygon
+	}
+	return p
+}
+
+// polygonRetain returns what is left of a completely sieved POLYGON based on the policy
+func polygonRetain(p geom.Polygon, options Options) geom.Polygon {
+	if len(p) == 0 || len(p[0]) == 0 {
+		return nil
+	}
+	switch options.Policy {
+	case PolicyLargest:
+		return interiorSieve(p, options.Resolution*options.Resolution)
+	case PolicyPoint:
+		return pixel(centroid(p[0]), options.Resolution)
 	}
 	return nil
 }
 
+// multiPolygonRetain returns what is left of a completely sieved MULTIPOLYGON based on the policy,
+// this is always (a representation of) the largest part
+func multiPolygonRetain(mp geom.MultiPolygon, options Options) geom.MultiPolygon {
+	if p := polygonRetain(largestPolygon(mp), options); p != nil {
+		return geom.MultiPolygon{p}
+	}
+	return nil
+}
+
+// collectionRetain returns what is left of a completely sieved GEOMETRYCOLLECTION based on the policy,
+// this is always (a representation of) the largest POLYGON in the collection
+func collectionRetain(c geom.Collection, options Options) geom.Collection {
+	if p := polygonRetain(largestPolygon(collectionPolygons(c)), options); p != nil {
+		return geom.Collection{p}
+	}
+	return nil
+}
+
+// collectionPolygons collects all the POLYGONs in a (nested) GEOMETRYCOLLECTION
+func collectionPolygons(c geom.Collection) geom.MultiPolygon {
+	var polygons geom.MultiPolygon
+	for _, g := range c {
+		switch member := g.(type) {
+		case geom.Polygon:
+			polygons = append(polygons, member)
+		case geom.MultiPolygon:
+			polygons = append(polygons, member...)
+		case geom.Collection:
+			polygons = append(polygons, collectionPolygons(member)...)
+		}
+	}
+	return polygons
+}
+
+// largestPolygon returns the part of the MULTIPOLYGON with the largest area
+func largestPolygon(mp geom.MultiPolygon) geom.Polygon {
+	var largest geom.Polygon
+	largestArea := -1.
+	for _, p := range mp {
+		if a := area(p); a > largestArea {
+			largest = p
+			largestArea = a
+		}
+	}
+	return largest
+}
+
+// pixel builds a square POLYGON with sides of the resolution around the given point
+func pixel(pt [2]float64, resolution float64) geom.Polygon {
+	d := resolution / 2
+	return geom.Polygon{{
+		{pt[0] - d, pt[1] - d},
+		{pt[0] + d, pt[1] - d},
+		{pt[0] + d, pt[1] + d},
+		{pt[0] - d, pt[1] + d},
+		{pt[0] - d, pt[1] - d},
+	}}
+}
+
+// centroid calculates the centroid of a ring, for rings without an area
+// the average of the points is used
+// https://en.wikipedia.org/wiki/Centroid#Of_a_polygon
+func centroid(pts [][2]float64) [2]float64 {
+	var cx, cy, sum float64
+	p0 := pts[len(pts)-1]
+	for _, p1 := range pts {
+		cross := p0[0]*p1[1] - p1[0]*p0[1]
+		sum += cross
+		cx += (p0[0] + p1[0]) * cross
+		cy += (p0[1] + p1[1]) * cross
+		p0 = p1
+	}
+	if sum == 0 {
+		for _, p := range pts {
+			cx += p[0]
+			cy += p[1]
+		}
+		return [2]float64{cx / float64(len(pts)), cy / float64(len(pts))}
+	}
+	return [2]float64{cx / (3 * sum), cy / (3 * sum)}
+}
+
 // calculate the area of a polygon
 func area(geom [][][2]float64) float64 {
 	interior := .0
@@ -115,6 +452,12 @@ func area(geom [][][2]float64) float64 {
 
 // https://en.wikipedia.org/wiki/Shoelace_formula
 func shoelace(pts [][2]float64) float64 {
+	return math.Abs(SignedArea(pts))
+}
+
+// SignedArea calculates the area of a ring with the shoelace formula, the area is
+// positive for counter-clockwise rings and negative for clockwise rings
+func SignedArea(pts [][2]float64) float64 {
 	sum := 0.
 	if len(pts) == 0 {
 		return 0.
@@ -122,20 +465,50 @@ func shoelace(pts [][2]float64) float64 {
 
 	p0 := pts[len(pts)-1]
 	for _, p1 := range pts {
-		sum += p0[1]*p1[0] - p0[0]*p1[1]
+		sum += p0[0]*p1[1] - p1[0]*p0[1]
 		p0 = p1
 	}
-	return math.Abs(sum / 2)
+	return sum / 2
 }
 
-func Sieve(source Source, target Target, resolution float64) {
+func Sieve(source Source, target Target, options Options) {
+	var stages []*StageBlocking
+	newStage := func(name string) *StageBlocking {
+		stage := &StageBlocking{Stage: name}
+		stages = append(stages, stage)
+		return stage
+	}
 
-	preSieve := make(chan Feature)
-	postSieve := make(chan Feature)
+	preSieve := make(chan Feature, options.ChannelBuffer)
+	postSieve := make(chan Feature, options.ChannelBuffer)
 	kill := make(chan bool)
 
-	go writeFeaturesToTarget(postSieve, kill, target)
-	go sieveFeatures(preSieve, postSieve, resolution)
+	// the optional stages are chained between the sieve and the target
+	sieve := newStage(`sieve`)
+	output := postSieve
+	if options.Validation == ValidationRepair || options.Validation == ValidationReject {
+		validated := make(chan Feature, options.ChannelBuffer)
+		go validateFeatures(output, validated, options, newStage(`validate`))
+		output = validated
+	}
+	if options.Orientation != `` && options.Orientation != OrientationNone {
+		oriented := make(chan Feature, options.ChannelBuffer)
+		go orientFeatures(output, oriented, options.Orientation, newStage(`orient`))
+		output = oriented
+	}
+	if options.Projection != nil {
+		projected := make(chan Feature, options.ChannelBuffer)
+		go projectFeatures(output, projected, options.Projection, newStage(`project`))
+		output = projected
+	}
+	if options.Sort.Curve != `` && options.Sort.Curve != CurveNone {
+		sorted := make(chan Feature, options.ChannelBuffer)
+		go sortFeatures(output, sorted, options.Sort, options.Table, newStage(`sort`))
+		output = sorted
+	}
+
+	go writeFeaturesToTarget(output, kill, target)
+	go sieveFeatures(preSieve, postSieve, options, sieve)
 	go readFeaturesFromSource(source, preSieve)
 
 	for {
@@ -144,4 +517,18 @@ func Sieve(source Source, target Target, resolution float64) {
 		}
 	}
 	close(kill)
+
+	// the stages are done when the target is, so their counters can be read
+	for _, stage := range stages {
+		metrics.StageBlocked.WithLabelValues(stage.Stage, `receiving`).Add(stage.Receiving.Seconds())
+		metrics.StageBlocked.WithLabelValues(stage.Stage, `sending`).Add(stage.Sending.Seconds())
+	}
+	if options.Blocking != nil {
+		var blocking []StageBlocking
+		for _, stage := range stages {
+			blocking = append(blocking, *stage)
+			options.Blocking.add(*stage)
+		}
+		logBlocking(options.Table, blocking)
+	}
 }
@@ -2,6 +2,11 @@ package pkg
 
 import (
 	"testing"
+	"time"
+
+	"github.com/go-spatial/geom"
+	"github.com/pdok/sieve/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestShoelace(t *testing.T) {
@@ -121,3 +126,194 @@ func TestMultiPolygonSieve(t *testing.T) {
 		}
 	}
 }
+
+func TestPolygonRetain(t *testing.T) {
+	var tests = []struct {
+		geom     [][][2]float64
+		options  Options
+		retained [][][2]float64
+	}{
+		// Drop policy
+		0: {geom: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, options: Options{Resolution: 11, Policy: PolicyDrop}, retained: nil},
+		// Largest policy keeps the polygon
+		1: {geom: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, options: Options{Resolution: 11, Policy: PolicyLargest}, retained: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}},
+		// Largest policy still filters the donut
+		2: {geom: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}, {{5, 5}, {5, 6}, {6, 6}, {6, 5}, {5, 5}}}, options: Options{Resolution: 11, Policy: PolicyLargest}, retained: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}},
+		// Point policy results in a pixel around the centroid
+		3: {geom: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, options: Options{Resolution: 11, Policy: PolicyPoint}, retained: [][][2]float64{{{-0.5, -0.5}, {10.5, -0.5}, {10.5, 10.5}, {-0.5, 10.5}, {-0.5, -0.5}}}},
+		// Nil input
+		4: {geom: nil, options: Options{Resolution: 1, Policy: PolicyLargest}, retained: nil},
+	}
+
+	for k, test := range tests {
+		geom := polygonRetain(test.geom, test.options)
+		if test.retained != nil && geom != nil {
+			if area(geom) != area(test.retained) || centroid(geom[0]) != centroid(test.retained[0]) {
+				t.Errorf("test: %d, expected: %f \ngot: %f", k, test.retained, geom)
+			}
+		} else if test.retained == nil && geom != nil {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.retained, geom)
+		} else if test.retained != nil && geom == nil {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.retained, geom)
+		}
+	}
+}
+
+func TestMultiPolygonRetain(t *testing.T) {
+	var tests = []struct {
+		geom     [][][][2]float64
+		options  Options
+		retained [][][][2]float64
+	}{
+		// Drop policy
+		0: {geom: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, {{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}}, options: Options{Resolution: 101, Policy: PolicyDrop}, retained: nil},
+		// Largest policy keeps the largest part
+		1: {geom: [][][][2]float64{{{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}, {{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}, options: Options{Resolution: 101, Policy: PolicyLargest}, retained: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}},
+		// Point policy results in a pixel around the centroid of the largest part
+		2: {geom: [][][][2]float64{{{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}, {{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}, options: Options{Resolution: 2, Policy: PolicyPoint}, retained: [][][][2]float64{{{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}}}},
+		// Nil input
+		3: {geom: nil, options: Options{Resolution: 1, Policy: PolicyLargest}, retained: nil},
+	}
+
+	for k, test := range tests {
+		geom := multiPolygonRetain(test.geom, test.options)
+		if test.retained != nil && geom != nil {
+			if len(geom) != len(test.retained) || area(geom[0]) != area(test.retained[0]) || centroid(geom[0][0]) != centroid(test.retained[0][0]) {
+				t.Errorf("test: %d, expected: %f \ngot: %f", k, test.retained, geom)
+			}
+		} else if test.retained == nil && geom != nil {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.retained, geom)
+		} else if test.retained != nil && geom == nil {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.retained, geom)
+		}
+	}
+}
+
+func TestMultiPolygonWholeSieve(t *testing.T) {
+	var tests = []struct {
+		geom        [][][][2]float64
+		resolution  float64
+		filterParts bool
+		sieved      [][][][2]float64
+	}{
+		// Summed area is large enough, all parts are kept
+		0: {geom: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, {{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}}, resolution: float64(11), filterParts: false, sieved: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, {{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}}},
+		// Summed area is too small
+		1: {geom: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, {{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}}, resolution: float64(12), filterParts: false, sieved: nil},
+		// Summed area is large enough, the small part is filtered
+		2: {geom: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, {{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}}, resolution: float64(9), filterParts: true, sieved: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}},
+		// Summed area is large enough, all parts are too small so the largest is kept
+		3: {geom: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, {{{15, 15}, {15, 20}, {20, 20}, {20, 15}, {15, 15}}}}, resolution: float64(11), filterParts: true, sieved: [][][][2]float64{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}},
+		// Nil input
+		4: {geom: nil, resolution: float64(1), filterParts: true, sieved: nil},
+	}
+
+	for k, test := range tests {
+		geom := multiPolygonWholeSieve(test.geom, test.resolution, test.filterParts)
+		if test.sieved != nil && geom != nil {
+			if len(geom) != len(test.sieved) || area(geom[0]) != area(test.sieved[0]) {
+				t.Errorf("test: %d, expected: %f \ngot: %f", k, test.sieved, geom)
+			}
+		} else if test.sieved == nil && geom != nil {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.sieved, geom)
+		} else if test.sieved != nil && geom == nil {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.sieved, geom)
+		}
+	}
+}
+
+func TestCollectionSieve(t *testing.T) {
+	var tests = []struct {
+		geom    geom.Collection
+		options Options
+		members int
+	}{
+		// Small polygon is removed, the point is kept
+		0: {geom: geom.Collection{geom.Point{1, 1}, geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}, options: Options{Resolution: 11}, members: 1},
+		// Large polygon is kept
+		1: {geom: geom.Collection{geom.Point{1, 1}, geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}, options: Options{Resolution: 9}, members: 2},
+		// Nested collection with only a small polygon is removed
+		2: {geom: geom.Collection{geom.Collection{geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}, geom.Polygon{{{0, 0}, {0, 20}, {20, 20}, {20, 0}, {0, 0}}}}, options: Options{Resolution: 11}, members: 1},
+		// Only small polygons results in nil
+		3: {geom: geom.Collection{geom.MultiPolygon{{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}}, options: Options{Resolution: 11}, members: 0},
+	}
+
+	for k, test := range tests {
+		sieved := collectionSieve(test.geom, test.options)
+		if len(sieved) != test.members {
+			t.Errorf("test: %d, expected: %d members \ngot: %v", k, test.members, sieved)
+		}
+	}
+
+	retained := collectionRetain(tests[3].geom, Options{Resolution: 11, Policy: PolicyLargest})
+	if len(retained) != 1 || area(retained[0].(geom.Polygon)) != 100 {
+		t.Errorf("expected the largest polygon to be retained \ngot: %v", retained)
+	}
+}
+
+// metricsSource sends the features, waiting after the first until the condition holds
+type metricsSource struct {
+	features []Feature
+	wait     func() bool
+	waited   *bool
+}
+
+func (s metricsSource) ReadFeatures(preSieve chan Feature) {
+	for i, feature := range s.features {
+		preSieve <- feature
+		if i == 0 {
+			for deadline := time.Now().Add(time.Second); !*s.waited && time.Now().Before(deadline); {
+				*s.waited = s.wait()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	close(preSieve)
+}
+
+func TestSieveMetrics(t *testing.T) {
+	table := `metrics`
+	dropped := metrics.FeaturesDropped.WithLabelValues(table, ``)
+	features := []Feature{
+		// too small
+		&testFeature{geometry: geom.Polygon{{{0, 0}, {0, 1}, {1, 1}, {1, 0}, {0, 0}}}},
+		// kept
+		&testFeature{geometry: geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}},
+		// kept by the sieve, rejected by the validation for the interior outside the exterior
+		&testFeature{geometry: geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}, {{20, 20}, {20, 25}, {25, 25}, {25, 20}, {20, 20}}}},
+	}
+	var waited bool
+	source := metricsSource{features: features, wait: func() bool { return testutil.ToFloat64(dropped) == 1 }, waited: &waited}
+	written := 0
+	Sieve(source, testTarget{written: &written}, Options{Table: table, Resolution: 2, Validation: ValidationReject})
+
+	if !waited {
+		t.Errorf("expected the dropped feature to be counted before the table is done")
+	}
+	for k, test := range []struct {
+		name     string
+		got      float64
+		expected float64
+	}{
+		{name: `read`, got: testutil.ToFloat64(metrics.FeaturesRead.WithLabelValues(table, ``)), expected: 3},
+		{name: `kept`, got: testutil.ToFloat64(metrics.FeaturesKept.WithLabelValues(table, ``)), expected: 2},
+		{name: `rejected`, got: testutil.ToFloat64(metrics.FeaturesRejected.WithLabelValues(table, ``)), expected: 1},
+		{name: `dropped`, got: testutil.ToFloat64(dropped), expected: 1},
+		{name: `written`, got: float64(written), expected: 1},
+	} {
+		if test.got != test.expected {
+			t.Errorf("test: %d, expected: %s %v \ngot: %v", k, test.name, test.expected, test.got)
+		}
+	}
+
+	// every zoom level of vector tiles is counted on its own
+	for zoom := 3; zoom <= 4; zoom++ {
+		waited = true
+		Sieve(source, testTarget{written: &written}, Options{Table: table, Zoom: &zoom, Resolution: 2, Validation: ValidationReject})
+	}
+	for k, label := range []string{``, `3`, `4`} {
+		if got := testutil.ToFloat64(metrics.FeaturesRead.WithLabelValues(table, label)); got != 3 {
+			t.Errorf("test: %d, expected: read 3 at zoom %q \ngot: %v", k, label, got)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package pkg
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/encoding/wkb"
+	"github.com/pdok/sieve/pkg/logging"
+)
+
+// Curve is the space filling curve the features of a table are sorted by
+type Curve string
+
+const (
+	// CurveNone keeps the order of the source, this is the default
+	CurveNone Curve = `none`
+	// CurveHilbert sorts by the Hilbert key of the centroid of the envelope
+	CurveHilbert Curve = `hilbert`
+	// CurveZOrder sorts by the Z-order (Morton) key of the centroid of the envelope
+	CurveZOrder Curve = `z-order`
+)
+
+// ParseCurve validates the given string as a Curve, an empty string results in CurveNone
+func ParseCurve(curve string) (Curve, error) {
+	switch Curve(curve) {
+	case ``, CurveNone:
+		return CurveNone, nil
+	case CurveHilbert, CurveZOrder:
+		return Curve(curve), nil
+	}
+	return ``, fmt.Errorf("unknown curve: %s", curve)
+}
+
+// Sort contains the settings for sorting the features of a table by a space filling curve
+type Sort struct {
+	Curve Curve
+	// Buffer is the number of features sorted in memory, the features of larger tables
+	// are spilled to disk in sorted runs that are merged
+	Buffer int
+	// Renumber replaces the primary key by the position in the sorted order, starting at 1
+	Renumber bool
+	// PrimaryKey is the position of the primary key in Feature.Columns(), used by Renumber
+	PrimaryKey int
+}
+
+// PrimaryKeyPosition returns the position of the INTEGER primary key of the table in Feature.Columns()
+func PrimaryKeyPosition(table Table) (int, error) {
+	i := 0
+	for _, c := range table.Columns {
+		if c.Name == table.GeometryColumn {
+			continue
+		}
+		if c.PrimaryKey && c.Type == `INTEGER` {
+			return i, nil
+		}
+		i++
+	}
+	return 0, fmt.Errorf("table %s has no INTEGER primary key", table.Name)
+}
+
+func init() {
+	// the types of the column values, next to the basic types registered by gob
+	gob.Register(time.Time{})
+}
+
+// curveBits is the number of bits per axis of the curve, so a key fits in 62 bits
+const curveBits = 31
+
+// emptyKey sorts the features without geometry first, keys of the other features
+// get the next bit set
+const emptyKey uint64 = 0
+
+// key returns the key on the curve of the point within the extent
+func (curve Curve) key(x, y float64, extent *geom.Extent) uint64 {
+	scale := func(v, min, max float64) uint32 {
+		if max <= min {
+			return 0
+		}
+		return uint32((v - min) / (max - min) * float64(uint32(1)<<curveBits-1))
+	}
+	cx := scale(x, extent.MinX(), extent.MaxX())
+	cy := scale(y, extent.MinY(), extent.MaxY())
+	if curve == CurveZOrder {
+		return 1<<(2*curveBits) | zOrder(cx, cy)
+	}
+	return 1<<(2*curveBits) | Hilbert(cx, cy, curveBits)
+}
+
+// zOrder interleaves the bits of x and y
+func zOrder(x, y uint32) uint64 {
+	var d uint64
+	for i := uint(0); i < curveBits; i++ {
+		d |= uint64(x>>i&1)<<(2*i) | uint64(y>>i&1)<<(2*i+1)
+	}
+	return d
+}
+
+// Hilbert returns the distance along the Hilbert curve of the cell x, y on a grid of
+// 2^bits by 2^bits cells, it is also used to order the index of a FlatGeobuf
+func Hilbert(x, y uint32, bits uint) uint64 {
+	var d uint64
+	n := uint32(1) << bits
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint32
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += uint64(s) * uint64(s) * uint64((3*rx)^ry)
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+// envelopeCentroid returns the centroid of the envelope of the geometry, ok is false for empty geometries
+func envelopeCentroid(geometry geom.Geometry) (x, y float64, ok bool) {
+	if geometry == nil {
+		return 0, 0, false
+	}
+	extent, err := geom.NewExtentFromGeometry(geometry)
+	if err != nil {
+		return 0, 0, false
+	}
+	return (extent.MinX() + extent.MaxX()) / 2, (extent.MinY() + extent.MaxY()) / 2, true
+}
+
+type sortedFeature struct {
+	columns  []interface{}
+	geometry geom.Geometry
+}
+
+func (f sortedFeature) Columns() []interface{} {
+	return f.columns
+}
+
+func (f sortedFeature) Geometry() geom.Geometry {
+	return f.geometry
+}
+
+func (f *sortedFeature) UpdateGeometry(geometry geom.Geometry) {
+	f.geometry = geometry
+}
+
+// spilled is a feature written to disk, with the centroid of its envelope before
+// it is sorted and with its key after
+type spilled struct {
+	Key      uint64
+	X, Y     float64
+	Empty    bool
+	Columns  []interface{}
+	Geometry []byte
+}
+
+func spill(feature Feature) spilled {
+	s := spilled{Columns: feature.Columns()}
+	var ok bool
+	s.X, s.Y, ok = envelopeCentroid(feature.Geometry())
+	s.Empty = !ok
+	if feature.Geometry() != nil {
+		geometry, err := wkb.EncodeBytes(feature.Geometry())
+		if err != nil {
+			log.Fatalf("error encoding the geometry for sorting: %s", err)
+		}
+		s.Geometry = geometry
+	}
+	return s
+}
+
+func (s spilled) feature() Feature {
+	f := &sortedFeature{columns: s.Columns}
+	if len(s.Geometry) > 0 {
+		geometry, err := wkb.DecodeBytes(s.Geometry)
+		if err != nil {
+			log.Fatalf("error decoding the geometry for sorting: %s", err)
+		}
+		f.geometry = geometry
+	}
+	return f
+}
+
+// writeSpilled writes the features to a new file in the directory
+func writeSpilled(dir string, features []spilled) string {
+	f, err := os.CreateTemp(dir, `run-*`)
+	if err != nil {
+		log.Fatalf("error spilling the features to disk: %s", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+	for _, s := range features {
+		if err = enc.Encode(s); err != nil {
+			log.Fatalf("error spilling the features to disk: %s", err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatalf("error spilling the features to disk: %s", err)
+	}
+	f.Close()
+	return f.Name()
+}
+
+// runReader reads a file written by writeSpilled
+type runReader struct {
+	file    *os.File
+	dec     *gob.Decoder
+	current spilled
+	index   int
+}
+
+func openRun(file string, index int) *runReader {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatalf("error reading the spilled features: %s", err)
+	}
+	return &runReader{file: f, dec: gob.NewDecoder(bufio.NewReader(f)), index: index}
+}
+
+// next reads the next feature into current, false at the end of the run
+func (r *runReader) next() bool {
+	r.current = spilled{}
+	err := r.dec.Decode(&r.current)
+	if err == io.EOF {
+		r.file.Close()
+		return false
+	}
+	if err != nil {
+		log.Fatalf("error reading the spilled features: %s", err)
+	}
+	return true
+}
+
+// runHeap orders the runs by the key of their current feature, equal keys by the
+// order of the runs so the sort is stable
+type runHeap []*runReader
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	if h[i].current.Key != h[j].current.Key {
+		return h[i].current.Key < h[j].current.Key
+	}
+	return h[i].index < h[j].index
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// sortFeatures sorts the features by the key on the curve of the centroid of their envelope,
+// scaled to the extent of the table. The features are kept in memory up to the buffer size,
+// larger tables are spilled to disk unsorted while the extent is collected, after that
+// every spilled part is sorted into a run and the runs are merged.
+func sortFeatures(in chan Feature, out chan Feature, options Sort, table string, stage *StageBlocking) {
+	var extent *geom.Extent
+	var buffer []spilled
+	var features []Feature
+	var spilledFiles []string
+	dir := ``
+
+	for {
+		feature, hasMore := stage.receive(in)
+		if !hasMore {
+			break
+		}
+		x, y, ok := envelopeCentroid(feature.Geometry())
+		if ok {
+			if extent == nil {
+				extent = geom.NewExtent([2]float64{x, y})
+			} else {
+				extent.AddPoints([2]float64{x, y})
+			}
+		}
+		features = append(features, feature)
+		if len(features) == options.Buffer {
+			if dir == `` {
+				var err error
+				if dir, err = os.MkdirTemp(``, `sieve-sort-`); err != nil {
+					log.Fatalf("error spilling the features to disk: %s", err)
+				}
+				defer os.RemoveAll(dir)
+			}
+			buffer = buffer[:0]
+			for _, f := range features {
+				buffer = append(buffer, spill(f))
+			}
+			spilledFiles = append(spilledFiles, writeSpilled(dir, buffer))
+			features = nil
+		}
+	}
+
+	fid := int64(0)
+	send := func(feature Feature) {
+		if options.Renumber {
+			fid++
+			columns := append([]interface{}{}, feature.Columns()...)
+			columns[options.PrimaryKey] = fid
+			feature = &sortedFeature{columns: columns, geometry: feature.Geometry()}
+		}
+		stage.send(out, feature)
+	}
+	keyOf := func(x, y float64, empty bool) uint64 {
+		if empty {
+			return emptyKey
+		}
+		return options.Curve.key(x, y, extent)
+	}
+
+	if len(spilledFiles) == 0 {
+		keys := make([]uint64, len(features))
+		order := make([]int, len(features))
+		for i, f := range features {
+			x, y, ok := envelopeCentroid(f.Geometry())
+			keys[i] = keyOf(x, y, !ok)
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+		for _, i := range order {
+			send(features[i])
+		}
+		close(out)
+		return
+	}
+
+	if len(features) > 0 {
+		buffer = buffer[:0]
+		for _, f := range features {
+			buffer = append(buffer, spill(f))
+		}
+		spilledFiles = append(spilledFiles, writeSpilled(dir, buffer))
+		features = nil
+	}
+
+	// every spilled part becomes a sorted run
+	runs := make(runHeap, 0, len(spilledFiles))
+	for i, file := range spilledFiles {
+		buffer = buffer[:0]
+		r := openRun(file, i)
+		for r.next() {
+			s := r.current
+			s.Key = keyOf(s.X, s.Y, s.Empty)
+			buffer = append(buffer, s)
+		}
+		os.Remove(file)
+		sort.SliceStable(buffer, func(i, j int) bool { return buffer[i].Key < buffer[j].Key })
+		run := openRun(writeSpilled(dir, buffer), i)
+		if run.next() {
+			runs = append(runs, run)
+		}
+	}
+	buffer = nil
+
+	heap.Init(&runs)
+	for runs.Len() > 0 {
+		r := runs[0]
+		send(r.current.feature())
+		if r.next() {
+			heap.Fix(&runs, 0)
+		} else {
+			heap.Pop(&runs)
+		}
+	}
+	close(out)
+	logging.Counts(`sorted`, table, `sort`, `sorted_runs`, len(spilledFiles))
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-spatial/geom"
+)
+
+func TestCurveKey(t *testing.T) {
+	extent := geom.NewExtent([2]float64{0, 0}, [2]float64{10, 10})
+	corners := [][2]float64{{0, 0}, {0, 10}, {10, 10}, {10, 0}}
+
+	var tests = []struct {
+		curve    Curve
+		expected []int
+	}{
+		// 0 the Hilbert curve visits the corners in order
+		{curve: CurveHilbert, expected: []int{0, 1, 2, 3}},
+		// 1 the Z-order curve visits them in a Z
+		{curve: CurveZOrder, expected: []int{0, 2, 3, 1}},
+	}
+
+	for k, test := range tests {
+		var got []int
+		for i := range corners {
+			rank := 0
+			key := test.curve.key(corners[i][0], corners[i][1], extent)
+			for j := range corners {
+				if test.curve.key(corners[j][0], corners[j][1], extent) < key {
+					rank++
+				}
+			}
+			got = append(got, rank)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
+
+func TestZOrder(t *testing.T) {
+	var tests = []struct {
+		x, y     uint32
+		expected uint64
+	}{
+		// 0
+		{x: 1, y: 0, expected: 1},
+		// 1
+		{x: 0, y: 1, expected: 2},
+		// 2
+		{x: 3, y: 3, expected: 15},
+	}
+
+	for k, test := range tests {
+		if got := zOrder(test.x, test.y); got != test.expected {
+			t.Errorf("test: %d, expected: %d \ngot: %d", k, test.expected, got)
+		}
+	}
+}
+
+func TestHilbert(t *testing.T) {
+	var tests = []struct {
+		x, y     uint32
+		bits     uint
+		expected uint64
+	}{
+		// 0 the cells of a 2 by 2 grid
+		{x: 0, y: 1, bits: 1, expected: 1},
+		// 1
+		{x: 1, y: 0, bits: 1, expected: 3},
+		// 2 the values of the index of a FlatGeobuf
+		{x: 65535, y: 0, bits: 16, expected: 4294967295},
+		// 3
+		{x: 12345, y: 54321, bits: 16, expected: 1555040834},
+	}
+
+	for k, test := range tests {
+		if got := Hilbert(test.x, test.y, test.bits); got != test.expected {
+			t.Errorf("test: %d, expected: %d \ngot: %d", k, test.expected, got)
+		}
+	}
+}
+
+func TestSortFeatures(t *testing.T) {
+	date := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	input := []struct {
+		name     string
+		geometry geom.Geometry
+	}{
+		{name: `a`, geometry: geom.Point{9, 9}},
+		{name: `b`, geometry: geom.Polygon{{{0, 0}, {1, 0}, {1, 1}}}},
+		{name: `c`},
+		{name: `d`, geometry: geom.Point{9, 0}},
+		{name: `e`, geometry: geom.Point{0, 9}},
+	}
+
+	var tests = []struct {
+		sort     Sort
+		expected []string
+	}{
+		// 0 in memory, features without geometry come first
+		{sort: Sort{Curve: CurveHilbert}, expected: []string{`c`, `b`, `e`, `a`, `d`}},
+		// 1 spilled to disk in runs of 2 features
+		{sort: Sort{Curve: CurveHilbert, Buffer: 2}, expected: []string{`c`, `b`, `e`, `a`, `d`}},
+		// 2
+		{sort: Sort{Curve: CurveZOrder, Buffer: 2, Renumber: true}, expected: []string{`c`, `b`, `d`, `e`, `a`}},
+	}
+
+	for k, test := range tests {
+		in := make(chan Feature)
+		out := make(chan Feature)
+		go func() {
+			for i, f := range input {
+				in <- &sortedFeature{columns: []interface{}{int64(i + 1), f.name, nil, date}, geometry: f.geometry}
+			}
+			close(in)
+		}()
+		go sortFeatures(in, out, test.sort, ``, &StageBlocking{})
+
+		var got []string
+		for f := range out {
+			columns := f.Columns()
+			got = append(got, columns[1].(string))
+			if columns[2] != nil || columns[3] != date {
+				t.Errorf("test: %d, expected the columns to be kept \ngot: %v", k, columns)
+			}
+			if test.sort.Renumber && columns[0] != int64(len(got)) {
+				t.Errorf("test: %d, expected: fid %d \ngot: %v", k, len(got), columns[0])
+			}
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/go-spatial/geom"
+	"github.com/go-spatial/geom/cmp"
+	"github.com/go-spatial/geom/planar/intersect"
+	"github.com/pdok/sieve/pkg/logging"
+	"github.com/pdok/sieve/pkg/metrics"
+)
+
+// Validation determines what happens with features that have an invalid geometry
+type Validation string
+
+const (
+	// ValidationNone skips the validation, this is the default
+	ValidationNone Validation = `none`
+	// ValidationRepair repairs invalid geometries, features that cannot be repaired are rejected
+	ValidationRepair Validation = `repair`
+	// ValidationReject rejects features with an invalid geometry
+	ValidationReject Validation = `reject`
+)
+
+// ParseValidation validates the given string as a Validation, an empty string results in ValidationNone
+func ParseValidation(validation string) (Validation, error) {
+	switch Validation(validation) {
+	case ``, ValidationNone:
+		return ValidationNone, nil
+	case ValidationRepair, ValidationReject:
+		return Validation(validation), nil
+	}
+	return ``, fmt.Errorf("unknown validation: %s", validation)
+}
+
+// RejectedFeature is a feature rejected by the validation that is passed on with KeepRejected,
+// the target leaves the feature as it is
+type RejectedFeature struct {
+	Feature
+}
+
+// validateFeatures validates the (MULTI)POLYGON geometries of the sieved features.
+// Before the validation the rings are closed and oriented, invalid geometries
+// are repaired or rejected based on the options
+func validateFeatures(postSieve chan Feature, validated chan Feature, options Options, stage *StageBlocking) {
+	var invalidCount, repairedCount, rejectedCount uint64
+	logger := slog.With(`table`, options.Table, `stage`, `validate`)
+	rejected := metrics.FeaturesRejected.WithLabelValues(options.Table, options.zoomLabel())
+	for {
+		feature, hasMore := stage.receive(postSieve)
+		if !hasMore {
+			break
+		}
+
+		var geometry geom.Geometry
+		var err error
+		switch g := feature.Geometry().(type) {
+		case geom.Polygon:
+			geometry, err = validatePolygon(g)
+		case geom.MultiPolygon:
+			geometry, err = validateMultiPolygon(g)
+		case geom.Collection:
+			geometry, err = validateCollection(g)
+		default:
+			stage.send(validated, feature)
+			continue
+		}
+
+		if err != nil {
+			invalidCount++
+			if options.Validation == ValidationRepair {
+				var repaired geom.Geometry
+				if repaired, err = repair(geometry); err == nil {
+					repairedCount++
+					feature.UpdateGeometry(repaired)
+					stage.send(validated, feature)
+					continue
+				}
+			}
+			rejectedCount++
+			rejected.Inc()
+			logger.Debug(`feature rejected`, `reason`, err)
+			if options.Rejects != nil {
+				options.Rejects.Reject(feature, err)
+			}
+			if options.KeepRejected {
+				stage.send(validated, RejectedFeature{feature})
+			}
+			continue
+		}
+		feature.UpdateGeometry(geometry)
+		stage.send(validated, feature)
+	}
+	close(validated)
+
+	counts := []any{`invalid`, invalidCount}
+	if options.Validation == ValidationRepair {
+		counts = append(counts, `repaired`, repairedCount)
+	}
+	counts = append(counts, `rejected`, rejectedCount)
+	logging.Counts(`validated`, options.Table, `validate`, counts...)
+}
+
+// validatePolygon normalizes the POLYGON and returns the reason when it isn't valid
+func validatePolygon(p geom.Polygon) (geom.Polygon, error) {
+	p = normalizePolygon(p)
+	return p, polygonValid(p)
+}
+
+// validateMultiPolygon normalizes the parts of the MULTIPOLYGON and returns the reason when
+// one of them isn't valid, overlap between the parts isn't checked
+func validateMultiPolygon(mp geom.MultiPolygon) (geom.MultiPolygon, error) {
+	var validated geom.MultiPolygon
+	var reason error
+	for i, p := range mp {
+		p = normalizePolygon(p)
+		if err := polygonValid(p); err != nil && reason == nil {
+			reason = fmt.Errorf("part %d: %w", i, err)
+		}
+		validated = append(validated, p)
+	}
+	return validated, reason
+}
+
+// validateCollection validates the (MULTI)POLYGON members of a GEOMETRYCOLLECTION
+func validateCollection(c geom.Collection) (geom.Collection, error) {
+	var validated geom.Collection
+	var reason error
+	for i, member := range c {
+		var err error
+		switch g := member.(type) {
+		case geom.Polygon:
+			member, err = validatePolygon(g)
+		case geom.MultiPolygon:
+			member, err = validateMultiPolygon(g)
+		case geom.Collection:
+			member, err = validateCollection(g)
+		}
+		if err != nil && reason == nil {
+			reason = fmt.Errorf("member %d: %w", i, err)
+		}
+		validated = append(validated, member)
+	}
+	return validated, reason
+}
+
+// normalizePolygon closes the rings, removes repeated points and
+// orients the exterior counter-clockwise and the interiors clockwise
+func normalizePolygon(p geom.Polygon) geom.Polygon {
+	var normalized geom.Polygon
+	for _, ring := range p {
+		normalized = append(normalized, closeRing(ring))
+	}
+	return orientPolygon(normalized, OrientationOGC)
+}
+
+// closeRing returns the ring without repeated points and with the closing point
+func closeRing(pts [][2]float64) [][2]float64 {
+	var ring [][2]float64
+	for _, pt := range pts {
+		if len(ring) == 0 || ring[len(ring)-1] != pt {
+			ring = append(ring, pt)
+		}
+	}
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		ring = append(ring, ring[0])
+	}
+	return ring
+}
+
+// ReverseRing returns the ring in the opposite direction
+func ReverseRing(pts [][2]float64) [][2]float64 {
+	reversed := make([][2]float64, len(pts))
+	for i, pt := range pts {
+		reversed[len(pts)-1-i] = pt
+	}
+	return reversed
+}
+
+// polygonValid checks a normalized POLYGON against the OGC Simple Features rules:
+// - rings have at least four points
+// - rings don't intersect themselves
+// - rings don't cross each other and touch each other at most at a single point
+// - interior rings lie within the exterior ring
+func polygonValid(p geom.Polygon) error {
+	if len(p) == 0 {
+		return errors.New("empty polygon")
+	}
+
+	var segments []geom.Line
+	// rings and positions are the ring of every segment and its position in that ring
+	var rings, positions []int
+	for i, ring := range p {
+		if len(ring) < 4 {
+			if i == 0 {
+				return errors.New("too few points in exterior ring")
+			}
+			return fmt.Errorf("too few points in interior ring %d", i)
+		}
+		for j := 1; j < len(ring); j++ {
+			segments = append(segments, geom.Line{ring[j-1], ring[j]})
+			rings = append(rings, i)
+			positions = append(positions, j-1)
+		}
+	}
+	// consecutive determines if two segments follow each other in the same ring, sharing a vertex
+	consecutive := func(src, dest int) bool {
+		if rings[src] != rings[dest] {
+			return false
+		}
+		last := len(p[rings[src]]) - 2
+		a, b := positions[src], positions[dest]
+		return a-b == 1 || b-a == 1 || (a == 0 && b == last) || (b == 0 && a == last)
+	}
+
+	var reason error
+	touches := make(map[[2]int]map[[2]float64]struct{})
+	eq := intersect.NewEventQueue(segments)
+	// all intersections are reported, also at shared vertices, only the vertex shared by
+	// consecutive segments of a ring is expected
+	err := eq.FindIntersects(context.Background(), false, func(src, dest int, pt [2]float64) error {
+		if consecutive(src, dest) && sharedVertex(segments[src], segments[dest], pt) {
+			return nil
+		}
+		r1, r2 := rings[src], rings[dest]
+		if r1 == r2 {
+			reason = fmt.Errorf("self-intersection of ring %d at %v", r1, pt)
+			return intersect.ErrStopIteration
+		}
+		v, ok := vertex(segments[src], pt)
+		if !ok {
+			if v, ok = vertex(segments[dest], pt); !ok {
+				reason = fmt.Errorf("ring %d crosses ring %d at %v", r1, r2, pt)
+				return intersect.ErrStopIteration
+			}
+		}
+		if r1 > r2 {
+			r1, r2 = r2, r1
+		}
+		if touches[[2]int{r1, r2}] == nil {
+			touches[[2]int{r1, r2}] = make(map[[2]float64]struct{})
+		}
+		touches[[2]int{r1, r2}][v] = struct{}{}
+		if len(touches[[2]int{r1, r2}]) > 1 {
+			reason = fmt.Errorf("ring %d touches ring %d at more than one point", r1, r2)
+			return intersect.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if reason != nil {
+		return reason
+	}
+
+	for i, interior := range p[1:] {
+		if !ringContains(p[0], interior) {
+			return fmt.Errorf("interior ring %d outside the exterior ring", i+1)
+		}
+	}
+	return nil
+}
+
+// sharedVertex determines if the point is an end point of both segments
+func sharedVertex(s1 geom.Line, s2 geom.Line, pt [2]float64) bool {
+	_, ok1 := vertex(s1, pt)
+	_, ok2 := vertex(s2, pt)
+	return ok1 && ok2
+}
+
+// vertex returns the end point of the segment that is equal to the given point
+func vertex(segment geom.Line, pt [2]float64) ([2]float64, bool) {
+	for _, v := range segment {
+		if cmp.PointEqual(v, pt) {
+			return v, true
+		}
+	}
+	return pt, false
+}
+
+// ringContains checks if the inner ring lies within the outer ring, the
+// rings don't cross so one point not on the outer ring is sufficient
+func ringContains(outer [][2]float64, inner [][2]float64) bool {
+	for _, pt := range inner {
+		if inside, onBoundary := PointInRing(outer, pt); !onBoundary {
+			return inside
+		}
+	}
+	return true
+}
+
+// PointInRing determines with ray casting if the point lies inside the ring
+// https://en.wikipedia.org/wiki/Point_in_polygon#Ray_casting_algorithm
+func PointInRing(ring [][2]float64, pt [2]float64) (inside bool, onBoundary bool) {
+	p0 := ring[len(ring)-1]
+	for _, p1 := range ring {
+		cross := (p1[0]-p0[0])*(pt[1]-p0[1]) - (pt[0]-p0[0])*(p1[1]-p0[1])
+		if cross == 0 &&
+			pt[0] >= math.Min(p0[0], p1[0]) && pt[0] <= math.Max(p0[0], p1[0]) &&
+			pt[1] >= math.Min(p0[1], p1[1]) && pt[1] <= math.Max(p0[1], p1[1]) {
+			return false, true
+		}
+		if (p0[1] > pt[1]) != (p1[1] > pt[1]) &&
+			pt[0] < (p1[0]-p0[0])*(pt[1]-p0[1])/(p1[1]-p0[1])+p0[0] {
+			inside = !inside
+		}
+		p0 = p1
+	}
+	return inside, false
+}
+
+// repair makes a (MULTI)POLYGON valid, a POLYGON can only be repaired if the result is a single POLYGON.
+// The (MULTI)POLYGON members of a GEOMETRYCOLLECTION are repaired separately
+func repair(geometry geom.Geometry) (geom.Geometry, error) {
+	if c, ok := geometry.(geom.Collection); ok {
+		var repaired geom.Collection
+		for _, member := range c {
+			switch member.(type) {
+			case geom.Polygon, geom.MultiPolygon, geom.Collection:
+				r, err := repair(member)
+				if err != nil {
+					return nil, err
+				}
+				repaired = append(repaired, r)
+			default:
+				repaired = append(repaired, member)
+			}
+		}
+		return repaired, nil
+	}
+
+	var polygons []geom.Polygon
+	switch g := geometry.(type) {
+	case geom.Polygon:
+		polygons = append(polygons, g)
+	case geom.MultiPolygon:
+		for _, p := range g {
+			polygons = append(polygons, p)
+		}
+	}
+	var repaired geom.MultiPolygon
+	for _, p := range repairPolygons(polygons) {
+		repaired = append(repaired, normalizePolygon(p))
+	}
+	if len(repaired) == 0 {
+		return nil, errors.New("repair resulted in an empty geometry")
+	}
+
+	if _, ok := geometry.(geom.Polygon); ok {
+		if len(repaired) > 1 {
+			return nil, fmt.Errorf("repair resulted in a MULTIPOLYGON with %d parts", len(repaired))
+		}
+		return repaired[0], nil
+	}
+	return repaired, nil
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-spatial/geom"
+)
+
+func TestNormalizePolygon(t *testing.T) {
+	var tests = []struct {
+		geom       [][][2]float64
+		normalized [][][2]float64
+	}{
+		// Counter-clockwise exterior stays the same
+		0: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}, normalized: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}},
+		// Clockwise exterior is reversed
+		1: {geom: [][][2]float64{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}, normalized: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}},
+		// Missing closing point and repeated points
+		2: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 0}, {10, 10}, {0, 10}}}, normalized: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}},
+		// Counter-clockwise interior is reversed
+		3: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{2, 2}, {8, 2}, {8, 8}, {2, 8}, {2, 2}}}, normalized: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{2, 2}, {2, 8}, {8, 8}, {8, 2}, {2, 2}}}},
+	}
+
+	for k, test := range tests {
+		normalized := normalizePolygon(test.geom)
+		if len(normalized) != len(test.normalized) {
+			t.Errorf("test: %d, expected: %f \ngot: %f", k, test.normalized, normalized)
+			continue
+		}
+		for i := range normalized {
+			if len(normalized[i]) != len(test.normalized[i]) {
+				t.Errorf("test: %d, expected: %f \ngot: %f", k, test.normalized, normalized)
+				break
+			}
+			for j := range normalized[i] {
+				if normalized[i][j] != test.normalized[i][j] {
+					t.Errorf("test: %d, expected: %f \ngot: %f", k, test.normalized, normalized)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestPolygonValid(t *testing.T) {
+	var tests = []struct {
+		geom  [][][2]float64
+		valid bool
+	}{
+		// Rectangle
+		0: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}, valid: true},
+		// Rectangle with hole
+		1: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{2, 2}, {2, 8}, {8, 8}, {8, 2}, {2, 2}}}, valid: true},
+		// Bow tie
+		2: {geom: [][][2]float64{{{0, 0}, {10, 10}, {10, 0}, {0, 10}, {0, 0}}}, valid: false},
+		// Too few points
+		3: {geom: [][][2]float64{{{0, 0}, {10, 0}, {0, 0}}}, valid: false},
+		// Hole crossing the exterior
+		4: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{5, 5}, {5, 15}, {15, 15}, {15, 5}, {5, 5}}}, valid: false},
+		// Hole outside the exterior
+		5: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{20, 20}, {20, 25}, {25, 25}, {25, 20}, {20, 20}}}, valid: false},
+		// Hole touching the exterior at a single point
+		6: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{0, 5}, {5, 8}, {5, 2}, {0, 5}}}, valid: true},
+		// Hole touching the exterior at two points
+		7: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{0, 5}, {5, 10}, {5, 2}, {0, 5}}}, valid: false},
+		// Empty polygon
+		8: {geom: nil, valid: false},
+		// Hole touching the exterior at two of its vertices
+		9: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{0, 0}, {5, 4}, {10, 10}, {4, 5}, {0, 0}}}, valid: false},
+		// Hole touching the exterior at a single vertex
+		10: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{0, 0}, {5, 4}, {4, 5}, {0, 0}}}, valid: true},
+		// Figure-eight exterior touching itself at a vertex
+		11: {geom: [][][2]float64{{{0, 0}, {0, 2}, {1, 1}, {2, 2}, {2, 0}, {1, 1}, {0, 0}}}, valid: false},
+		// Two holes sharing an edge
+		12: {geom: [][][2]float64{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, {{2, 2}, {2, 8}, {5, 8}, {5, 2}, {2, 2}}, {{5, 2}, {5, 8}, {8, 8}, {8, 2}, {5, 2}}}, valid: false},
+	}
+
+	for k, test := range tests {
+		err := polygonValid(normalizePolygon(test.geom))
+		if test.valid && err != nil {
+			t.Errorf("test: %d, expected a valid polygon \ngot: %s", k, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("test: %d, expected an invalid polygon", k)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	var tests = []struct {
+		geom  geom.Geometry
+		parts int
+		area  float64
+	}{
+		// Bow tie as POLYGON cannot be repaired into a single POLYGON
+		0: {geom: geom.Polygon{{{0, 0}, {10, 10}, {10, 0}, {0, 10}, {0, 0}}}, parts: 0},
+		// Bow tie as MULTIPOLYGON results in two parts
+		1: {geom: geom.MultiPolygon{{{{0, 0}, {10, 10}, {10, 0}, {0, 10}, {0, 0}}}}, parts: 2, area: 50},
+	}
+
+	for k, test := range tests {
+		repaired, err := repair(test.geom)
+		if test.parts == 0 {
+			if err == nil {
+				t.Errorf("test: %d, expected an error \ngot: %v", k, repaired)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test: %d, expected no error \ngot: %s", k, err)
+			continue
+		}
+		mp := repaired.(geom.MultiPolygon)
+		total := 0.
+		for _, p := range mp {
+			total = total + area(p)
+		}
+		if len(mp) != test.parts || total != test.area {
+			t.Errorf("test: %d, expected: %d parts with area %f \ngot: %f", k, test.parts, test.area, mp)
+		}
+		if err = polygonValid(mp[0]); err != nil {
+			t.Errorf("test: %d, expected a valid polygon \ngot: %s", k, err)
+		}
+	}
+}
+
+func TestKeepRejected(t *testing.T) {
+	invalid := &testFeature{geometry: geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}, {{20, 20}, {20, 25}, {25, 25}, {25, 20}, {20, 20}}}}
+	valid := &testFeature{geometry: geom.Polygon{{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}}}
+
+	for k, keep := range []bool{false, true} {
+		postSieve := make(chan Feature, 2)
+		validated := make(chan Feature, 2)
+		postSieve <- invalid
+		postSieve <- valid
+		close(postSieve)
+		validateFeatures(postSieve, validated, Options{Validation: ValidationReject, KeepRejected: keep}, &StageBlocking{Stage: `validate`})
+
+		var got []Feature
+		for f := range validated {
+			got = append(got, f)
+		}
+		expected := []Feature{valid}
+		if keep {
+			expected = []Feature{RejectedFeature{invalid}, valid}
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("test: %d, expected: %v \ngot: %v", k, expected, got)
+		}
+	}
+}